@@ -0,0 +1,204 @@
+// portalctl 直接向NAS发送Portal报文，用于现场排查问题
+//
+//	portalctl challenge -nas 192.168.10.1 -secret syler -userip 192.168.10.3
+//	portalctl auth -nas 192.168.10.1 -secret syler -userip 192.168.10.3 -username 13800138000 -password 123456 [-pap]
+//	portalctl logout -nas 192.168.10.1 -secret syler -userip 192.168.10.3
+//	portalctl info -nas 192.168.10.1 -secret syler -userip 192.168.10.3
+//	portalctl dump -r portal.pcap [-secret syler]
+//	portalctl replay -r portal.pcap [-nas 127.0.0.1 -port 2000 | -sim] -secret syler
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"syler/internal/capture"
+	"syler/internal/portal"
+	v1 "syler/internal/portal/v1"
+	v2 "syler/internal/portal/v2"
+)
+
+const usage = `usage: portalctl <challenge|auth|logout|info|dump|replay> [flags]
+
+Run "portalctl <command> -h" for the flags of a command.
+`
+
+type options struct {
+	nas      net.IP
+	port     int
+	secret   string
+	userip   net.IP
+	username string
+	password string
+	pap      bool
+	vendor   *portal.Vendor
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Print(usage)
+		os.Exit(2)
+	}
+	cmd := os.Args[1]
+	switch cmd {
+	case "challenge", "auth", "logout", "info":
+		os.Exit(send(cmd, os.Args[2:]))
+	case "dump":
+		os.Exit(dump(os.Args[2:]))
+	case "replay":
+		os.Exit(replay(os.Args[2:]))
+	default:
+		fmt.Print(usage)
+		os.Exit(2)
+	}
+}
+
+// send 向NAS发送一个请求，返回退出码。返回前关闭抓包文件
+func send(cmd string, args []string) int {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	nas := fs.String("nas", "", "NAS IP address")
+	port := fs.Int("port", 2000, "NAS portal port")
+	secret := fs.String("secret", "", "shared secret")
+	version := fs.Int("version", 2, "portal protocol version (1 or 2)")
+	listen := fs.String("listen", "0.0.0.0:50100", "local address the NAS answers to")
+	userip := fs.String("userip", "", "user IP address")
+	username := fs.String("username", "", "user name (auth)")
+	password := fs.String("password", "", "user password (auth)")
+	pap := fs.Bool("pap", false, "use PAP instead of CHAP (auth)")
+	timeout := fs.Duration("timeout", 3*time.Second, "time to wait for each answer")
+	retries := fs.Int("retries", 0, "retransmissions when the NAS does not answer")
+	pcapFile := fs.String("w", "", "write the exchanged packets to a pcap file")
+	vendorName := fs.String("vendor", "huawei", "portal dialect: huawei, h3c, ruijie, zte")
+	basip := fs.String("basip", "", "BAS-IP attribute for dialects that require it, defaults to -nas")
+	fs.Parse(args)
+
+	opts := options{
+		nas:      net.ParseIP(*nas),
+		port:     *port,
+		secret:   *secret,
+		userip:   net.ParseIP(*userip),
+		username: *username,
+		password: *password,
+		pap:      *pap,
+	}
+	if opts.nas == nil || opts.userip == nil {
+		fmt.Println("-nas and -userip must be valid IP addresses")
+		return 2
+	}
+	vendor, err := portal.VendorByName(*vendorName)
+	if err != nil {
+		fmt.Println(err)
+		return 2
+	}
+	opts.vendor = vendor
+
+	if *version == 1 {
+		portal.SetVersion(new(v1.Version))
+	} else {
+		portal.SetVersion(new(v2.Version))
+	}
+	portal.RegisterNas(portal.Nas{
+		IP:            opts.nas,
+		Port:          opts.port,
+		Secret:        opts.secret,
+		Retries:       *retries,
+		RetryInterval: *timeout,
+		Vendor:        vendor,
+		BasIP:         net.ParseIP(*basip),
+	})
+	portal.RegisterFallBack(func(m portal.Message, src net.IP) {
+		fmt.Printf("<- unsolicited message from %s\n", src)
+		show(opts.vendor, m)
+	})
+	if *pcapFile != "" {
+		f, err := os.Create(*pcapFile)
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		defer f.Close()
+		defer portal.SetTap(nil)
+		w, err := capture.NewWriter(f)
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		portal.SetTap(func(src, dst *net.UDPAddr, data []byte) {
+			w.Write(&capture.Packet{Src: src, Dst: dst, Payload: data})
+		})
+	}
+	go portal.ListenAndService(*listen)
+	for !portal.Bound() {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	switch cmd {
+	case "challenge":
+		_, err = exchange(portal.Ver.NewChallenge(opts.userip, opts.secret), opts, true)
+	case "auth":
+		err = auth(opts)
+	case "logout":
+		_, err = exchange(portal.Ver.NewLogout(opts.userip, opts.secret), opts, true)
+	case "info":
+		_, err = exchange(portal.Ver.NewReqInfo(opts.userip, opts.secret), opts, true)
+	}
+	if err != nil {
+		return 1
+	}
+	return 0
+}
+
+func auth(opts options) error {
+	username, password := []byte(opts.username), []byte(opts.password)
+	var req portal.Message
+	if opts.pap {
+		req = portal.Ver.NewPapAuth(opts.userip, opts.secret, username, password)
+	} else {
+		res, err := exchange(portal.Ver.NewChallenge(opts.userip, opts.secret), opts, true)
+		if err != nil {
+			return err
+		}
+		cres, ok := res.(portal.ChallengeRes)
+		if !ok {
+			return fmt.Errorf("no challenge in response")
+		}
+		req = portal.Ver.NewAuth(opts.userip, opts.secret, username, password, res.ReqId(), cres.GetChallenge())
+	}
+	res, err := exchange(req, opts, true)
+	if err != nil {
+		return err
+	}
+	_, err = exchange(portal.Ver.NewAffAckAuth(opts.userip, opts.secret, res.SerialId(), res.ReqId()), opts, false)
+	return err
+}
+
+// exchange 发送请求并打印请求和应答
+func exchange(req portal.Message, opts options, sync bool) (portal.Message, error) {
+	fmt.Printf("-> %s:%d\n", opts.nas, opts.port)
+	res, err := portal.Send(context.Background(), req, opts.nas, opts.port, opts.secret, sync)
+	// Send会按方言补充属性，发送后再打印请求
+	show(opts.vendor, req)
+	if res != nil {
+		fmt.Printf("<- %s:%d\n", opts.nas, opts.port)
+		show(opts.vendor, res)
+	}
+	var perr *portal.Error
+	switch {
+	case errors.As(err, &perr):
+		fmt.Printf("error: code=%d %s (retryable: %v)\n\n", perr.Code, perr.Desc, perr.Retryable)
+	case err != nil:
+		fmt.Printf("error: %s\n\n", err)
+	}
+	return res, err
+}
+
+func show(vendor *portal.Vendor, m portal.Message) {
+	fmt.Print(vendor.Format(m))
+	fmt.Println(hex.Dump(m.Bytes()))
+}
@@ -6,6 +6,7 @@ import (
 	"os/signal"
 	"syscall"
 
+	"syler/internal/audit"
 	"syler/internal/logger"
 	"syler/internal/server"
 
@@ -21,18 +22,29 @@ func main() {
 	viper.AddConfigPath("$HOME/.syler")
 	viper.AddConfigPath(".")
 
+	viper.SetDefault("logging.format", "text")
+	viper.SetDefault("logging.stdout", true)
+	viper.SetDefault("cluster.prefix", "syler:")
+	viper.SetDefault("cluster.leader_ttl", "15s")
+	viper.SetDefault("web.enabled", true)
+	viper.SetDefault("http.csrf", true)
+	viper.SetDefault("http.tls.reload_interval", "1m")
+
 	if err := viper.ReadInConfig(); err != nil {
 		fmt.Printf("Error reading config file: %s\n", err)
 		os.Exit(1)
 	}
 
 	// Initialize logger
-	err := logger.Init(
-		viper.GetString("logging.file"),
-		viper.GetString("logging.level"),
-		viper.GetInt("logging.max_size"),
-		viper.GetInt("logging.max_backups"),
-	)
+	err := logger.Init(logger.Config{
+		File:       viper.GetString("logging.file"),
+		Level:      viper.GetString("logging.level"),
+		Format:     viper.GetString("logging.format"),
+		MaxSize:    viper.GetInt("logging.max_size"),
+		MaxBackups: viper.GetInt("logging.max_backups"),
+		Stdout:     viper.GetBool("logging.stdout"),
+		Levels:     viper.GetStringMapString("logging.levels"),
+	})
 	if err != nil {
 		fmt.Printf("Error initializing logger: %s\n", err)
 		os.Exit(1)
@@ -40,8 +52,30 @@ func main() {
 
 	log := logger.GetLogger()
 
+	// Initialize audit trail
+	err = audit.Init(audit.Config{
+		File:          viper.GetString("audit.file"),
+		MaxSize:       viper.GetInt("audit.max_size"),
+		MaxBackups:    viper.GetInt("audit.max_backups"),
+		RetentionDays: viper.GetInt("audit.retention_days"),
+		QueueSize:     viper.GetInt("audit.queue_size"),
+		Syslog: audit.SyslogConfig{
+			Network:  viper.GetString("audit.syslog.network"),
+			Addr:     viper.GetString("audit.syslog.addr"),
+			Facility: viper.GetInt("audit.syslog.facility"),
+			AppName:  viper.GetString("audit.syslog.app_name"),
+		},
+	})
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+		}).Error("Failed to initialize audit log")
+	}
+	defer audit.Close()
+
 	// Initialize basic components
 	server.InitAuthenticator()
+	defer server.CloseAuthenticator()
 
 	// Handle graceful shutdown
 	shutdown := make(chan struct{})
@@ -56,11 +90,18 @@ func main() {
 		close(shutdown)
 	}()
 
+	// Share sessions and forward portal responses between instances
+	server.StartCluster(shutdown)
+
 	// Start portal server
 	go server.StartPortal()
+	defer server.StopCapture()
 
 	// Start HTTP server
 	go server.StartHttp()
 
+	// Log out users whose heartbeats stopped
+	go server.StartSweeper(shutdown)
+
 	<-shutdown
 }
@@ -0,0 +1,150 @@
+// 软件NAS模拟器，按华为Portal协议应答syler的请求，用于本地联调
+//
+//	go run ./cmds/test -listen 127.0.0.1:2000 -version 2 -secret syler -user 13800138000:123456
+//
+// 运行后可在标准输入中输入命令：
+//
+//	list                              查看在线用户
+//	logout <userip>                   向Portal Server发送NTF_LOGOUT
+//	fault <type> [errcode=N] [delay=1s] [drop=N] [text=...]
+//	                                  对challenge/auth/logout/info请求注入故障
+//	clear <type>                      清除故障
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"syler/internal/portal"
+	"syler/internal/simulator"
+)
+
+type userFlag map[string]string
+
+func (u userFlag) String() string {
+	return fmt.Sprint(map[string]string(u))
+}
+
+func (u userFlag) Set(s string) error {
+	name, pwd, ok := strings.Cut(s, ":")
+	if !ok {
+		return fmt.Errorf("user must be name:password")
+	}
+	u[name] = pwd
+	return nil
+}
+
+var reqTypes = map[string]byte{
+	"challenge": portal.REQ_CHALLENGE,
+	"auth":      portal.REQ_AUTH,
+	"logout":    portal.REQ_LOGOUT,
+	"info":      portal.REQ_INFO,
+}
+
+func main() {
+	users := make(userFlag)
+	listen := flag.String("listen", "127.0.0.1:2000", "NAS listen address")
+	version := flag.Int("version", 2, "portal protocol version (1 or 2)")
+	secret := flag.String("secret", "syler", "shared secret")
+	portalAddr := flag.String("portal", "", "portal server address for NTF_LOGOUT, defaults to the last request source")
+	flag.Var(users, "user", "accepted user as name:password, repeatable; accept anyone when omitted")
+	flag.Parse()
+
+	cfg := simulator.Config{Addr: *listen, Version: *version, Secret: *secret}
+	if len(users) > 0 {
+		cfg.Users = users
+	}
+	nas := simulator.New(cfg)
+	if err := nas.Start(); err != nil {
+		fmt.Printf("Error starting NAS simulator: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("NAS simulator (portal v%d) listening on %s\n", *version, nas.Addr())
+
+	var dest *net.UDPAddr
+	if *portalAddr != "" {
+		var err error
+		if dest, err = net.ResolveUDPAddr("udp", *portalAddr); err != nil {
+			fmt.Printf("Invalid portal address: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		if err := command(nas, dest, strings.Fields(scanner.Text())); err != nil {
+			fmt.Println("error:", err)
+		}
+	}
+	// 标准输入关闭后继续提供服务
+	select {}
+}
+
+func command(nas *simulator.Nas, dest *net.UDPAddr, args []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+	switch args[0] {
+	case "list":
+		for _, s := range nas.Sessions() {
+			fmt.Printf("%-16s %-20s %s\n", s.UserIP, s.Username, s.LoginAt.Format("2006-01-02 15:04:05"))
+		}
+	case "logout":
+		if len(args) != 2 || net.ParseIP(args[1]) == nil {
+			return fmt.Errorf("usage: logout <userip>")
+		}
+		return nas.NotifyLogout(net.ParseIP(args[1]), dest)
+	case "fault", "clear":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: %s <challenge|auth|logout|info> ...", args[0])
+		}
+		typ, ok := reqTypes[args[1]]
+		if !ok {
+			return fmt.Errorf("unknown request type %q", args[1])
+		}
+		if args[0] == "clear" {
+			nas.Inject(typ, nil)
+			return nil
+		}
+		fault, err := parseFault(args[2:])
+		if err != nil {
+			return err
+		}
+		nas.Inject(typ, fault)
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+	return nil
+}
+
+func parseFault(args []string) (*simulator.Fault, error) {
+	fault := new(simulator.Fault)
+	for _, arg := range args {
+		key, value, _ := strings.Cut(arg, "=")
+		var err error
+		switch key {
+		case "errcode":
+			var code int
+			code, err = strconv.Atoi(value)
+			fault.ErrCode = byte(code)
+		case "delay":
+			fault.Delay, err = time.ParseDuration(value)
+		case "drop":
+			fault.Drop, err = strconv.Atoi(value)
+		case "text":
+			fault.TextInfo = value
+		default:
+			err = fmt.Errorf("unknown fault option %q", key)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return fault, nil
+}
@@ -0,0 +1,175 @@
+package audit
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
+
+	"syler/internal/logger"
+)
+
+// Event 审计事件类型
+type Event string
+
+const (
+	LoginSuccess Event = "login_success"
+	LoginFailure Event = "login_failure"
+	Logout       Event = "logout"
+	NtfLogout    Event = "ntf_logout"
+	Kick         Event = "kick"
+	SMSSend      Event = "sms_send"
+	ListChange   Event = "list_change"
+	Lockout      Event = "lockout"
+)
+
+// Record 一条审计记录，按公共上网场所监管要求保留用户、IP、MAC及时间
+type Record struct {
+	Time      time.Time `json:"time"`
+	Event     Event     `json:"event"`
+	Username  string    `json:"username,omitempty"`
+	Phone     string    `json:"phone,omitempty"`
+	UserIP    string    `json:"user_ip,omitempty"`
+	UserMAC   string    `json:"user_mac,omitempty"`
+	NasIP     string    `json:"nas_ip,omitempty"`
+	Method    string    `json:"method,omitempty"`
+	Operator  string    `json:"operator,omitempty"`
+	Success   bool      `json:"success"`
+	Reason    string    `json:"reason,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+// Config 审计日志配置
+type Config struct {
+	File          string
+	MaxSize       int // MB
+	MaxBackups    int
+	RetentionDays int // 审计文件保留天数，0表示不按时间清理
+	QueueSize     int // 等待写入的记录数上限，默认4096，队列满时丢弃新记录
+	Syslog        SyslogConfig
+}
+
+const defaultQueueSize = 4096
+
+type entry struct {
+	r    Record
+	line []byte
+}
+
+var (
+	mu      sync.RWMutex // 保护queue的发送与关闭
+	queue   chan entry
+	done    chan struct{}
+	dropped atomic.Int64 // 启动以来因队列满丢弃的记录数
+
+	// 以下只由写入协程访问
+	file   io.WriteCloser
+	syslog *syslogWriter
+)
+
+// Init 初始化审计日志，File为空时不写文件。记录经队列由单独的协程写入，
+// 文件或syslog变慢时不阻塞请求处理；syslog暂时连不上时在后台重连，不影响启动
+func Init(cfg Config) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if cfg.File != "" {
+		file = &lumberjack.Logger{
+			Filename:   cfg.File,
+			MaxSize:    cfg.MaxSize,
+			MaxBackups: cfg.MaxBackups,
+			MaxAge:     cfg.RetentionDays,
+			LocalTime:  true,
+			Compress:   true,
+		}
+	}
+	if cfg.Syslog.Addr != "" {
+		syslog = newSyslogWriter(cfg.Syslog)
+	}
+
+	size := cfg.QueueSize
+	if size <= 0 {
+		size = defaultQueueSize
+	}
+	queue = make(chan entry, size)
+	done = make(chan struct{})
+	go run(queue, done)
+	return nil
+}
+
+// Log 追加一条审计记录，队列满时丢弃并计数
+func Log(r Record) {
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	line, err := json.Marshal(r)
+	if err != nil {
+		return
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+	if queue == nil {
+		return
+	}
+	select {
+	case queue <- entry{r, line}:
+	default:
+		dropped.Add(1)
+	}
+}
+
+// Dropped 返回启动以来因队列满丢弃的记录数
+func Dropped() int64 {
+	return dropped.Load()
+}
+
+// run 写入协程，依次写文件和syslog，队列关闭后写完剩余记录再退出
+func run(queue <-chan entry, done chan<- struct{}) {
+	defer close(done)
+	log := logger.GetLogger()
+	var reported int64
+	for e := range queue {
+		if file != nil {
+			if _, err := file.Write(append(e.line, '\n')); err != nil {
+				log.WithField("error", err).Error("Failed to write audit record")
+			}
+		}
+		if syslog != nil {
+			syslog.Write(e.r, e.line)
+		}
+		if n := dropped.Load(); n != reported {
+			log.WithFields(logrus.Fields{
+				"dropped": n - reported,
+				"total":   n,
+			}).Warn("Audit queue full, records dropped")
+			reported = n
+		}
+	}
+}
+
+// Close 写完队列中的记录后关闭审计输出
+func Close() {
+	mu.Lock()
+	q, d := queue, done
+	queue = nil
+	mu.Unlock()
+	if q == nil {
+		return
+	}
+	close(q)
+	<-d
+
+	if file != nil {
+		file.Close()
+		file = nil
+	}
+	if syslog != nil {
+		syslog.Close()
+		syslog = nil
+	}
+}
@@ -0,0 +1,55 @@
+package audit
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// syslog连不上时记录仍写入文件，Log不等待拨号
+func TestLogAsync(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "audit.log")
+	if err := Init(Config{File: file, Syslog: SyslogConfig{Network: "tcp", Addr: "127.0.0.1:1"}}); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		Log(Record{Event: LoginSuccess, Username: "user", Success: true})
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Log blocked for %v", d)
+	}
+	Close()
+
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	lines := 0
+	for s := bufio.NewScanner(f); s.Scan(); {
+		lines++
+	}
+	if lines != 100 {
+		t.Errorf("%d records written, want 100", lines)
+	}
+}
+
+func TestLogDropWhenFull(t *testing.T) {
+	mu.Lock()
+	queue = make(chan entry, 1)
+	mu.Unlock()
+	defer func() {
+		mu.Lock()
+		queue = nil
+		mu.Unlock()
+	}()
+	before := Dropped()
+	Log(Record{Event: Logout})
+	Log(Record{Event: Logout})
+	if n := Dropped() - before; n != 1 {
+		t.Errorf("dropped %d records, want 1", n)
+	}
+}
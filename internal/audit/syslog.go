@@ -0,0 +1,202 @@
+package audit
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"syler/internal/logger"
+)
+
+// SyslogConfig RFC 5424 syslog 输出配置
+type SyslogConfig struct {
+	Network  string // udp 或 tcp
+	Addr     string
+	Facility int // 默认 10 (authpriv)
+	AppName  string
+}
+
+const (
+	severityWarning = 4
+	severityInfo    = 6
+	// 结构化数据ID，使用私有企业号格式
+	sdID = "syler@32473"
+)
+
+// 连接syslog失败后的重连间隔，每次失败翻倍
+const (
+	syslogDialTimeout  = 2 * time.Second
+	syslogWriteTimeout = 2 * time.Second
+	syslogMinBackoff   = time.Second
+	syslogMaxBackoff   = time.Minute
+)
+
+// syslogWriter 只由审计写入协程使用。连接失败后在退避时间内直接丢弃记录，
+// 不让每条记录都等待拨号超时
+type syslogWriter struct {
+	cfg      SyslogConfig
+	hostname string
+	conn     net.Conn
+
+	backoff time.Duration
+	retryAt time.Time
+	lost    int64 // 本次断开期间未能发送的记录数
+}
+
+func newSyslogWriter(cfg SyslogConfig) *syslogWriter {
+	if cfg.Network == "" {
+		cfg.Network = "udp"
+	}
+	if cfg.Facility == 0 {
+		cfg.Facility = 10
+	}
+	if cfg.AppName == "" {
+		cfg.AppName = "syler"
+	}
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "-"
+	}
+	w := &syslogWriter{cfg: cfg, hostname: hostname}
+	if err := w.connect(time.Now()); err != nil {
+		logger.GetLogger().WithFields(logrus.Fields{
+			"addr":  cfg.Addr,
+			"error": err,
+		}).Warn("Audit syslog unreachable, retrying in background")
+	}
+	return w
+}
+
+// connect 连接syslog，退避期内不重试
+func (w *syslogWriter) connect(now time.Time) error {
+	if now.Before(w.retryAt) {
+		return errSyslogDown
+	}
+	conn, err := net.DialTimeout(w.cfg.Network, w.cfg.Addr, syslogDialTimeout)
+	if err != nil {
+		w.fail(now)
+		return err
+	}
+	w.conn, w.backoff, w.retryAt = conn, 0, time.Time{}
+	return nil
+}
+
+func (w *syslogWriter) fail(now time.Time) {
+	if w.conn != nil {
+		w.conn.Close()
+		w.conn = nil
+	}
+	w.backoff *= 2
+	if w.backoff < syslogMinBackoff {
+		w.backoff = syslogMinBackoff
+	}
+	if w.backoff > syslogMaxBackoff {
+		w.backoff = syslogMaxBackoff
+	}
+	w.retryAt = now.Add(w.backoff)
+}
+
+var errSyslogDown = errors.New("syslog unavailable, waiting to reconnect")
+
+// Write 发送一条记录，失败的记录不重发，断开和恢复时各记录一次日志
+func (w *syslogWriter) Write(r Record, msg []byte) {
+	line := format(w.cfg.Facility, w.hostname, w.cfg.AppName, r, msg)
+	if w.cfg.Network == "tcp" {
+		// RFC 6587 octet counting
+		line = fmt.Sprintf("%d %s", len(line), line)
+	}
+	now := time.Now()
+	err := w.send(now, []byte(line))
+	if err == nil {
+		if w.lost > 0 {
+			logger.GetLogger().WithField("lost", w.lost).Info("Audit syslog reconnected")
+			w.lost = 0
+		}
+		return
+	}
+	if w.lost == 0 {
+		logger.GetLogger().WithFields(logrus.Fields{
+			"addr":  w.cfg.Addr,
+			"error": err,
+		}).Error("Failed to send audit record to syslog")
+	}
+	w.lost++
+}
+
+func (w *syslogWriter) send(now time.Time, line []byte) error {
+	if w.conn == nil {
+		if err := w.connect(now); err != nil {
+			return err
+		}
+	}
+	if err := w.write(line); err == nil {
+		return nil
+	}
+	// 已建立的连接断开时立即重连一次
+	w.conn.Close()
+	w.conn = nil
+	if err := w.connect(now); err != nil {
+		return err
+	}
+	if err := w.write(line); err != nil {
+		w.fail(now)
+		return err
+	}
+	return nil
+}
+
+func (w *syslogWriter) write(line []byte) error {
+	w.conn.SetWriteDeadline(time.Now().Add(syslogWriteTimeout))
+	_, err := w.conn.Write(line)
+	return err
+}
+
+func (w *syslogWriter) Close() {
+	if w.conn != nil {
+		w.conn.Close()
+	}
+}
+
+// format 按 RFC 5424 格式化一条审计记录
+func format(facility int, hostname, app string, r Record, msg []byte) string {
+	severity := severityInfo
+	if !r.Success {
+		severity = severityWarning
+	}
+	params := []struct{ name, value string }{
+		{"username", r.Username},
+		{"phone", r.Phone},
+		{"userip", r.UserIP},
+		{"usermac", r.UserMAC},
+		{"nasip", r.NasIP},
+		{"method", r.Method},
+		{"operator", r.Operator},
+	}
+	sd := new(strings.Builder)
+	sd.WriteString("[" + sdID)
+	for _, p := range params {
+		if p.value != "" {
+			fmt.Fprintf(sd, ` %s="%s"`, p.name, escapeParam(p.value))
+		}
+	}
+	sd.WriteString("]")
+	return fmt.Sprintf("<%d>1 %s %s %s %d %s %s %s",
+		facility*8+severity,
+		r.Time.Format(time.RFC3339Nano),
+		hostname,
+		app,
+		os.Getpid(),
+		r.Event,
+		sd.String(),
+		msg,
+	)
+}
+
+func escapeParam(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(s)
+}
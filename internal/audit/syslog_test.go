@@ -0,0 +1,24 @@
+package audit
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormat(t *testing.T) {
+	r := Record{
+		Time:     time.Date(2024, 5, 1, 8, 30, 0, 0, time.UTC),
+		Event:    LoginFailure,
+		Username: `a"b]c`,
+		UserIP:   "192.168.10.3",
+		NasIP:    "192.168.10.1",
+	}
+	line := format(10, "host", "syler", r, []byte("{}"))
+	if !strings.HasPrefix(line, "<84>1 2024-05-01T08:30:00Z host syler ") {
+		t.Errorf("unexpected header: %s", line)
+	}
+	if !strings.Contains(line, ` login_failure [syler@32473 username="a\"b\]c" userip="192.168.10.3" nasip="192.168.10.1"] {}`) {
+		t.Errorf("unexpected structured data: %s", line)
+	}
+}
@@ -0,0 +1,130 @@
+package capture_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"syler/internal/capture"
+	"syler/internal/portal"
+	v2 "syler/internal/portal/v2"
+	"syler/internal/simulator"
+)
+
+// record 对模拟NAS完成一次认证、查询和下线，返回抓到的pcap
+func record(t *testing.T) []byte {
+	nas := simulator.New(simulator.Config{Addr: "127.0.0.1:0", Version: 2, Secret: "secret"})
+	if err := nas.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer nas.Close()
+
+	buf := new(bytes.Buffer)
+	w, err := capture.NewWriter(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	portal.SetVersion(new(v2.Version))
+	portal.RegisterFallBack(func(portal.Message, net.IP) {})
+	portal.SetTap(func(src, dst *net.UDPAddr, data []byte) {
+		w.Write(&capture.Packet{Src: src, Dst: dst, Payload: data})
+	})
+	defer portal.SetTap(nil)
+	go portal.ListenAndService("127.0.0.1:0")
+	for !portal.Bound() {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ip, port, userip := nas.Addr().IP, nas.Addr().Port, net.IPv4(10, 0, 0, 1)
+	res, err := portal.Challenge(context.Background(), userip, "secret", ip, port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = portal.ChapAuth(context.Background(), userip, "secret", ip, port, []byte("user"), []byte("pwd"), res.ReqId(), res.(portal.ChallengeRes).GetChallenge())
+	if err != nil {
+		t.Fatal(err)
+	}
+	portal.AffAckAuth(context.Background(), userip, "secret", ip, port, res.SerialId(), res.ReqId())
+	if _, err := portal.ReqInfo(context.Background(), userip, "secret", ip, port); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := portal.Logout(context.Background(), userip, "secret", ip, port); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	return buf.Bytes()
+}
+
+func read(t *testing.T, data []byte) []*capture.Packet {
+	r, err := capture.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var packets []*capture.Packet
+	for {
+		p, err := r.Next()
+		if err == io.EOF {
+			return packets
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		packets = append(packets, p)
+	}
+}
+
+func TestDecodeAndReplay(t *testing.T) {
+	packets := read(t, record(t))
+	if len(packets) != 9 {
+		t.Fatalf("captured %d packets, want 9", len(packets))
+	}
+
+	d := capture.NewDecoder("secret")
+	for _, p := range packets {
+		m := d.Decode(p)
+		if m == nil || m.Auth != capture.AuthOK {
+			t.Errorf("authenticator not verified: %v", m)
+		}
+	}
+	wrong := capture.NewDecoder("wrong")
+	if m := wrong.Decode(packets[0]); m.Auth != capture.AuthMismatch {
+		t.Errorf("wrong secret: got %s", m.Auth)
+	}
+
+	nas := simulator.New(simulator.Config{Addr: "127.0.0.1:0", Version: 2, Secret: "secret"})
+	if err := nas.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer nas.Close()
+	results, err := capture.Replay(packets, nas.Addr(), "secret", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 5 {
+		t.Errorf("replayed %d requests, want 5", len(results))
+	}
+	for _, r := range results {
+		if !r.Match() {
+			t.Errorf("%s: recorded %v, replayed %v", portal.TypeName(r.Request.Type()), r.Recorded, r.Replayed)
+		}
+	}
+}
+
+func TestCorruptLength(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if _, err := capture.NewWriter(buf); err != nil {
+		t.Fatal(err)
+	}
+	// 记录头中的长度为4GiB
+	buf.Write([]byte{0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	r, err := capture.NewReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Next(); err == nil || err == io.EOF {
+		t.Errorf("corrupt length not rejected: %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package capture
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"syler/internal/portal"
+	v1 "syler/internal/portal/v1"
+	v2 "syler/internal/portal/v2"
+)
+
+// 校验结果
+const (
+	AuthOK        = "ok"
+	AuthMismatch  = "mismatch"
+	AuthNoRequest = "request not captured"
+	AuthNone      = "n/a"
+)
+
+// Decoded 解码后的Portal报文
+type Decoded struct {
+	Packet  *Packet
+	Version int
+	Message portal.Message
+	Auth    string // Authenticator校验结果，未提供密钥或Portal 1.0时为 n/a
+	vendor  *portal.Vendor
+}
+
+// Decoder 解码Portal报文，记录请求报文以校验应答报文的Authenticator
+type Decoder struct {
+	Secret   string
+	Vendor   *portal.Vendor
+	requests map[uint16]*v2.T_Message
+}
+
+func NewDecoder(secret string) *Decoder {
+	return &Decoder{Secret: secret, Vendor: portal.Huawei, requests: make(map[uint16]*v2.T_Message)}
+}
+
+// IsPortal 粗略判断数据报是否为Portal报文
+func IsPortal(payload []byte) bool {
+	switch {
+	case len(payload) >= 16 && payload[0] == 0x01:
+		return true
+	case len(payload) >= 32 && payload[0] == 0x02:
+		return true
+	}
+	return false
+}
+
+// Decode 解码一个数据报，非Portal报文返回nil
+func (d *Decoder) Decode(p *Packet) *Decoded {
+	if !IsPortal(p.Payload) {
+		return nil
+	}
+	res := &Decoded{Packet: p, Version: int(p.Payload[0]), Auth: AuthNone, vendor: d.Vendor}
+	if res.Version == 1 {
+		res.Message = new(v1.Version).Unmarshall(p.Payload)
+		return res
+	}
+	msg := new(v2.Version).Unmarshall(p.Payload).(*v2.T_Message)
+	res.Message = msg
+	if d.Secret == "" {
+		return res
+	}
+
+	var reqAuth []byte
+	switch msg.Type() {
+	case portal.ACK_CHALLENGE, portal.ACK_AUTH, portal.ACK_LOGOUT, portal.ACK_INFO:
+		req, ok := d.requests[msg.SerialId()]
+		if !ok {
+			res.Auth = AuthNoRequest
+			return res
+		}
+		reqAuth = req.Header.Authenticator
+	default:
+		// 请求报文和NAS主动发送的报文以全零计算Authenticator
+		reqAuth = make([]byte, 16)
+		d.requests[msg.SerialId()] = msg
+	}
+	res.Auth = AuthMismatch
+	if verify(p.Payload, reqAuth, d.Secret) {
+		res.Auth = AuthOK
+	}
+	return res
+}
+
+// verify 以reqAuth替换报文中的Authenticator后计算MD5并与原值比较
+func verify(payload []byte, reqAuth []byte, secret string) bool {
+	msg := new(v2.Version).Unmarshall(payload).(*v2.T_Message)
+	wanted := msg.Header.Authenticator
+	msg.Header.Authenticator = reqAuth
+	msg.AuthBy(secret)
+	return bytes.Equal(wanted, msg.Header.Authenticator)
+}
+
+// String 输出报文的时间、地址、解码内容和校验结果
+func (d *Decoded) String() string {
+	b := new(strings.Builder)
+	fmt.Fprintf(b, "%s %s -> %s portal v%d authenticator: %s\n",
+		d.Packet.Time.Format("2006-01-02 15:04:05.000000"), d.Packet.Src, d.Packet.Dst, d.Version, d.Auth)
+	b.WriteString(d.vendor.Format(d.Message))
+	return b.String()
+}
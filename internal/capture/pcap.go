@@ -0,0 +1,231 @@
+// Package capture 读写Portal报文的pcap文件，解码报文并校验Authenticator，
+// 以及将录制的报文重放到NAS模拟器
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// pcap 链路类型
+const (
+	linkNull     = 0
+	linkEthernet = 1
+	linkRaw      = 101
+	linkLinuxSLL = 113
+)
+
+const (
+	magicMicro = 0xa1b2c3d4
+	magicNano  = 0xa1b23c4d
+)
+
+// Packet 一个UDP数据报
+type Packet struct {
+	Time    time.Time
+	Src     *net.UDPAddr
+	Dst     *net.UDPAddr
+	Payload []byte
+}
+
+// Reader 读取经典libpcap格式文件中的IPv4 UDP数据报，其他报文会被跳过
+type Reader struct {
+	r        io.Reader
+	order    binary.ByteOrder
+	nano     bool
+	linkType uint32
+	snapLen  uint32
+}
+
+// maxSnapLen 数据报长度上限，与tcpdump相同，防止损坏的文件申请过大的内存
+const maxSnapLen = 262144
+
+func NewReader(r io.Reader) (*Reader, error) {
+	hdr := make([]byte, 24)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+	pr := &Reader{r: bufio.NewReader(r)}
+	switch {
+	case binary.LittleEndian.Uint32(hdr) == magicMicro:
+		pr.order = binary.LittleEndian
+	case binary.BigEndian.Uint32(hdr) == magicMicro:
+		pr.order = binary.BigEndian
+	case binary.LittleEndian.Uint32(hdr) == magicNano:
+		pr.order, pr.nano = binary.LittleEndian, true
+	case binary.BigEndian.Uint32(hdr) == magicNano:
+		pr.order, pr.nano = binary.BigEndian, true
+	default:
+		return nil, fmt.Errorf("not a pcap file (pcapng is not supported)")
+	}
+	pr.snapLen = pr.order.Uint32(hdr[16:])
+	if pr.snapLen == 0 || pr.snapLen > maxSnapLen {
+		pr.snapLen = maxSnapLen
+	}
+	pr.linkType = pr.order.Uint32(hdr[20:])
+	switch pr.linkType {
+	case linkNull, linkEthernet, linkRaw, linkLinuxSLL:
+	default:
+		return nil, fmt.Errorf("unsupported link type %d", pr.linkType)
+	}
+	return pr, nil
+}
+
+// Next 返回下一个UDP数据报，文件结束时返回io.EOF
+func (pr *Reader) Next() (*Packet, error) {
+	hdr := make([]byte, 16)
+	for {
+		if _, err := io.ReadFull(pr.r, hdr); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				err = io.EOF
+			}
+			return nil, err
+		}
+		sec := pr.order.Uint32(hdr[0:])
+		frac := pr.order.Uint32(hdr[4:])
+		capLen := pr.order.Uint32(hdr[8:])
+		if capLen > pr.snapLen {
+			return nil, fmt.Errorf("corrupt pcap: packet length %d exceeds snaplen %d", capLen, pr.snapLen)
+		}
+		data := make([]byte, capLen)
+		if _, err := io.ReadFull(pr.r, data); err != nil {
+			return nil, io.EOF
+		}
+		if !pr.nano {
+			frac *= 1000
+		}
+		if p := pr.decode(data); p != nil {
+			p.Time = time.Unix(int64(sec), int64(frac))
+			return p, nil
+		}
+	}
+}
+
+func (pr *Reader) decode(data []byte) *Packet {
+	switch pr.linkType {
+	case linkNull:
+		if len(data) < 4 {
+			return nil
+		}
+		data = data[4:]
+	case linkEthernet:
+		if len(data) < 14 {
+			return nil
+		}
+		etherType := binary.BigEndian.Uint16(data[12:])
+		data = data[14:]
+		// 802.1Q VLAN
+		for etherType == 0x8100 && len(data) >= 4 {
+			etherType = binary.BigEndian.Uint16(data[2:])
+			data = data[4:]
+		}
+		if etherType != 0x0800 {
+			return nil
+		}
+	case linkLinuxSLL:
+		if len(data) < 16 || binary.BigEndian.Uint16(data[14:]) != 0x0800 {
+			return nil
+		}
+		data = data[16:]
+	}
+	return decodeIPv4(data)
+}
+
+func decodeIPv4(data []byte) *Packet {
+	if len(data) < 20 || data[0]>>4 != 4 {
+		return nil
+	}
+	ihl := int(data[0]&0x0f) * 4
+	total := int(binary.BigEndian.Uint16(data[2:]))
+	if data[9] != 17 || len(data) < ihl+8 || total < ihl+8 {
+		return nil
+	}
+	if total < len(data) {
+		data = data[:total]
+	}
+	// 不处理IP分片
+	if binary.BigEndian.Uint16(data[6:])&0x3fff != 0 {
+		return nil
+	}
+	udp := data[ihl:]
+	return &Packet{
+		Src:     &net.UDPAddr{IP: net.IP(append([]byte(nil), data[12:16]...)), Port: int(binary.BigEndian.Uint16(udp[0:]))},
+		Dst:     &net.UDPAddr{IP: net.IP(append([]byte(nil), data[16:20]...)), Port: int(binary.BigEndian.Uint16(udp[2:]))},
+		Payload: append([]byte(nil), udp[8:]...),
+	}
+}
+
+// Writer 以LINKTYPE_RAW格式写pcap文件，为每个数据报补上IPv4和UDP头
+type Writer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriter(w io.Writer) (*Writer, error) {
+	hdr := make([]byte, 24)
+	binary.LittleEndian.PutUint32(hdr[0:], magicMicro)
+	binary.LittleEndian.PutUint16(hdr[4:], 2)
+	binary.LittleEndian.PutUint16(hdr[6:], 4)
+	binary.LittleEndian.PutUint32(hdr[16:], 65535)
+	binary.LittleEndian.PutUint32(hdr[20:], linkRaw)
+	if _, err := w.Write(hdr); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w}, nil
+}
+
+func (pw *Writer) Write(p *Packet) error {
+	size := 28 + len(p.Payload)
+	data := make([]byte, 16+size)
+	ts := p.Time
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	binary.LittleEndian.PutUint32(data[0:], uint32(ts.Unix()))
+	binary.LittleEndian.PutUint32(data[4:], uint32(ts.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(data[8:], uint32(size))
+	binary.LittleEndian.PutUint32(data[12:], uint32(size))
+
+	ip := data[16:]
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:], uint16(size))
+	ip[8] = 64
+	ip[9] = 17
+	copy(ip[12:16], ipv4(p.Src.IP))
+	copy(ip[16:20], ipv4(p.Dst.IP))
+	binary.BigEndian.PutUint16(ip[10:], checksum(ip[:20]))
+
+	udp := ip[20:]
+	binary.BigEndian.PutUint16(udp[0:], uint16(p.Src.Port))
+	binary.BigEndian.PutUint16(udp[2:], uint16(p.Dst.Port))
+	binary.BigEndian.PutUint16(udp[4:], uint16(8+len(p.Payload)))
+	copy(udp[8:], p.Payload)
+
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	_, err := pw.w.Write(data)
+	return err
+}
+
+func ipv4(ip net.IP) net.IP {
+	if v4 := ip.To4(); v4 != nil {
+		return v4
+	}
+	return net.IPv4zero.To4()
+}
+
+func checksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return ^uint16(sum)
+}
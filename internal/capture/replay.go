@@ -0,0 +1,170 @@
+package capture
+
+import (
+	"bytes"
+	"net"
+	"time"
+
+	"syler/internal/portal"
+	v1 "syler/internal/portal/v1"
+	v2 "syler/internal/portal/v2"
+)
+
+// Result 一次重放的请求与录制、重放得到的应答
+type Result struct {
+	Request  portal.Message
+	Recorded portal.Message // 录制的应答，无应答时为nil
+	Replayed portal.Message // 重放得到的应答，超时为nil
+}
+
+// Match 重放应答与录制应答的类型和错误码一致
+func (r Result) Match() bool {
+	if r.Recorded == nil || r.Replayed == nil {
+		return r.Recorded == nil && r.Replayed == nil
+	}
+	return r.Recorded.Type() == r.Replayed.Type() && r.Recorded.ErrCode() == r.Replayed.ErrCode()
+}
+
+func isRequest(typ byte) bool {
+	switch typ {
+	case portal.REQ_CHALLENGE, portal.REQ_AUTH, portal.REQ_LOGOUT, portal.AFF_ACK_AUTH, portal.REQ_INFO:
+		return true
+	}
+	return false
+}
+
+func expectsResponse(m portal.Message) bool {
+	switch m.Type() {
+	case portal.AFF_ACK_AUTH:
+		return false
+	case portal.REQ_LOGOUT:
+		return m.ErrCode() != 1
+	}
+	return true
+}
+
+// Replay 按顺序将录制的Portal Server请求发送到target并比较应答。
+// NAS分配的ReqID和Challenge会替换为重放时得到的值，Portal 2.0报文用secret重新签名，
+// CHAP密码无法重新计算，因此目标模拟器应配置为接受任意用户
+func Replay(packets []*Packet, target *net.UDPAddr, secret string, timeout time.Duration) ([]Result, error) {
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var msgs []portal.Message
+	for _, p := range packets {
+		if IsPortal(p.Payload) {
+			msgs = append(msgs, unmarshall(p.Payload))
+		}
+	}
+
+	reqIds := make(map[uint16]uint16)
+	challenges := make(map[string][]byte)
+	var results []Result
+	for i, req := range msgs {
+		if !isRequest(req.Type()) {
+			continue
+		}
+		res := Result{Request: req}
+		for _, m := range msgs[i+1:] {
+			if !isRequest(m.Type()) && m.SerialId() == req.SerialId() && m.Type() == req.Type()+1 {
+				res.Recorded = m
+				break
+			}
+		}
+
+		out := rewrite(req, reqIds, challenges, secret)
+		if _, err := conn.WriteToUDP(out.Bytes(), target); err != nil {
+			return results, err
+		}
+		if expectsResponse(req) {
+			res.Replayed = receive(conn, out.SerialId(), timeout)
+		}
+		if res.Recorded != nil && res.Replayed != nil {
+			reqIds[res.Recorded.ReqId()] = res.Replayed.ReqId()
+			if old := challengeOf(res.Recorded); old != nil {
+				challenges[string(old)] = challengeOf(res.Replayed)
+			}
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+func receive(conn *net.UDPConn, serial uint16, timeout time.Duration) portal.Message {
+	buf := make([]byte, 4096)
+	deadline := time.Now().Add(timeout)
+	for {
+		conn.SetReadDeadline(deadline)
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return nil
+		}
+		if !IsPortal(buf[:n]) {
+			continue
+		}
+		if m := unmarshall(append([]byte(nil), buf[:n]...)); m.SerialId() == serial {
+			return m
+		}
+	}
+}
+
+func unmarshall(payload []byte) portal.Message {
+	if payload[0] == 0x01 {
+		return new(v1.Version).Unmarshall(payload)
+	}
+	return new(v2.Version).Unmarshall(payload)
+}
+
+func challengeOf(m portal.Message) []byte {
+	if c, ok := m.(portal.ChallengeRes); ok {
+		return c.GetChallenge()
+	}
+	return nil
+}
+
+// rewrite 将请求中录制时的ReqID和Challenge替换为重放时NAS分配的值
+func rewrite(req portal.Message, reqIds map[uint16]uint16, challenges map[string][]byte, secret string) portal.Message {
+	replace := func(typ byte, val []byte) []byte {
+		if typ == 0x03 {
+			if c, ok := challenges[string(val)]; ok {
+				return c
+			}
+		}
+		return val
+	}
+	switch m := req.(type) {
+	case *v1.T_Message:
+		out := *m
+		if id, ok := reqIds[m.Header.ReqIdentifier]; ok {
+			out.Header.ReqIdentifier = id
+		}
+		out.Attrs = make([]v1.T_Attr, len(m.Attrs))
+		for i, a := range m.Attrs {
+			// Unmarshall保留的是含头部的长度
+			val := replace(a.AttrType, a.AttrStr)
+			out.Attrs[i] = v1.T_Attr{AttrType: a.AttrType, AttrLen: byte(len(val)), AttrStr: val}
+		}
+		return &out
+	case *v2.T_Message:
+		out := *m
+		if id, ok := reqIds[m.Header.ReqIdentifier]; ok {
+			out.Header.ReqIdentifier = id
+		}
+		out.Attrs = make([]v2.T_Attr, len(m.Attrs))
+		changed := out.Header.ReqIdentifier != m.Header.ReqIdentifier
+		for i, a := range m.Attrs {
+			val := replace(a.AttrType, a.AttrStr)
+			changed = changed || !bytes.Equal(val, a.AttrStr)
+			out.Attrs[i] = v2.T_Attr{AttrType: a.AttrType, AttrLen: byte(len(val)), AttrStr: val}
+		}
+		if changed && secret != "" {
+			out.Header.Authenticator = make([]byte, 16)
+			out.AuthBy(secret)
+		}
+		return &out
+	}
+	return req
+}
@@ -0,0 +1,190 @@
+// Package cluster 多个syler实例通过Redis组成集群：
+// 应答报文转交给发出请求的节点，定时任务只在主节点执行
+package cluster
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+
+	"syler/internal/logger"
+	"syler/internal/portal"
+)
+
+// Config 集群配置
+type Config struct {
+	NodeID    string        // 节点标识，为空时使用主机名加随机后缀
+	Prefix    string        // Redis键前缀
+	LeaderTTL time.Duration // 主节点租约时长
+}
+
+// Cluster 本节点在集群中的状态，实现portal.Forwarder
+type Cluster struct {
+	cfg    Config
+	rdb    redis.UniversalClient
+	leader atomic.Bool
+}
+
+// forwarded 节点间转发的应答报文
+type forwarded struct {
+	Src  string `json:"src"`
+	Data []byte `json:"data"`
+}
+
+// 仅当值仍为本节点时续约或删除
+var renewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+func New(rdb redis.UniversalClient, cfg Config) *Cluster {
+	if cfg.NodeID == "" {
+		host, _ := os.Hostname()
+		b := make([]byte, 4)
+		rand.Read(b)
+		cfg.NodeID = host + "-" + hex.EncodeToString(b)
+	}
+	if cfg.Prefix == "" {
+		cfg.Prefix = "syler:"
+	}
+	if cfg.LeaderTTL <= 0 {
+		cfg.LeaderTTL = 15 * time.Second
+	}
+	return &Cluster{cfg: cfg, rdb: rdb}
+}
+
+func (c *Cluster) NodeID() string {
+	return c.cfg.NodeID
+}
+
+// IsLeader 本节点是否持有主节点租约
+func (c *Cluster) IsLeader() bool {
+	return c.leader.Load()
+}
+
+func (c *Cluster) leaderKey() string {
+	return c.cfg.Prefix + "leader"
+}
+
+func (c *Cluster) txnKey(nas net.IP, serial uint16) string {
+	return fmt.Sprintf("%stxn:%s:%d", c.cfg.Prefix, nas, serial)
+}
+
+func (c *Cluster) channel(node string) string {
+	return c.cfg.Prefix + "node:" + node
+}
+
+// Run 接收其他节点转来的应答并参与主节点选举，done关闭后释放租约并返回
+func (c *Cluster) Run(done <-chan struct{}) {
+	log := logger.Subsystem(logger.Portal).WithField("node_id", c.cfg.NodeID)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sub := c.rdb.Subscribe(ctx, c.channel(c.cfg.NodeID))
+	defer sub.Close()
+	go func() {
+		for m := range sub.Channel() {
+			c.deliver(log, m.Payload)
+		}
+	}()
+
+	log.Info("Joined portal cluster")
+	c.campaign(ctx, log)
+	ticker := time.NewTicker(c.cfg.LeaderTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			if c.leader.Swap(false) {
+				releaseScript.Run(context.Background(), c.rdb, []string{c.leaderKey()}, c.cfg.NodeID)
+			}
+			return
+		case <-ticker.C:
+			c.campaign(ctx, log)
+		}
+	}
+}
+
+// campaign 续约或尝试成为主节点
+func (c *Cluster) campaign(ctx context.Context, log *logrus.Entry) {
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.LeaderTTL/3)
+	defer cancel()
+
+	var leader bool
+	if c.leader.Load() {
+		n, err := renewScript.Run(ctx, c.rdb, []string{c.leaderKey()}, c.cfg.NodeID, c.cfg.LeaderTTL.Milliseconds()).Int()
+		leader = err == nil && n == 1
+	} else {
+		ok, err := c.rdb.SetNX(ctx, c.leaderKey(), c.cfg.NodeID, c.cfg.LeaderTTL).Result()
+		leader = err == nil && ok
+	}
+	if leader != c.leader.Swap(leader) {
+		if leader {
+			log.Info("Became cluster leader")
+		} else {
+			log.Warn("Lost cluster leadership")
+		}
+	}
+}
+
+func (c *Cluster) deliver(log *logrus.Entry, payload string) {
+	var f forwarded
+	if err := json.Unmarshal([]byte(payload), &f); err != nil {
+		log.WithField("error", err).Warn("Drop malformed forwarded message")
+		return
+	}
+	src, err := net.ResolveUDPAddr("udp", f.Src)
+	if err != nil {
+		log.WithField("error", err).Warn("Drop forwarded message with bad source")
+		return
+	}
+	portal.Deliver(src, f.Data)
+}
+
+// Claim 登记本节点为NAS上该序列号事务的处理者
+func (c *Cluster) Claim(nas net.IP, serial uint16, ttl time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := c.rdb.Set(ctx, c.txnKey(nas, serial), c.cfg.NodeID, ttl).Err(); err != nil {
+		logger.Subsystem(logger.Portal).WithFields(logrus.Fields{
+			"error":     err,
+			"nas_ip":    nas.String(),
+			"serial_no": serial,
+		}).Warn("Failed to claim portal transaction")
+	}
+}
+
+func (c *Cluster) Release(nas net.IP, serial uint16) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	releaseScript.Run(ctx, c.rdb, []string{c.txnKey(nas, serial)}, c.cfg.NodeID)
+}
+
+// Forward 将应答发布到处理该事务的节点的频道
+func (c *Cluster) Forward(src *net.UDPAddr, serial uint16, data []byte) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	owner, err := c.rdb.Get(ctx, c.txnKey(src.IP, serial)).Result()
+	if err != nil || owner == c.cfg.NodeID {
+		return false
+	}
+	payload, _ := json.Marshal(forwarded{Src: src.String(), Data: data})
+	return c.rdb.Publish(ctx, c.channel(owner), payload).Err() == nil
+}
@@ -0,0 +1,42 @@
+package cluster
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// 设置 SYLER_TEST_REDIS=host:port 时运行
+func TestLeaderElection(t *testing.T) {
+	addr := os.Getenv("SYLER_TEST_REDIS")
+	if addr == "" {
+		t.Skip("SYLER_TEST_REDIS not set")
+	}
+	rdb := redis.NewClient(&redis.Options{Addr: addr})
+	if err := rdb.Ping(context.Background()).Err(); err != nil {
+		t.Skip(err)
+	}
+
+	cfg := Config{Prefix: "syler-test:" + time.Now().Format("150405.000") + ":", LeaderTTL: 300 * time.Millisecond}
+	a, b := New(rdb, cfg), New(rdb, cfg)
+	doneA, doneB := make(chan struct{}), make(chan struct{})
+	go a.Run(doneA)
+	time.Sleep(50 * time.Millisecond)
+	go b.Run(doneB)
+	defer close(doneB)
+
+	time.Sleep(200 * time.Millisecond)
+	if !a.IsLeader() || b.IsLeader() {
+		t.Fatalf("leader a=%v b=%v", a.IsLeader(), b.IsLeader())
+	}
+
+	// 主节点退出后另一节点接任
+	close(doneA)
+	time.Sleep(300 * time.Millisecond)
+	if !b.IsLeader() {
+		t.Error("leadership not taken over")
+	}
+}
@@ -1,44 +1,90 @@
 package logger
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"io"
 	"net/http"
 	"os"
+	"strings"
+	"sync"
 
 	"github.com/sirupsen/logrus"
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
-var log *logrus.Logger
+// 子系统名称，可在 logging.levels 中单独设置日志级别
+const (
+	Portal = "portal"
+	HTTP   = "http"
+	SMS    = "sms"
+)
+
+// RequestIDHeader 请求ID的HTTP头，客户端或前置代理传入时沿用，否则自动生成
+const RequestIDHeader = "X-Request-Id"
+
+// Config 日志配置
+type Config struct {
+	File       string
+	Level      string
+	Format     string // text 或 json
+	MaxSize    int    // MB
+	MaxBackups int
+	Stdout     bool              // 是否同时输出到标准输出
+	Levels     map[string]string // 子系统日志级别
+}
 
-func Init(filePath string, level string, maxSize int, maxBackups int) error {
+type ctxKey struct{}
+
+var log = logrus.New()
+var subs = make(map[string]*logrus.Logger)
+var subsLock sync.Mutex
+
+func Init(cfg Config) error {
 	log = logrus.New()
 
 	// Set log level
-	lvl, err := logrus.ParseLevel(level)
+	lvl, err := logrus.ParseLevel(cfg.Level)
 	if err != nil {
 		return err
 	}
 	log.SetLevel(lvl)
 
-	// Set log format to JSON
-	// log.SetFormatter(&logrus.JSONFormatter{})
-	log.SetFormatter(&logrus.TextFormatter{
-		FullTimestamp: true,
-		DisableColors: false,
-	})
+	switch strings.ToLower(cfg.Format) {
+	case "json":
+		log.SetFormatter(&maskFormatter{&logrus.JSONFormatter{}})
+	default:
+		log.SetFormatter(&maskFormatter{&logrus.TextFormatter{
+			FullTimestamp: true,
+			DisableColors: !cfg.Stdout,
+		}})
+	}
 
 	// Set up log rotation
 	rotator := &lumberjack.Logger{
-		Filename:   filePath,
-		MaxSize:    maxSize, // MB
-		MaxBackups: maxBackups,
+		Filename:   cfg.File,
+		MaxSize:    cfg.MaxSize, // MB
+		MaxBackups: cfg.MaxBackups,
 		Compress:   true,
 	}
 
-	// Write logs to both file and stdout
-	mw := io.MultiWriter(os.Stdout, rotator)
-	log.SetOutput(mw)
+	var out io.Writer = rotator
+	if cfg.Stdout {
+		out = io.MultiWriter(os.Stdout, rotator)
+	}
+	log.SetOutput(out)
+
+	subsLock.Lock()
+	defer subsLock.Unlock()
+	subs = make(map[string]*logrus.Logger)
+	for name, level := range cfg.Levels {
+		lvl, err := logrus.ParseLevel(level)
+		if err != nil {
+			return err
+		}
+		subs[name] = newSub(lvl)
+	}
 
 	return nil
 }
@@ -47,13 +93,71 @@ func GetLogger() *logrus.Logger {
 	return log
 }
 
+func newSub(lvl logrus.Level) *logrus.Logger {
+	return &logrus.Logger{
+		Out:          log.Out,
+		Hooks:        log.Hooks,
+		Formatter:    log.Formatter,
+		ReportCaller: log.ReportCaller,
+		Level:        lvl,
+		ExitFunc:     os.Exit,
+	}
+}
+
+// Subsystem 返回子系统日志，未单独配置级别的子系统沿用全局级别
+func Subsystem(name string) *logrus.Entry {
+	subsLock.Lock()
+	l, ok := subs[name]
+	if !ok {
+		l = newSub(log.GetLevel())
+		subs[name] = l
+	}
+	subsLock.Unlock()
+	return l.WithField("subsystem", name)
+}
+
+// NewRequestID 生成一个随机请求ID
+func NewRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// WithRequestID 将请求ID写入context
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// RequestID 取出context中的请求ID
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(ctxKey{}).(string)
+	return id
+}
+
+// FromContext 返回带请求ID的portal子系统日志，用于记录Portal交互过程
+func FromContext(ctx context.Context) *logrus.Entry {
+	entry := Subsystem(Portal)
+	if id := RequestID(ctx); id != "" {
+		entry = entry.WithField("request_id", id)
+	}
+	return entry
+}
+
 // WithRequest creates a new logger with request context
 func WithRequest(r *http.Request) *logrus.Entry {
+	entry := Subsystem(HTTP)
 	if r == nil {
-		return log.WithFields(logrus.Fields{})
+		return entry
 	}
-	return log.WithFields(logrus.Fields{
+	fields := logrus.Fields{
 		"method": r.Method,
 		"path":   r.URL.Path,
-	})
+	}
+	if id := RequestID(r.Context()); id != "" {
+		fields["request_id"] = id
+	}
+	return entry.WithFields(fields)
 }
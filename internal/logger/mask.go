@@ -0,0 +1,68 @@
+package logger
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+var phonePattern = regexp.MustCompile(`^(\+?86)?1[3-9]\d{9}$`)
+
+// digitRun 文本中连续的数字，逐段判断是否为手机号，不会截取更长号码中的一部分
+var digitRun = regexp.MustCompile(`\+?\d{11,}`)
+
+// 需要整体隐藏的字段
+var secretFields = map[string]bool{
+	"password": true,
+	"userpwd":  true,
+	"pwd":      true,
+	"code":     true,
+	"secret":   true,
+}
+
+// maskFormatter 在输出前对敏感字段整体隐藏，并隐藏消息和其他字段值中出现的手机号，
+// 包括用户名、名单规则和错误信息
+type maskFormatter struct {
+	inner logrus.Formatter
+}
+
+func (f *maskFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	data := make(logrus.Fields, len(entry.Data))
+	for k, v := range entry.Data {
+		if secretFields[strings.ToLower(k)] {
+			data[k] = "******"
+			continue
+		}
+		switch val := v.(type) {
+		case string:
+			data[k] = MaskText(val)
+		case error:
+			if text := val.Error(); MaskText(text) != text {
+				data[k] = MaskText(text)
+			} else {
+				data[k] = v
+			}
+		default:
+			data[k] = v
+		}
+	}
+	masked := *entry
+	masked.Data = data
+	masked.Message = MaskText(entry.Message)
+	return f.inner.Format(&masked)
+}
+
+// MaskPhone 隐藏手机号中间四位，非手机号原样返回
+func MaskPhone(s string) string {
+	if !phonePattern.MatchString(s) {
+		return s
+	}
+	n := len(s)
+	return s[:n-8] + "****" + s[n-4:]
+}
+
+// MaskText 隐藏文本中出现的所有手机号
+func MaskText(s string) string {
+	return digitRun.ReplaceAllStringFunc(s, MaskPhone)
+}
@@ -0,0 +1,36 @@
+package logger
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestMaskFormatter(t *testing.T) {
+	entry := logrus.NewEntry(logrus.New()).WithFields(logrus.Fields{
+		"username":     "13800001234",
+		"list_pattern": "1380013*",
+		"target":       "+8613900005678",
+		"error":        errors.New("sms to 13700009876 failed"),
+		"userpwd":      "hunter2",
+		"serial":       "123456789012345",
+	})
+	entry.Message = "Sending code to 13600004321"
+	f := &maskFormatter{&logrus.JSONFormatter{}}
+	out, err := f.Format(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, phone := range []string{"13800001234", "13900005678", "13700009876", "13600004321", "hunter2"} {
+		if strings.Contains(string(out), phone) {
+			t.Errorf("%s not masked: %s", phone, out)
+		}
+	}
+	for _, want := range []string{"138****1234", "+86139****5678", "1380013*", "123456789012345"} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("missing %s: %s", want, out)
+		}
+	}
+}
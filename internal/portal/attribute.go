@@ -0,0 +1,185 @@
+package portal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// 属性类型
+const (
+	ATTR_USERNAME        = 0x01
+	ATTR_PASSWORD        = 0x02
+	ATTR_CHALLENGE       = 0x03
+	ATTR_CHAPPASSWORD    = 0x04
+	ATTR_TEXTINFO        = 0x05
+	ATTR_UPLINKFLUX      = 0x06
+	ATTR_DOWNLINKFLUX    = 0x07
+	ATTR_PORT            = 0x08
+	ATTR_IPCONFIG        = 0x09
+	ATTR_BASIP           = 0x0a
+	ATTR_SESSIONID       = 0x0b // 华为等设备填写用户MAC
+	ATTR_DELAYTIME       = 0x0c
+	ATTR_USERIPV6        = 0xf1
+	ATTR_USER_PRIVATE_IP = 0xf2
+)
+
+// AttrKind 属性值的编码方式
+type AttrKind int
+
+const (
+	KindBytes  AttrKind = iota
+	KindString          // 文本
+	KindUint32          // 4字节大端整数
+	KindUint64          // 8字节大端整数
+	KindIPv4
+	KindIPv6
+	KindMAC
+	KindIPList // 连续的IPv4地址
+)
+
+// AttrDef 属性定义，Min、Max为属性值的长度范围，Query表示请求中可以不带值
+type AttrDef struct {
+	Name     string
+	Kind     AttrKind
+	Min, Max int
+	Query    bool
+}
+
+// MaxAttrLen 属性长度字段包含2字节的类型和长度
+const MaxAttrLen = 255 - 2
+
+var attrDefs = map[byte]AttrDef{
+	ATTR_USERNAME:        {"UserName", KindString, 1, MaxAttrLen, false},
+	ATTR_PASSWORD:        {"PassWord", KindString, 1, MaxAttrLen, false},
+	ATTR_CHALLENGE:       {"Challenge", KindBytes, 16, 16, false},
+	ATTR_CHAPPASSWORD:    {"ChapPassWord", KindBytes, 16, 16, false},
+	ATTR_TEXTINFO:        {"TextInfo", KindString, 1, MaxAttrLen, false},
+	ATTR_UPLINKFLUX:      {"UpLinkFlux", KindUint64, 8, 8, true},
+	ATTR_DOWNLINKFLUX:    {"DownLinkFlux", KindUint64, 8, 8, true},
+	ATTR_PORT:            {"Port", KindString, 1, MaxAttrLen, false},
+	ATTR_IPCONFIG:        {"IP-Config", KindBytes, 1, MaxAttrLen, false},
+	ATTR_BASIP:           {"BAS-IP", KindIPv4, 4, 4, false},
+	ATTR_SESSIONID:       {"Session-ID", KindMAC, 6, 6, false},
+	ATTR_DELAYTIME:       {"Delay-Time", KindUint32, 4, 4, false},
+	ATTR_USERIPV6:        {"User-IPv6", KindIPv6, 16, 16, false},
+	ATTR_USER_PRIVATE_IP: {"User-Private-IP", KindIPv4, 4, 4, false},
+}
+
+// LookupAttr 返回标准属性的定义
+func LookupAttr(typ byte) (AttrDef, bool) {
+	d, ok := attrDefs[typ]
+	return d, ok
+}
+
+// Validate 检查属性值长度
+func (d AttrDef) Validate(val []byte) error {
+	if len(val) == 0 && d.Query {
+		return nil
+	}
+	if len(val) < d.Min || len(val) > d.Max {
+		if d.Min == d.Max {
+			return fmt.Errorf("%s: length %d, want %d", d.Name, len(val), d.Min)
+		}
+		return fmt.Errorf("%s: length %d, want %d-%d", d.Name, len(val), d.Min, d.Max)
+	}
+	return nil
+}
+
+// Encode 按属性类型编码属性值并检查长度，v可以是string、[]byte、uint32、uint64、net.IP、net.HardwareAddr或[]net.IP
+func (d AttrDef) Encode(v interface{}) ([]byte, error) {
+	var val []byte
+	switch x := v.(type) {
+	case string:
+		val = []byte(x)
+	case []byte:
+		val = x
+	case uint32:
+		val = binary.BigEndian.AppendUint32(nil, x)
+	case uint64:
+		val = binary.BigEndian.AppendUint64(nil, x)
+	case net.IP:
+		if d.Kind == KindIPv6 {
+			val = x.To16()
+		} else {
+			val = x.To4()
+		}
+	case net.HardwareAddr:
+		val = x
+	case []net.IP:
+		for _, ip := range x {
+			val = append(val, ip.To4()...)
+		}
+	default:
+		return nil, fmt.Errorf("%s: unsupported value type %T", d.Name, v)
+	}
+	if err := d.Validate(val); err != nil {
+		return nil, err
+	}
+	return val, nil
+}
+
+// Decode 按属性类型解码属性值，长度不符时返回原始字节
+func (d AttrDef) Decode(val []byte) interface{} {
+	if d.Validate(val) != nil {
+		return val
+	}
+	switch d.Kind {
+	case KindString:
+		return string(val)
+	case KindUint32:
+		return binary.BigEndian.Uint32(val)
+	case KindUint64:
+		if len(val) == 0 {
+			return uint64(0)
+		}
+		return binary.BigEndian.Uint64(val)
+	case KindIPv4, KindIPv6:
+		return net.IP(val)
+	case KindMAC:
+		return net.HardwareAddr(val)
+	case KindIPList:
+		ips := make([]net.IP, 0, len(val)/4)
+		for i := 0; i+4 <= len(val); i += 4 {
+			ips = append(ips, net.IPv4(val[i], val[i+1], val[i+2], val[i+3]).To4())
+		}
+		return ips
+	}
+	return val
+}
+
+// FindAttribute 返回报文中第一个指定类型属性的值
+func FindAttribute(m Message, typ byte) ([]byte, bool) {
+	for i := 0; i < m.AttributeLen(); i++ {
+		if a := m.Attribute(i); a.Type() == typ {
+			return a.Byte(), true
+		}
+	}
+	return nil, false
+}
+
+// TextInfo 返回报文的TextInfo属性，NAS在应答中以此说明错误原因
+func TextInfo(m Message) string {
+	val, _ := FindAttribute(m, ATTR_TEXTINFO)
+	return string(val)
+}
+
+// UserMAC 返回Session-ID属性中的用户MAC，长度不是6字节时返回nil
+func UserMAC(m Message) net.HardwareAddr {
+	val, ok := FindAttribute(m, ATTR_SESSIONID)
+	if !ok || len(val) != 6 {
+		return nil
+	}
+	return net.HardwareAddr(val)
+}
+
+// Flux 返回ACK_INFO中的上下行流量
+func Flux(m Message) (up, down uint64) {
+	if val, ok := FindAttribute(m, ATTR_UPLINKFLUX); ok && len(val) == 8 {
+		up = binary.BigEndian.Uint64(val)
+	}
+	if val, ok := FindAttribute(m, ATTR_DOWNLINKFLUX); ok && len(val) == 8 {
+		down = binary.BigEndian.Uint64(val)
+	}
+	return
+}
@@ -0,0 +1,79 @@
+package portal_test
+
+import (
+	"net"
+	"testing"
+
+	"syler/internal/portal"
+	v1 "syler/internal/portal/v1"
+	v2 "syler/internal/portal/v2"
+)
+
+func TestAttrEncode(t *testing.T) {
+	cases := []struct {
+		typ  byte
+		val  interface{}
+		want interface{}
+		ok   bool
+	}{
+		{portal.ATTR_USERNAME, "user", "user", true},
+		{portal.ATTR_USERNAME, "", nil, false},
+		{portal.ATTR_CHALLENGE, make([]byte, 15), nil, false},
+		{portal.ATTR_BASIP, net.IPv4(192, 168, 10, 1), "192.168.10.1", true},
+		{portal.ATTR_USERIPV6, net.ParseIP("2001:db8::1"), "2001:db8::1", true},
+		{portal.ATTR_SESSIONID, net.HardwareAddr{0, 1, 2, 3, 4, 5}, "00:01:02:03:04:05", true},
+		{portal.ATTR_SESSIONID, []byte{0, 1, 2}, nil, false},
+		{portal.ATTR_DELAYTIME, uint32(30), uint32(30), true},
+		{portal.ATTR_UPLINKFLUX, uint64(1 << 40), uint64(1 << 40), true},
+	}
+	for _, c := range cases {
+		def, _ := portal.LookupAttr(c.typ)
+		val, err := def.Encode(c.val)
+		if (err == nil) != c.ok {
+			t.Errorf("%s %v: err = %v", def.Name, c.val, err)
+			continue
+		}
+		if !c.ok {
+			continue
+		}
+		got := def.Decode(val)
+		if s, ok := got.(interface{ String() string }); ok {
+			got = s.String()
+		}
+		if got != c.want {
+			t.Errorf("%s: decoded %v, want %v", def.Name, got, c.want)
+		}
+	}
+
+	// 查询流量时属性可以不带值
+	def, _ := portal.LookupAttr(portal.ATTR_DOWNLINKFLUX)
+	if err := def.Validate(nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMessageAccessors(t *testing.T) {
+	mac := net.HardwareAddr{0xaa, 0xbb, 0xcc, 0, 1, 2}
+	for _, ver := range []portal.Version{new(v1.Version), new(v2.Version)} {
+		m := ver.NewChallenge(net.IPv4(10, 0, 0, 1), "secret")
+		if m.TextInfo() != "" || m.UserMAC() != nil {
+			t.Errorf("empty message: got %q %v", m.TextInfo(), m.UserMAC())
+		}
+		m.AddAttribute(portal.ATTR_TEXTINFO, []byte("账号已欠费"))
+		m.AddAttribute(portal.ATTR_SESSIONID, mac)
+		m = ver.Unmarshall(m.Bytes())
+		if m.TextInfo() != "账号已欠费" {
+			t.Errorf("TextInfo = %q", m.TextInfo())
+		}
+		if m.UserMAC().String() != mac.String() {
+			t.Errorf("UserMAC = %v", m.UserMAC())
+		}
+		if err := portal.Huawei.Validate(m); err != nil {
+			t.Error(err)
+		}
+		m.AddAttribute(portal.ATTR_BASIP, []byte{1, 2})
+		if err := portal.Huawei.Validate(m); err == nil {
+			t.Error("short BAS-IP accepted")
+		}
+	}
+}
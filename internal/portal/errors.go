@@ -0,0 +1,62 @@
+package portal
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrTimeout = fmt.Errorf("请求超时")
+
+// TimeoutError 请求重传后仍未收到应答，保留请求的序列号以便发送超时下线报文
+type TimeoutError struct {
+	Type     byte
+	SerialNo uint16
+	ReqId    uint16
+}
+
+func (e *TimeoutError) Error() string {
+	return ErrTimeout.Error()
+}
+
+func (e *TimeoutError) Is(target error) bool {
+	return target == ErrTimeout
+}
+
+// Error NAS应答报文中的错误码
+type Error struct {
+	Type      byte
+	Code      byte
+	Desc      string
+	Retryable bool   // 稍后重新发起请求可能成功
+	TextInfo  string // NAS在应答的TextInfo属性中给出的原因
+}
+
+func (e *Error) Error() string {
+	if e.TextInfo != "" {
+		return fmt.Sprintf("no. %d:%s(%s)", e.Code, e.Desc, e.TextInfo)
+	}
+	return fmt.Sprintf("no. %d:%s", e.Code, e.Desc)
+}
+
+// ErrorDef 错误码的描述及是否可重试
+type ErrorDef struct {
+	Desc      string
+	Retryable bool
+}
+
+// NewError 根据应答类型和错误码生成错误，使用华为的错误码定义
+func NewError(typ byte, code byte) *Error {
+	return Huawei.NewError(typ, code)
+}
+
+// IsRetryable 判断错误是否可以重新发起请求，超时和NAS繁忙类错误可以重试
+func IsRetryable(err error) bool {
+	if errors.Is(err, ErrTimeout) {
+		return true
+	}
+	var perr *Error
+	if errors.As(err, &perr) {
+		return perr.Retryable
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package portal
+
+import (
+	"fmt"
+	"strings"
+)
+
+var typeNames = map[byte]string{
+	REQ_CHALLENGE:  "REQ_CHALLENGE",
+	ACK_CHALLENGE:  "ACK_CHALLENGE",
+	REQ_AUTH:       "REQ_AUTH",
+	ACK_AUTH:       "ACK_AUTH",
+	REQ_LOGOUT:     "REQ_LOGOUT",
+	ACK_LOGOUT:     "ACK_LOGOUT",
+	AFF_ACK_AUTH:   "AFF_ACK_AUTH",
+	NTF_LOGOUT:     "NTF_LOGOUT",
+	REQ_INFO:       "REQ_INFO",
+	ACK_INFO:       "ACK_INFO",
+	ACK_NTF_LOGOUT: "ACK_NTF_LOGOUT",
+
+	REQ_MACINFO:          "REQ_MACINFO",
+	ACK_MACINFO:          "ACK_MACINFO",
+	NTF_USERDISCOVERY:    "NTF_USERDISCOVERY",
+	NTF_USERIPCHANGE:     "NTF_USERIPCHANGE",
+	AFF_NTF_USERIPCHANGE: "AFF_NTF_USERIPCHANGE",
+}
+
+// TypeName 返回报文类型名称
+func TypeName(typ byte) string {
+	if name, ok := typeNames[typ]; ok {
+		return name
+	}
+	return fmt.Sprintf("UNKNOWN(0x%02x)", typ)
+}
+
+// AttrName 返回属性类型名称
+func AttrName(typ byte) string {
+	if d, ok := LookupAttr(typ); ok {
+		return d.Name
+	}
+	return fmt.Sprintf("Attr(0x%02x)", typ)
+}
+
+// Format 以便于阅读的形式输出报文头和属性
+func Format(m Message) string {
+	return Huawei.Format(m)
+}
+
+// Format 按厂商方言输出报文头和属性
+func (v *Vendor) Format(m Message) string {
+	b := new(strings.Builder)
+	fmt.Fprintf(b, "%s serial=%d req_id=%d user_ip=%s err_code=%d attrs=%d\n",
+		v.TypeName(m.Type()), m.SerialId(), m.ReqId(), m.UserIp(), m.ErrCode(), m.AttributeLen())
+	for i := 0; i < m.AttributeLen(); i++ {
+		a := m.Attribute(i)
+		fmt.Fprintf(b, "  %-15s len=%-3d %s\n", v.AttrName(a.Type()), len(a.Byte()), v.formatValue(a))
+	}
+	return b.String()
+}
+
+func (v *Vendor) formatValue(a Attribute) string {
+	d, ok := v.LookupAttr(a.Type())
+	if !ok || d.Kind == KindBytes || len(a.Byte()) == 0 {
+		return fmt.Sprintf("%x", a.Byte())
+	}
+	// 密码不以明文输出
+	if a.Type() == ATTR_PASSWORD {
+		return "******"
+	}
+	switch val := d.Decode(a.Byte()).(type) {
+	case string:
+		return fmt.Sprintf("%q", val)
+	case []byte:
+		return fmt.Sprintf("%x", val)
+	default:
+		return fmt.Sprint(val)
+	}
+}
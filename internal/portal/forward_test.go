@@ -0,0 +1,109 @@
+package portal_test
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"syler/internal/portal"
+	v2 "syler/internal/portal/v2"
+)
+
+type fakeForwarder struct {
+	mu        sync.Mutex
+	claimed   map[uint16]bool
+	released  map[uint16]bool
+	forwarded chan uint16
+}
+
+func (f *fakeForwarder) Claim(nas net.IP, serial uint16, ttl time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.claimed[serial] = true
+}
+
+func (f *fakeForwarder) Release(nas net.IP, serial uint16) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.released[serial] = true
+}
+
+func (f *fakeForwarder) Forward(src *net.UDPAddr, serial uint16, data []byte) bool {
+	f.forwarded <- serial
+	return true
+}
+
+func TestForward(t *testing.T) {
+	portal.SetVersion(new(v2.Version))
+	portal.RegisterFallBack(func(m portal.Message, ip net.IP) {
+		t.Errorf("response reached fallback: type %d", m.Type())
+	})
+	go portal.ListenAndService("127.0.0.1:0")
+	for !portal.Bound() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	f := &fakeForwarder{claimed: map[uint16]bool{}, released: map[uint16]bool{}, forwarded: make(chan uint16, 4)}
+	portal.SetForwarder(f)
+	defer portal.SetForwarder(nil)
+
+	nas, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nas.Close()
+	ip := net.IPv4(127, 0, 0, 1)
+	port := nas.LocalAddr().(*net.UDPAddr).Port
+	portal.RegisterNas(portal.Nas{IP: ip, Port: port, Secret: "secret", RetryInterval: time.Second})
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := portal.Challenge(context.Background(), net.IPv4(10, 0, 0, 9), "secret", ip, port)
+		done <- err
+	}()
+
+	buf := make([]byte, 4096)
+	n, portalAddr, err := nas.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := new(v2.Version).Unmarshall(buf[:n]).(*v2.T_Message)
+	res := *req
+	res.Header.Type = portal.ACK_CHALLENGE
+	res.Attrs = []v2.T_Attr{{AttrType: portal.ATTR_CHALLENGE, AttrLen: 16, AttrStr: make([]byte, 16)}}
+	res.Header.AttrNum = 1
+	res.AuthBy("secret")
+
+	// 本节点没有等待的应答交给Forwarder
+	other := res
+	other.Header.SerialNo = req.Header.SerialNo + 1
+	nas.WriteToUDP(other.Bytes(), portalAddr)
+	select {
+	case serial := <-f.forwarded:
+		if serial != other.Header.SerialNo {
+			t.Errorf("forwarded serial %d, want %d", serial, other.Header.SerialNo)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("unmatched response was not forwarded")
+	}
+
+	// 其他节点转来的应答交给等待中的请求
+	portal.Deliver(nas.LocalAddr().(*net.UDPAddr), res.Bytes())
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	f.mu.Lock()
+	if !f.claimed[req.Header.SerialNo] || !f.released[req.Header.SerialNo] {
+		t.Errorf("transaction %d not claimed and released", req.Header.SerialNo)
+	}
+	f.mu.Unlock()
+
+	// 转来的重复应答直接丢弃，不再转发
+	portal.Deliver(nas.LocalAddr().(*net.UDPAddr), other.Bytes())
+	select {
+	case <-f.forwarded:
+		t.Error("forwarded response was forwarded again")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,68 @@
+package portal
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// Nas 单台NAS的Portal参数，未登记的NAS使用DefaultNas
+type Nas struct {
+	IP            net.IP
+	Port          int
+	Secret        string
+	Retries       int           // 未收到应答时使用相同序列号重传的次数
+	RetryInterval time.Duration // 每次发送后等待应答的时长
+	Version       Version       // 为空时使用SetVersion设置的版本
+	Vendor        *Vendor       // 为空时使用华为方言
+	BasIP         net.IP        // BAS-IP属性的值，为空时使用IP
+	IdleTimeout   time.Duration // 超过该时长未收到心跳的用户强制下线，0表示不检测
+	RedirectAuth  string        // HTTP接口校验用户参数的方式：none、hmac、source
+	RedirectKey   string        // hmac方式的签名密钥，为空时使用Secret
+}
+
+var DefaultNas = Nas{Port: 2000}
+var nasTable = make(map[string]Nas)
+var nasTableLock sync.RWMutex
+
+// RegisterNas 登记一台NAS的参数
+func RegisterNas(n Nas) {
+	nasTableLock.Lock()
+	defer nasTableLock.Unlock()
+	nasTable[n.IP.String()] = n
+}
+
+// Registered NAS是否已登记。未登记任何NAS时所有NAS都使用DefaultNas，视为已登记
+func Registered(ip net.IP) bool {
+	nasTableLock.RLock()
+	defer nasTableLock.RUnlock()
+	if len(nasTable) == 0 {
+		return true
+	}
+	_, ok := nasTable[ip.String()]
+	return ok
+}
+
+// NasFor 返回NAS的参数，未登记时返回DefaultNas
+func NasFor(ip net.IP) Nas {
+	nasTableLock.RLock()
+	n, ok := nasTable[ip.String()]
+	nasTableLock.RUnlock()
+	if !ok {
+		n = DefaultNas
+		n.IP = ip
+	}
+	if n.RetryInterval <= 0 {
+		n.RetryInterval = time.Duration(Timeout) * time.Second
+	}
+	if n.Retries < 0 {
+		n.Retries = 0
+	}
+	if n.Version == nil {
+		n.Version = Ver
+	}
+	if n.Vendor == nil {
+		n.Vendor = Huawei
+	}
+	return n
+}
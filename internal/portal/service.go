@@ -1,20 +1,47 @@
 package portal
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"math"
 	"math/rand"
 	"net"
 	"syler/internal/logger"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/sirupsen/logrus"
 )
 
-var conn *net.UDPConn
+var conn *net.UDPConn // 第一个监听的端口，未收到过NAS报文时用于发送
+var nasConns sync.Map // NAS IP -> 最近收到该NAS报文的端口，应答和请求从同一端口发出
 var cb_fallback func(Message, net.IP)
 var Ver Version
-var expect = make(map[uint16]chan Message)
-var errTimeout = fmt.Errorf("请求超时")
-var Timeout = 8 // Potal响应报文等待最大时长
+var expect = make(map[txKey]chan Message)
+var answered = make(map[txKey]time.Time) // 已完成的请求，用于丢弃重复的应答
+var expectLock sync.Mutex
+var Timeout = 8 // Potal响应报文等待最大时长，NAS未配置重传间隔时使用
+var bound atomic.Int32
+var forwarder atomic.Pointer[Forwarder]
+var tap atomic.Pointer[func(src, dst *net.UDPAddr, data []byte)]
+var nasStates = make(map[string]NasState)
+var nasStatesLock sync.Mutex
+var serialNo atomic.Uint32
+
+func init() {
+	serialNo.Store(rand.Uint32())
+}
+
+// txKey 标识一次等待应答的请求，不同NAS的序列号互相独立
+type txKey struct {
+	nas    string
+	serial uint16
+}
+
+func newTxKey(nas net.IP, serial uint16) txKey {
+	return txKey{nas: nas.String(), serial: serial}
+}
 
 const (
 	_              = iota
@@ -31,15 +58,41 @@ const (
 	ACK_NTF_LOGOUT = 0x0e
 )
 
+// 华为Portal 2.0中NAS主动发起的报文
+const (
+	REQ_MACINFO          = 0x30 // NAS查询MAC地址绑定的用户，用于MAC优先的无感知认证
+	ACK_MACINFO          = 0x31
+	NTF_USERDISCOVERY    = 0x32 // NAS发现新用户，携带用户IP和MAC
+	NTF_USERIPCHANGE     = 0x33 // 用户IP变化，报文头为新IP，Session-ID为用户MAC
+	AFF_NTF_USERIPCHANGE = 0x34
+)
+
+// NasState 记录与NAS最近一次交互的结果，用于健康检查
+type NasState struct {
+	LastSuccess time.Time `json:"last_success"`
+	LastFailure time.Time `json:"last_failure"`
+}
+
+// Reachable 最近一次交互成功（或从未失败）时认为NAS可达
+func (s NasState) Reachable() bool {
+	return !s.LastSuccess.Before(s.LastFailure)
+}
+
 type Message interface {
 	Bytes() []byte
 	Type() byte
 	ReqId() uint16
 	SerialId() uint16
 	UserIp() net.IP
+	ErrCode() byte
+	TextInfo() string
+	UserMAC() net.HardwareAddr
 	CheckFor(Message, string) error
+	Verify(string) bool // 校验NAS主动发送的报文的Authenticator
 	AttributeLen() int
 	Attribute(int) Attribute
+	AddAttribute(byte, []byte)
+	Sign(string)
 }
 
 type Attribute interface {
@@ -57,10 +110,15 @@ type Version interface {
 	IsResponse(Message) bool
 	NewChallenge(net.IP, string) Message
 	NewAuth(net.IP, string, []byte, []byte, uint16, []byte) Message
+	NewPapAuth(net.IP, string, []byte, []byte) Message
 	NewAffAckAuth(net.IP, string, uint16, uint16) Message
 	NewLogout(net.IP, string) Message
+	NewTimeoutLogout(net.IP, string, uint16, uint16) Message
 	NewReqInfo(net.IP, string) Message
 	NewAckNtfLogout(net.IP, string, uint16, uint16) Message
+	NewAckUserHeartbeat(net.IP, string, uint16, uint16) Message
+	NewAckMacInfo(net.IP, string, uint16, uint16, byte) Message
+	NewAffAckUserIpChange(net.IP, string, uint16, uint16) Message
 }
 
 func RegisterFallBack(f func(Message, net.IP)) {
@@ -71,8 +129,61 @@ func SetVersion(v Version) {
 	Ver = v
 }
 
+// SetTap 设置抓包回调，收发的每个数据报都会以原始字节交给f，f为nil时关闭
+func SetTap(f func(src, dst *net.UDPAddr, data []byte)) {
+	if f == nil {
+		tap.Store(nil)
+		return
+	}
+	tap.Store(&f)
+}
+
+func capture(src, dst net.Addr, data []byte) {
+	if f := tap.Load(); f != nil {
+		s, _ := src.(*net.UDPAddr)
+		d, _ := dst.(*net.UDPAddr)
+		(*f)(s, d, data)
+	}
+}
+
+// Forwarder 集群模式下在节点间转发应答，使应答到达任一节点时都能交给发出请求的节点
+type Forwarder interface {
+	// Claim 发送需要应答的请求前登记本节点为该事务的处理者
+	Claim(nas net.IP, serial uint16, ttl time.Duration)
+	// Release 事务结束
+	Release(nas net.IP, serial uint16)
+	// Forward 本节点没有等待该应答时转交给发出请求的节点，返回是否已转交
+	Forward(src *net.UDPAddr, serial uint16, data []byte) bool
+}
+
+// SetForwarder 设置集群转发，f为nil时关闭
+func SetForwarder(f Forwarder) {
+	if f == nil {
+		forwarder.Store(nil)
+		return
+	}
+	forwarder.Store(&f)
+}
+
+// write 发送数据报并交给抓包回调
+func write(data []byte, dest *net.UDPAddr) error {
+	c := conn
+	if v, ok := nasConns.Load(dest.IP.String()); ok {
+		c = v.(*net.UDPConn)
+	}
+	if c == nil {
+		return fmt.Errorf("portal server is not listening")
+	}
+	_, err := c.WriteTo(data, dest)
+	if err == nil {
+		capture(c.LocalAddr(), dest, data)
+	}
+	return err
+}
+
+// ListenAndService 监听一个Portal端口，可对多个地址分别调用
 func ListenAndService(addr string) (err error) {
-	log := logger.GetLogger()
+	log := logger.Subsystem(logger.Portal)
 
 	var ad *net.UDPAddr
 	ad, err = net.ResolveUDPAddr("udp", addr)
@@ -81,87 +192,274 @@ func ListenAndService(addr string) (err error) {
 		return
 	}
 
-	conn, err = net.ListenUDP("udp", ad)
+	c, err := net.ListenUDP("udp", ad)
 	if err != nil {
 		log.Fatalf("Failed to listen on UDP port: %v", err)
 		return
 	}
+	if bound.Add(1) == 1 {
+		conn = c
+	}
+	defer bound.Add(-1)
 
 	for {
 		data := make([]byte, 4096)
-		n, saddr, err := conn.ReadFromUDP(data)
+		n, saddr, err := c.ReadFromUDP(data)
 		if err != nil {
 			return err
 		}
-		go func(bts []byte) {
-			message := Ver.Unmarshall(bts)
-			if c, ok := expect[message.SerialId()]; ok {
-				c <- message
-			} else {
-				log.Print("get a active message, type: ", message.Type())
-				cb_fallback(message, saddr.IP)
-			}
-		}(data[:n])
+		markNas(saddr.IP, true)
+		nasConns.Store(saddr.IP.String(), c)
+		capture(saddr, c.LocalAddr(), data[:n])
+		go handle(saddr, data[:n], false)
 	}
+}
 
-	return
+// Deliver 处理其他节点转来的应答报文
+func Deliver(src *net.UDPAddr, data []byte) {
+	handle(src, data, true)
 }
 
-func Send(mess Message, dest net.IP, port int, secret string, sync bool) (Message, error) {
-	defer func() {
-		delete(expect, mess.SerialId())
-	}()
+func handle(src *net.UDPAddr, bts []byte, forwarded bool) {
+	log := logger.Subsystem(logger.Portal)
+
+	ver := NasFor(src.IP).Version
+	message := ver.Unmarshall(bts)
+	if dispatch(src.IP, message) {
+		return
+	}
+	if ver.IsResponse(message) {
+		if isAnswered(src.IP, message.SerialId()) {
+			log.WithFields(logrus.Fields{
+				"type":      message.Type(),
+				"serial_no": message.SerialId(),
+			}).Debug("Drop duplicate portal response")
+			return
+		}
+		if forwarded {
+			return
+		}
+		if f := forwarder.Load(); f != nil && (*f).Forward(src, message.SerialId(), bts) {
+			log.WithFields(logrus.Fields{
+				"type":      message.Type(),
+				"serial_no": message.SerialId(),
+			}).Debug("Forwarded portal response to owning node")
+			return
+		}
+	}
+	if forwarded {
+		return
+	}
+	log.WithFields(logrus.Fields{
+		"type":      message.Type(),
+		"serial_no": message.SerialId(),
+		"nas_ip":    src.IP.String(),
+	}).Debug("Received NAS initiated portal message")
+	cb_fallback(message, src.IP)
+}
+
+// Send 发送报文，sync为true时等待应答。未收到应答时按NAS的重传策略使用相同序列号重传。
+// ctx中的请求ID记录在日志中，ctx结束不会中断重传
+func Send(ctx context.Context, mess Message, dest net.IP, port int, secret string, sync bool) (Message, error) {
 	receiver, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", dest.String(), port))
-	conn.WriteTo(mess.Bytes(), receiver)
 	if err != nil {
 		return nil, err
 	}
+	log := logger.FromContext(ctx).WithFields(logrus.Fields{
+		"type":          mess.Type(),
+		"serial_no":     mess.SerialId(),
+		"portal_req_id": mess.ReqId(),
+		"nas_ip":        dest.String(),
+	})
+	nas := NasFor(dest)
+	if isRequest(mess.Type()) {
+		if err := nas.Vendor.prepare(mess, nas, secret); err != nil {
+			return nil, err
+		}
+		if err := nas.Vendor.Validate(mess); err != nil {
+			return nil, err
+		}
+	}
 	if !sync {
-		return nil, nil
+		log.Debug("Sending portal message")
+		return nil, write(mess.Bytes(), receiver)
+	}
+
+	key := newTxKey(dest, mess.SerialId())
+	c := make(chan Message, 1)
+	expectLock.Lock()
+	expect[key] = c
+	expectLock.Unlock()
+	defer finish(key, nas)
+	if f := forwarder.Load(); f != nil {
+		(*f).Claim(dest, mess.SerialId(), nas.RetryInterval*time.Duration(nas.Retries+1))
+		defer (*f).Release(dest, mess.SerialId())
+	}
+
+	bts := mess.Bytes()
+	for try := 0; try <= nas.Retries; try++ {
+		if try == 0 {
+			log.Debug("Sending portal message")
+		} else {
+			log.WithField("retry", try).Warn("No response from NAS, retransmitting portal message")
+		}
+		if err = write(bts, receiver); err != nil {
+			return nil, err
+		}
+		select {
+		case res := <-c:
+			err = res.CheckFor(mess, secret)
+			var perr *Error
+			if errors.As(err, &perr) {
+				verr := nas.Vendor.NewError(perr.Type, perr.Code)
+				verr.TextInfo = res.TextInfo()
+				err = verr
+			}
+			return res, err
+		case <-time.After(nas.RetryInterval):
+		}
+	}
+	markNas(dest, false)
+	return nil, &TimeoutError{Type: mess.Type(), SerialNo: mess.SerialId(), ReqId: mess.ReqId()}
+}
+
+// dispatch 将NAS的应答交给等待中的请求，返回是否已处理
+func dispatch(nas net.IP, message Message) bool {
+	expectLock.Lock()
+	c, ok := expect[newTxKey(nas, message.SerialId())]
+	expectLock.Unlock()
+	if !ok {
+		return false
 	}
-	c := make(chan Message)
-	expect[mess.SerialId()] = c
-	// 发送数据
 	select {
-	case res := <-c:
-		return res, res.CheckFor(mess, secret)
-	case <-time.After(time.Duration(Timeout) * time.Second):
-		return nil, errTimeout
+	case c <- message:
+	default:
+		// 重传导致的重复应答，已有应答在处理中
 	}
+	return true
 }
 
-func Challenge(userip net.IP, secret string, basip net.IP, basport int) (res Message, err error) {
-	cha := Ver.NewChallenge(userip, secret)
-	return Send(cha, basip, basport, secret, true)
+// finish 结束一次请求，在一段时间内记住该序列号以丢弃迟到的重复应答
+func finish(key txKey, nas Nas) {
+	expectLock.Lock()
+	defer expectLock.Unlock()
+	delete(expect, key)
+	now := time.Now()
+	for k, t := range answered {
+		if now.After(t) {
+			delete(answered, k)
+		}
+	}
+	answered[key] = now.Add(nas.RetryInterval * time.Duration(nas.Retries+1))
 }
 
-func Logout(userip net.IP, secret string, basip net.IP, basport int) (res Message, err error) {
-	cha := Ver.NewLogout(userip, secret)
-	return Send(cha, basip, basport, secret, true)
+func isAnswered(nas net.IP, serial uint16) bool {
+	expectLock.Lock()
+	defer expectLock.Unlock()
+	t, ok := answered[newTxKey(nas, serial)]
+	return ok && time.Now().Before(t)
 }
 
-func ChapAuth(userip net.IP, secret string, basip net.IP, basport int, username, userpwd []byte, reqid uint16, cha []byte) (res Message, err error) {
-	auth := Ver.NewAuth(userip, secret, username, userpwd, reqid, cha)
-	return Send(auth, basip, basport, secret, true)
+// Bound 返回Portal UDP端口是否已监听
+func Bound() bool {
+	return bound.Load() > 0
 }
 
-func AffAckAuth(userip net.IP, secret string, basip net.IP, basport int, serial uint16, reqid uint16) (Message, error) {
-	AffAckAuth := Ver.NewAffAckAuth(userip, secret, serial, reqid)
-	return Send(AffAckAuth, basip, basport, secret, false)
+// NasStates 返回所有交互过的NAS的最近状态
+func NasStates() map[string]NasState {
+	nasStatesLock.Lock()
+	defer nasStatesLock.Unlock()
+	states := make(map[string]NasState, len(nasStates))
+	for k, v := range nasStates {
+		states[k] = v
+	}
+	return states
+}
+
+func markNas(ip net.IP, ok bool) {
+	nasStatesLock.Lock()
+	defer nasStatesLock.Unlock()
+	state := nasStates[ip.String()]
+	if ok {
+		state.LastSuccess = time.Now()
+	} else {
+		state.LastFailure = time.Now()
+	}
+	nasStates[ip.String()] = state
 }
 
-func ReqInfo(userip net.IP, secret string, basip net.IP, basport int) (Message, error) {
-	ReqInfo := Ver.NewReqInfo(userip, secret)
-	return Send(ReqInfo, basip, basport, secret, true)
+func Challenge(ctx context.Context, userip net.IP, secret string, basip net.IP, basport int) (res Message, err error) {
+	cha := NasFor(basip).Version.NewChallenge(userip, secret)
+	return Send(ctx, cha, basip, basport, secret, true)
+}
+
+func Logout(ctx context.Context, userip net.IP, secret string, basip net.IP, basport int) (res Message, err error) {
+	cha := NasFor(basip).Version.NewLogout(userip, secret)
+	return Send(ctx, cha, basip, basport, secret, true)
+}
+
+// CancelAuth 请求超时后发送ErrCode为1的REQ_LOGOUT，通知NAS取消正在进行的认证
+func CancelAuth(ctx context.Context, userip net.IP, secret string, basip net.IP, basport int, serial uint16, reqid uint16) (Message, error) {
+	logout := NasFor(basip).Version.NewTimeoutLogout(userip, secret, serial, reqid)
+	return Send(ctx, logout, basip, basport, secret, false)
+}
+
+func ChapAuth(ctx context.Context, userip net.IP, secret string, basip net.IP, basport int, username, userpwd []byte, reqid uint16, cha []byte) (res Message, err error) {
+	auth := NasFor(basip).Version.NewAuth(userip, secret, username, userpwd, reqid, cha)
+	return Send(ctx, auth, basip, basport, secret, true)
+}
+
+// PapAuth 使用PAP方式认证，无需先请求Challenge
+func PapAuth(ctx context.Context, userip net.IP, secret string, basip net.IP, basport int, username, userpwd []byte) (res Message, err error) {
+	auth := NasFor(basip).Version.NewPapAuth(userip, secret, username, userpwd)
+	return Send(ctx, auth, basip, basport, secret, true)
+}
+
+func AffAckAuth(ctx context.Context, userip net.IP, secret string, basip net.IP, basport int, serial uint16, reqid uint16) (Message, error) {
+	AffAckAuth := NasFor(basip).Version.NewAffAckAuth(userip, secret, serial, reqid)
+	return Send(ctx, AffAckAuth, basip, basport, secret, false)
+}
+
+func ReqInfo(ctx context.Context, userip net.IP, secret string, basip net.IP, basport int) (Message, error) {
+	ReqInfo := NasFor(basip).Version.NewReqInfo(userip, secret)
+	return Send(ctx, ReqInfo, basip, basport, secret, true)
+}
+
+func AckNtfLogout(ctx context.Context, userip net.IP, secret string, basip net.IP, basport int, serial uint16, reqid uint16) (Message, error) {
+	AckNtfLogout := NasFor(basip).Version.NewAckNtfLogout(userip, secret, serial, reqid)
+	return Send(ctx, AckNtfLogout, basip, basport, secret, false)
+}
+
+// AckUserHeartbeat 应答H3C设备的NTF_USER_HEARTBEAT
+func AckUserHeartbeat(ctx context.Context, userip net.IP, secret string, basip net.IP, basport int, serial uint16, reqid uint16) (Message, error) {
+	ack := NasFor(basip).Version.NewAckUserHeartbeat(userip, secret, serial, reqid)
+	return Send(ctx, ack, basip, basport, secret, false)
+}
+
+// AckMacInfo 应答REQ_MACINFO，username为空时ErrCode为1表示MAC未绑定
+func AckMacInfo(ctx context.Context, userip net.IP, secret string, basip net.IP, basport int, serial uint16, reqid uint16, mac net.HardwareAddr, username string) (Message, error) {
+	var errCode byte = 1
+	if username != "" {
+		errCode = 0
+	}
+	ack := NasFor(basip).Version.NewAckMacInfo(userip, secret, serial, reqid, errCode)
+	if mac != nil {
+		ack.AddAttribute(ATTR_SESSIONID, mac)
+	}
+	if username != "" {
+		ack.AddAttribute(ATTR_USERNAME, []byte(username))
+	}
+	ack.Sign(secret)
+	return Send(ctx, ack, basip, basport, secret, false)
 }
 
-func AckNtfLogout(userip net.IP, secret string, basip net.IP, basport int, serial uint16, reqid uint16) (Message, error) {
-	AckNtfLogout := Ver.NewAckNtfLogout(userip, secret, serial, reqid)
-	return Send(AckNtfLogout, basip, basport, secret, false)
+// AffAckUserIpChange 确认NTF_USERIPCHANGE
+func AffAckUserIpChange(ctx context.Context, userip net.IP, secret string, basip net.IP, basport int, serial uint16, reqid uint16) (Message, error) {
+	ack := NasFor(basip).Version.NewAffAckUserIpChange(userip, secret, serial, reqid)
+	return Send(ctx, ack, basip, basport, secret, false)
 }
 
+// NewSerialNo 返回下一个请求序列号。从随机值开始递增，65536个请求内不会重复
 func NewSerialNo() uint16 {
-	rand.Seed(time.Now().UnixNano())
-	r := rand.Intn(math.MaxUint16)
-	return uint16(r)
+	return uint16(serialNo.Add(1))
 }
@@ -0,0 +1,152 @@
+package portal_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"syler/internal/portal"
+	v2 "syler/internal/portal/v2"
+)
+
+// fakeNas 丢弃前drop个请求，之后对每个请求应答两次
+func fakeNas(t *testing.T, drop int) *net.UDPConn {
+	nas, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, addr, err := nas.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if drop > 0 {
+				drop--
+				continue
+			}
+			req := new(v2.Version).Unmarshall(buf[:n]).(*v2.T_Message)
+			res := *req
+			res.Header.Type = portal.ACK_CHALLENGE
+			res.Header.ReqIdentifier = 1
+			res.Attrs = []v2.T_Attr{{AttrType: 3, AttrLen: 16, AttrStr: make([]byte, 16)}}
+			res.Header.AttrNum = 1
+			res.AuthBy("secret")
+			nas.WriteToUDP(res.Bytes(), addr)
+			nas.WriteToUDP(res.Bytes(), addr)
+		}
+	}()
+	return nas
+}
+
+func TestRetransmit(t *testing.T) {
+	portal.SetVersion(new(v2.Version))
+	active := make(chan portal.Message, 4)
+	portal.RegisterFallBack(func(m portal.Message, ip net.IP) { active <- m })
+	go portal.ListenAndService("127.0.0.1:0")
+	for !portal.Bound() {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	nas := fakeNas(t, 1)
+	defer nas.Close()
+	ip := net.IPv4(127, 0, 0, 1)
+	port := nas.LocalAddr().(*net.UDPAddr).Port
+	portal.RegisterNas(portal.Nas{IP: ip, Port: port, Secret: "secret", Retries: 1, RetryInterval: 200 * time.Millisecond})
+
+	res, err := portal.Challenge(context.Background(), net.IPv4(10, 0, 0, 2), "secret", ip, port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Type() != portal.ACK_CHALLENGE {
+		t.Errorf("got type %d", res.Type())
+	}
+	select {
+	case m := <-active:
+		t.Errorf("duplicate ACK reached fallback: %d", m.Type())
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// 重传次数用尽后超时
+	nas2 := fakeNas(t, 2)
+	defer nas2.Close()
+	port2 := nas2.LocalAddr().(*net.UDPAddr).Port
+	portal.RegisterNas(portal.Nas{IP: ip, Port: port2, Secret: "secret", Retries: 1, RetryInterval: 100 * time.Millisecond})
+	if _, err := portal.Challenge(context.Background(), net.IPv4(10, 0, 0, 3), "secret", ip, port2); !errors.Is(err, portal.ErrTimeout) {
+		t.Errorf("want timeout, got %v", err)
+	}
+}
+
+// 其他NAS发来相同序列号的应答不会被当作等待中请求的应答
+func TestResponseFromOtherNas(t *testing.T) {
+	portal.SetVersion(new(v2.Version))
+	portal.RegisterFallBack(func(m portal.Message, ip net.IP) {})
+	go portal.ListenAndService("127.0.0.1:0")
+	for !portal.Bound() {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	nas, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nas.Close()
+	other, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2)})
+	if err != nil {
+		t.Skip(err)
+	}
+	defer other.Close()
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, addr, err := nas.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			req := new(v2.Version).Unmarshall(buf[:n]).(*v2.T_Message)
+			res := *req
+			res.Header.Type = portal.ACK_CHALLENGE
+			res.Attrs = []v2.T_Attr{{AttrType: 3, AttrLen: 16, AttrStr: make([]byte, 16)}}
+			res.Header.AttrNum = 1
+			res.AuthBy("secret")
+			other.WriteToUDP(res.Bytes(), addr)
+		}
+	}()
+
+	ip := net.IPv4(127, 0, 0, 1)
+	port := nas.LocalAddr().(*net.UDPAddr).Port
+	portal.RegisterNas(portal.Nas{IP: ip, Port: port, Secret: "secret", Retries: 0, RetryInterval: 200 * time.Millisecond})
+	if _, err := portal.Challenge(context.Background(), net.IPv4(10, 0, 0, 4), "secret", ip, port); !errors.Is(err, portal.ErrTimeout) {
+		t.Errorf("response from another NAS accepted: %v", err)
+	}
+}
+
+func TestNewSerialNo(t *testing.T) {
+	seen := make(map[uint16]bool)
+	for i := 0; i < 1000; i++ {
+		n := portal.NewSerialNo()
+		if seen[n] {
+			t.Fatalf("serial %d repeated after %d requests", n, i)
+		}
+		seen[n] = true
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{portal.ErrTimeout, true},
+		{portal.NewError(portal.ACK_CHALLENGE, 3), true},
+		{portal.NewError(portal.ACK_AUTH, 1), false},
+	}
+	for _, c := range cases {
+		if got := portal.IsRetryable(c.err); got != c.want {
+			t.Errorf("%v: got %v, want %v", c.err, got, c.want)
+		}
+	}
+}
@@ -1,32 +1,51 @@
 package v1
 
 import (
-	"fmt"
+	"bytes"
+	"crypto/md5"
 	"net"
 	"testing"
+
+	"syler/internal/portal"
 )
 
 func TestChallange(t *testing.T) {
 	v := &Version{}
 	ip := net.IPv4(192, 168, 56, 2)
-	fmt.Println(v.NewChallenge(ip, "it is a secret"))
+	msg := v.NewChallenge(ip, "it is a secret")
+	res := v.Unmarshall(msg.Bytes())
+	if res.Type() != portal.REQ_CHALLENGE || res.SerialId() != msg.SerialId() || !res.UserIp().Equal(ip) {
+		t.Errorf("round trip mismatch: %v", res)
+	}
 }
 
 func TestAuth(t *testing.T) {
 	v := &Version{}
 	ip := net.IPv4(192, 168, 56, 2)
-	// TODO: 需要challenge的值才能正确生成Auth消息
 	challenge := []byte("challenge")
-	fmt.Println(v.NewAuth(ip, "it is a secret", []byte("刘铭"), []byte("456"), uint16(1234), challenge))
-}
-
-func TestRunChallange(t *testing.T) {
-	// TODO: 实现Challenge函数或修改测试
-	Challenge(net.IPv4(192, 168, 1, 1), "it is a secret", net.IPv4(192, 168, 56, 2))
+	msg := v.NewAuth(ip, "it is a secret", []byte("刘铭"), []byte("456"), uint16(1234), challenge)
+	hash := md5.New()
+	hash.Write([]byte{byte(1234 & 0xff)})
+	hash.Write([]byte("456"))
+	hash.Write(challenge)
+	res := v.Unmarshall(msg.Bytes())
+	if res.AttributeLen() != 3 || !bytes.Equal(res.Attribute(2).Byte(), hash.Sum(nil)) {
+		t.Errorf("unexpected CHAP password: %v", res)
+	}
 }
 
 func TestUnmarshal(t *testing.T) {
 	v := &Version{}
-	msg := v.Unmarshall([]byte{0x01, 0x02, 0x00, 0x00, 0x6f, 0x3c, 0x00, 0x06, 0xc0, 0xa8, 0x0a, 0xfe, 0x00, 0x00, 0x00, 0x01, 0x03, 0x12, 0xef, 0x47, 0x25, 0x3d, 0xc5, 0x19, 0x41, 0xb7, 0x63, 0x97, 0x35, 0x07, 0x75, 0xe7, 0x3d, 0x95})
-	fmt.Println(msg)
+	data := []byte{0x01, 0x02, 0x00, 0x00, 0x6f, 0x3c, 0x00, 0x06, 0xc0, 0xa8, 0x0a, 0xfe, 0x00, 0x00, 0x00, 0x01, 0x03, 0x12, 0xef, 0x47, 0x25, 0x3d, 0xc5, 0x19, 0x41, 0xb7, 0x63, 0x97, 0x35, 0x07, 0x75, 0xe7, 0x3d, 0x95}
+	msg := v.Unmarshall(data)
+	if msg.Type() != portal.ACK_CHALLENGE || msg.SerialId() != 0x6f3c || msg.ReqId() != 6 || !msg.UserIp().Equal(net.IPv4(192, 168, 10, 254)) {
+		t.Errorf("unexpected header: type %d serial %#x req %d user %s", msg.Type(), msg.SerialId(), msg.ReqId(), msg.UserIp())
+	}
+	cres, ok := msg.(portal.ChallengeRes)
+	if !ok || msg.AttributeLen() != 1 || !bytes.Equal(cres.GetChallenge(), data[18:]) {
+		t.Errorf("unexpected challenge attribute: %v", msg)
+	}
+	if !bytes.Equal(msg.Bytes(), data) {
+		t.Errorf("re-encoded message differs: %x", msg.Bytes())
+	}
 }
@@ -19,6 +19,12 @@ func (v *Version) NewLogout(userip net.IP, secret string) portal.Message {
 	return newMessage(portal.REQ_LOGOUT, userip, secret, portal.NewSerialNo(), 0)
 }
 
+func (v *Version) NewTimeoutLogout(userip net.IP, secret string, serial uint16, reqid uint16) portal.Message {
+	msg := newMessage(portal.REQ_LOGOUT, userip, secret, serial, reqid)
+	msg.Header.ErrCode = 1
+	return msg
+}
+
 func (v *Version) NewAffAckAuth(userip net.IP, secret string, serial uint16, reqid uint16) portal.Message {
 	return newMessage(portal.AFF_ACK_AUTH, userip, secret, serial, reqid)
 }
@@ -26,7 +32,7 @@ func (v *Version) NewAffAckAuth(userip net.IP, secret string, serial uint16, req
 func (v *Version) NewReqInfo(userip net.IP, secret string) portal.Message {
 	msg := newMessage(portal.REQ_INFO, userip, secret, portal.NewSerialNo(), 0)
 	msg.Header.AttrNum = 2
-	msg.Attrs = []T_Attr{{AttrType: byte(6), AttrLen: 0}, {AttrType: byte(7), AttrLen: 0}}
+	msg.Attrs = []T_Attr{{AttrType: portal.ATTR_UPLINKFLUX, AttrLen: 0}, {AttrType: portal.ATTR_DOWNLINKFLUX, AttrLen: 0}}
 	return msg
 }
 
@@ -34,9 +40,23 @@ func (v *Version) NewAckNtfLogout(userip net.IP, secret string, serial uint16, r
 	return newMessage(portal.ACK_NTF_LOGOUT, userip, secret, serial, reqid)
 }
 
+func (v *Version) NewAckUserHeartbeat(userip net.IP, secret string, serial uint16, reqid uint16) portal.Message {
+	return newMessage(portal.ACK_NTF_USER_HEARTBEAT, userip, secret, serial, reqid)
+}
+
+func (v *Version) NewAckMacInfo(userip net.IP, secret string, serial uint16, reqid uint16, errCode byte) portal.Message {
+	msg := newMessage(portal.ACK_MACINFO, userip, secret, serial, reqid)
+	msg.Header.ErrCode = errCode
+	return msg
+}
+
+func (v *Version) NewAffAckUserIpChange(userip net.IP, secret string, serial uint16, reqid uint16) portal.Message {
+	return newMessage(portal.AFF_NTF_USERIPCHANGE, userip, secret, serial, reqid)
+}
+
 func (v *Version) IsResponse(mesg portal.Message) bool {
 	switch mesg.Type() {
-	case 2, 4, 6, 10:
+	case portal.ACK_CHALLENGE, portal.ACK_AUTH, portal.ACK_LOGOUT, portal.ACK_INFO:
 		return true
 	}
 	return false
@@ -67,7 +87,8 @@ func (v *Version) Unmarshall(bts []byte) portal.Message {
 		attr := &msg.Attrs[i]
 		binary.Read(buf, binary.BigEndian, &attr.AttrType)
 		binary.Read(buf, binary.BigEndian, &attr.AttrLen)
-		attr.AttrStr = make([]byte, attr.AttrLen-2)
+		attr.AttrLen = attr.AttrLen - 2
+		attr.AttrStr = make([]byte, attr.AttrLen)
 		binary.Read(buf, binary.BigEndian, &attr.AttrStr)
 	}
 	return msg
@@ -83,8 +104,19 @@ func newMessage(typ byte, userip net.IP, secret string, serialNo uint16, reqId u
 	return msg
 }
 
+func (v *Version) NewPapAuth(userip net.IP, secret string, username []byte, userpwd []byte) portal.Message {
+	msg := newMessage(portal.REQ_AUTH, userip, secret, portal.NewSerialNo(), 0)
+	msg.Header.Pap = 1
+	msg.Header.AttrNum = 2
+	msg.Attrs = []T_Attr{
+		{AttrType: portal.ATTR_USERNAME, AttrLen: byte(len(username)), AttrStr: username},
+		{AttrType: portal.ATTR_PASSWORD, AttrLen: byte(len(userpwd)), AttrStr: userpwd},
+	}
+	return msg
+}
+
 func (v *Version) NewAuth(userip net.IP, secret string, username []byte, userpwd []byte, req uint16, cha []byte) portal.Message {
-	msg := newMessage(3, userip, secret, portal.NewSerialNo(), req)
+	msg := newMessage(portal.REQ_AUTH, userip, secret, portal.NewSerialNo(), req)
 	msg.Header.AttrNum = 3
 	hash := md5.New()
 	hash.Write([]byte{byte(req)})
@@ -92,9 +124,9 @@ func (v *Version) NewAuth(userip net.IP, secret string, username []byte, userpwd
 	hash.Write(cha)
 	cpwd := hash.Sum(nil)
 	msg.Attrs = []T_Attr{
-		{AttrType: byte(1), AttrLen: byte(len(username)), AttrStr: username},
-		{AttrType: byte(3), AttrLen: byte(len(cha)), AttrStr: cha},
-		{AttrType: byte(4), AttrLen: byte(len(cpwd)), AttrStr: cpwd},
+		{AttrType: portal.ATTR_USERNAME, AttrLen: byte(len(username)), AttrStr: username},
+		{AttrType: portal.ATTR_CHALLENGE, AttrLen: byte(len(cha)), AttrStr: cha},
+		{AttrType: portal.ATTR_CHAPPASSWORD, AttrLen: byte(len(cpwd)), AttrStr: cpwd},
 	}
 	return msg
 }
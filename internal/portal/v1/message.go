@@ -3,7 +3,6 @@ package v1
 import (
 	"bytes"
 	"encoding/binary"
-	"fmt"
 	"net"
 
 	"syler/internal/portal"
@@ -26,6 +25,18 @@ func (t *T_Message) UserIp() net.IP {
 	return t.Header.UserIp
 }
 
+func (t *T_Message) ErrCode() byte {
+	return t.Header.ErrCode
+}
+
+func (t *T_Message) TextInfo() string {
+	return portal.TextInfo(t)
+}
+
+func (t *T_Message) UserMAC() net.HardwareAddr {
+	return portal.UserMAC(t)
+}
+
 func (t *T_Message) AttributeLen() int {
 	return len(t.Attrs)
 }
@@ -34,6 +45,19 @@ func (t *T_Message) Attribute(n int) portal.Attribute {
 	return t.Attrs[n]
 }
 
+func (t *T_Message) AddAttribute(typ byte, val []byte) {
+	t.Attrs = append(t.Attrs, T_Attr{AttrType: typ, AttrLen: byte(len(val)), AttrStr: val})
+	t.Header.AttrNum = byte(len(t.Attrs))
+}
+
+// Sign Portal 1.0 报文没有Authenticator
+func (t *T_Message) Sign(secret string) {}
+
+// Verify Portal 1.0报文没有Authenticator
+func (t *T_Message) Verify(secret string) bool {
+	return true
+}
+
 func (msg *T_Message) Bytes() []byte {
 	buf := new(bytes.Buffer)
 	binary.Write(buf, binary.BigEndian, msg.Header.Version)
@@ -61,7 +85,7 @@ func (t *T_Message) Type() byte {
 func (t *T_Message) GetChallenge() []byte {
 	for i := byte(0); i < t.Header.AttrNum; i++ {
 		attr := t.Attrs[i]
-		if attr.AttrType == 0x03 {
+		if attr.AttrType == portal.ATTR_CHALLENGE {
 			return attr.AttrStr
 		}
 	}
@@ -69,36 +93,10 @@ func (t *T_Message) GetChallenge() []byte {
 }
 
 func (t *T_Message) CheckFor(msg portal.Message, secret string) error {
-	typ := t.Type()
 	if t.Header.ErrCode == 0 {
 		return nil
 	}
-	des := "未知错误"
-	switch typ {
-	case portal.ACK_CHALLENGE:
-		switch t.Header.ErrCode {
-		case 1:
-			des = "请求Challenge被拒绝"
-		case 2:
-			des = "此链接已建立"
-		case 3:
-			des = "有一个用户正在认证过程中，请稍后再试"
-		case 4:
-			des = "此用户请求Challenge失败（发生错误）"
-		}
-	case portal.ACK_AUTH:
-		switch t.Header.ErrCode {
-		case 1:
-			des = "认证请求被拒绝"
-		case 2:
-			des = "此链接已建立"
-		case 3:
-			des = "有一个用户正在认证过程中，请稍后再试"
-		case 4:
-			des = "此用户请求认证失败（发生错误）"
-		}
-	}
-	return fmt.Errorf("no. %d:%s", t.Header.ErrCode, des)
+	return portal.NewError(t.Type(), t.Header.ErrCode)
 }
 
 type T_Header struct {
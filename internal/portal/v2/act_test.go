@@ -3,8 +3,12 @@ package v2
 import (
 	"bytes"
 	"crypto/md5"
+	"errors"
 	"fmt"
+	"net"
 	"testing"
+
+	"syler/internal/portal"
 )
 
 func TestRawAuth(t *testing.T) {
@@ -19,17 +23,34 @@ func TestRawAuth(t *testing.T) {
 }
 
 func TestUnmarshal(t *testing.T) {
+	// 现网抓取的REQ_AUTH，共享密钥未保留，无法校验Authenticator，只校验解析结果
 	bts := []byte{0x02, 0x03, 0x00, 0x00, 0x9e, 0xd8, 0x0a, 0xb7, 0x7c, 0x7f, 0x4e, 0xd8, 0x00, 0x00, 0x00, 0x02, 0x23, 0x5e, 0xed, 0x66, 0x3f, 0x9b, 0x18, 0x6e, 0xbc, 0x64, 0xde, 0x52, 0xfd, 0x54, 0x20, 0x81, 0x01, 0x15, 0x40, 0x77, 0x6c, 0x61, 0x6e, 0x2d, 0x78, 0x69, 0x6e, 0x6a, 0x69, 0x65, 0x6b, 0x6f, 0x75, 0x2d, 0x6e, 0x65, 0x77, 0x04, 0x12, 0x95, 0xac, 0xed, 0xcb, 0xb9, 0xa1, 0x25, 0x51, 0xc9, 0xed, 0x8d, 0x4c, 0xbc, 0x45, 0x3a, 0xf4}
 	v := new(Version)
 	m := v.Unmarshall(bts)
-	fmt.Println(m)
-	mq := m.(*T_Message)
-	wanted := mq.Header.Authenticator
-	mq.Header.Authenticator = []byte{0xde, 0x75, 0x9a, 0x17, 0x8d, 0xf4, 0xbe, 0x44, 0x36, 0x8e, 0xfc, 0xdb, 0x5a, 0xd0, 0x1a, 0xc7}
-	fmt.Println(m)
-	mq.AuthBy("mink2501")
-	if bytes.Compare(mq.Header.Authenticator, wanted) != 0 {
-		t.Error(fmt.Errorf("MD5鉴权错误"))
+	if m.Type() != portal.REQ_AUTH || m.SerialId() != 0x9ed8 || m.ReqId() != 0x0ab7 || !m.UserIp().Equal(net.IPv4(124, 127, 78, 216)) {
+		t.Errorf("unexpected header: %v", m)
+	}
+	if m.AttributeLen() != 2 || string(m.Attribute(0).Byte()) != "@wlan-xinjiekou-new" || len(m.Attribute(1).Byte()) != 16 {
+		t.Errorf("unexpected attributes: %v", m)
+	}
+	if !bytes.Equal(m.Bytes(), bts) {
+		t.Errorf("re-encoded message differs: %x", m.Bytes())
+	}
+
+	// 应答的Authenticator以请求的Authenticator计算，按模拟NAS的方式构造应答后校验
+	secret := "mink2501"
+	req := v.NewChallenge(net.IPv4(124, 127, 78, 216), secret).(*T_Message)
+	res := &T_Message{Header: req.Header}
+	res.Header.Type = portal.ACK_CHALLENGE
+	res.Header.ErrCode = 4
+	res.AuthBy(secret)
+
+	var perr *portal.Error
+	if err := v.Unmarshall(res.Bytes()).(*T_Message).CheckFor(req, secret); !errors.As(err, &perr) {
+		t.Errorf("expected NAS error code, got %v", err)
+	}
+	if err := v.Unmarshall(res.Bytes()).(*T_Message).CheckFor(req, "wrong"); errors.As(err, &perr) || err == nil {
+		t.Errorf("authenticator with wrong secret accepted: %v", err)
 	}
 }
 
@@ -24,6 +24,13 @@ func (v *Version) NewLogout(userip net.IP, secret string) portal.Message {
 	return msg
 }
 
+func (v *Version) NewTimeoutLogout(userip net.IP, secret string, serial uint16, reqid uint16) portal.Message {
+	msg := newMessage(portal.REQ_LOGOUT, userip, serial, reqid)
+	msg.Header.ErrCode = 1
+	msg.AuthBy(secret)
+	return msg
+}
+
 func (v *Version) NewAffAckAuth(userip net.IP, secret string, serial uint16, reqid uint16) portal.Message {
 	msg := newMessage(portal.AFF_ACK_AUTH, userip, serial, reqid)
 	msg.AuthBy(secret)
@@ -31,7 +38,7 @@ func (v *Version) NewAffAckAuth(userip net.IP, secret string, serial uint16, req
 }
 
 func (v *Version) NewAuth(userip net.IP, secret string, username []byte, userpwd []byte, req uint16, cha []byte) portal.Message {
-	msg := newMessage(3, userip, portal.NewSerialNo(), req)
+	msg := newMessage(portal.REQ_AUTH, userip, portal.NewSerialNo(), req)
 	msg.Header.AttrNum = 3
 	hash := md5.New()
 	hash.Write([]byte{byte(req)})
@@ -39,9 +46,21 @@ func (v *Version) NewAuth(userip net.IP, secret string, username []byte, userpwd
 	hash.Write(cha)
 	cpwd := hash.Sum(nil)
 	msg.Attrs = []T_Attr{
-		{AttrType: byte(1), AttrLen: byte(len(username)), AttrStr: username},
-		{AttrType: byte(3), AttrLen: byte(len(cha)), AttrStr: cha},
-		{AttrType: byte(4), AttrLen: byte(len(cpwd)), AttrStr: cpwd},
+		{AttrType: portal.ATTR_USERNAME, AttrLen: byte(len(username)), AttrStr: username},
+		{AttrType: portal.ATTR_CHALLENGE, AttrLen: byte(len(cha)), AttrStr: cha},
+		{AttrType: portal.ATTR_CHAPPASSWORD, AttrLen: byte(len(cpwd)), AttrStr: cpwd},
+	}
+	msg.AuthBy(secret)
+	return msg
+}
+
+func (v *Version) NewPapAuth(userip net.IP, secret string, username []byte, userpwd []byte) portal.Message {
+	msg := newMessage(portal.REQ_AUTH, userip, portal.NewSerialNo(), 0)
+	msg.Header.Pap = 1
+	msg.Header.AttrNum = 2
+	msg.Attrs = []T_Attr{
+		{AttrType: portal.ATTR_USERNAME, AttrLen: byte(len(username)), AttrStr: username},
+		{AttrType: portal.ATTR_PASSWORD, AttrLen: byte(len(userpwd)), AttrStr: userpwd},
 	}
 	msg.AuthBy(secret)
 	return msg
@@ -50,7 +69,7 @@ func (v *Version) NewAuth(userip net.IP, secret string, username []byte, userpwd
 func (v *Version) NewReqInfo(userip net.IP, secret string) portal.Message {
 	msg := newMessage(portal.REQ_INFO, userip, portal.NewSerialNo(), 0)
 	msg.Header.AttrNum = 2
-	msg.Attrs = []T_Attr{{AttrType: byte(6), AttrLen: 0}, {AttrType: byte(7), AttrLen: 0}}
+	msg.Attrs = []T_Attr{{AttrType: portal.ATTR_UPLINKFLUX, AttrLen: 0}, {AttrType: portal.ATTR_DOWNLINKFLUX, AttrLen: 0}}
 	msg.AuthBy(secret)
 	return msg
 }
@@ -61,9 +80,28 @@ func (v *Version) NewAckNtfLogout(userip net.IP, secret string, serial uint16, r
 	return msg
 }
 
+func (v *Version) NewAckUserHeartbeat(userip net.IP, secret string, serial uint16, reqid uint16) portal.Message {
+	msg := newMessage(portal.ACK_NTF_USER_HEARTBEAT, userip, serial, reqid)
+	msg.AuthBy(secret)
+	return msg
+}
+
+func (v *Version) NewAckMacInfo(userip net.IP, secret string, serial uint16, reqid uint16, errCode byte) portal.Message {
+	msg := newMessage(portal.ACK_MACINFO, userip, serial, reqid)
+	msg.Header.ErrCode = errCode
+	msg.AuthBy(secret)
+	return msg
+}
+
+func (v *Version) NewAffAckUserIpChange(userip net.IP, secret string, serial uint16, reqid uint16) portal.Message {
+	msg := newMessage(portal.AFF_NTF_USERIPCHANGE, userip, serial, reqid)
+	msg.AuthBy(secret)
+	return msg
+}
+
 func (v *Version) IsResponse(mesg portal.Message) bool {
 	switch mesg.Type() {
-	case 2, 4, 6, 10:
+	case portal.ACK_CHALLENGE, portal.ACK_AUTH, portal.ACK_LOGOUT, portal.ACK_INFO:
 		return true
 	}
 	return false
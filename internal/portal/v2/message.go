@@ -27,6 +27,18 @@ func (t *T_Message) UserIp() net.IP {
 	return t.Header.UserIp
 }
 
+func (t *T_Message) ErrCode() byte {
+	return t.Header.ErrCode
+}
+
+func (t *T_Message) TextInfo() string {
+	return portal.TextInfo(t)
+}
+
+func (t *T_Message) UserMAC() net.HardwareAddr {
+	return portal.UserMAC(t)
+}
+
 func (t *T_Message) Type() byte {
 	return t.Header.Type
 }
@@ -47,10 +59,28 @@ func (t *T_Message) AuthBy(secret string) {
 	t.Header.Authenticator = hashMd5.Sum(nil)
 }
 
+func (t *T_Message) AddAttribute(typ byte, val []byte) {
+	t.Attrs = append(t.Attrs, T_Attr{AttrType: typ, AttrLen: byte(len(val)), AttrStr: val})
+	t.Header.AttrNum = byte(len(t.Attrs))
+}
+
+// Sign 以全零Authenticator重新计算请求报文的Authenticator
+func (t *T_Message) Sign(secret string) {
+	t.Header.Authenticator = make([]byte, 16)
+	t.AuthBy(secret)
+}
+
+// Verify NAS主动发送的报文以全零Authenticator计算，与Sign相同
+func (t *T_Message) Verify(secret string) bool {
+	m := *t
+	m.Sign(secret)
+	return bytes.Equal(m.Header.Authenticator, t.Header.Authenticator)
+}
+
 func (t *T_Message) GetChallenge() []byte {
 	for i := byte(0); i < t.Header.AttrNum; i++ {
 		attr := t.Attrs[i]
-		if attr.AttrType == 0x03 {
+		if attr.AttrType == portal.ATTR_CHALLENGE {
 			return attr.AttrStr
 		}
 	}
@@ -140,41 +170,18 @@ func (t *T_Message) CheckFor(req portal.Message, secret string) error {
 		return nil
 	}
 	reqMsg := req.(*T_Message)
-	typ := t.Type()
-	des := "未知错误"
 	wanted := t.Header.Authenticator
 	t.Header.Authenticator = reqMsg.Header.Authenticator
 	t.AuthBy(secret)
 	if bytes.Compare(t.Header.Authenticator, wanted) != 0 {
 		return fmt.Errorf("MD5鉴权错误")
 	}
-	switch typ {
-	case portal.ACK_CHALLENGE:
-		switch t.Header.ErrCode {
-		case 1:
-			des = "请求Challenge被拒绝"
-		case 2:
-			fmt.Println("此链接已建立")
-			return nil
-		case 3:
-			des = "有一个用户正在认证过程中，请稍后再试"
-		case 4:
-			des = "此用户请求Challenge失败（发生错误）"
-		}
-	case portal.ACK_AUTH:
-		switch t.Header.ErrCode {
-		case 1:
-			des = "认证请求被拒绝"
-		case 2:
-			fmt.Println("此链接已建立")
-			return nil
-		case 3:
-			des = "有一个用户正在认证过程中，请稍后再试"
-		case 4:
-			des = "此用户请求认证失败（发生错误）"
-		}
+	typ := t.Type()
+	if (typ == portal.ACK_CHALLENGE || typ == portal.ACK_AUTH) && t.Header.ErrCode == 2 {
+		// 此链接已建立
+		return nil
 	}
-	return fmt.Errorf("No. %d:%s", t.Header.ErrCode, des)
+	return portal.NewError(typ, t.Header.ErrCode)
 }
 
 type T_Header struct {
@@ -0,0 +1,35 @@
+package v2_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"syler/internal/portal"
+	v2 "syler/internal/portal/v2"
+	"syler/internal/simulator"
+)
+
+func TestRunChallenge(t *testing.T) {
+	nas := simulator.New(simulator.Config{Addr: "127.0.0.1:0", Version: 2, Secret: "it is a secret"})
+	if err := nas.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer nas.Close()
+
+	portal.SetVersion(new(v2.Version))
+	portal.RegisterFallBack(func(portal.Message, net.IP) {})
+	go portal.ListenAndService("127.0.0.1:0")
+	for !portal.Bound() {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	res, err := portal.Challenge(context.Background(), net.IPv4(192, 168, 1, 1), "it is a secret", nas.Addr().IP, nas.Addr().Port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cha := res.(portal.ChallengeRes).GetChallenge(); len(cha) != 16 {
+		t.Errorf("unexpected challenge: %x", cha)
+	}
+}
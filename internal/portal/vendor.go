@@ -0,0 +1,240 @@
+package portal
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// H3C扩展报文类型和属性
+const (
+	NTF_HEARTBEAT          = 0x0f // NAS心跳
+	NTF_USER_HEARTBEAT     = 0x10 // NAS通告在线用户
+	ACK_NTF_USER_HEARTBEAT = 0x11
+	ATTR_USERLIST          = 0x0d // 在线用户IP列表，每4字节一个IPv4地址
+)
+
+// Vendor 厂商Portal方言，在Version编解码之上描述各厂商的错误码、扩展报文类型和属性
+type Vendor struct {
+	Name string
+	// Errors 应答类型 -> 错误码 -> 定义，未列出的沿用华为定义
+	Errors map[byte]map[byte]ErrorDef
+	// Types 扩展报文类型名称
+	Types map[byte]string
+	// Attrs 扩展属性及对标准属性的重新定义
+	Attrs map[byte]AttrDef
+	// BasIP 请求报文中携带BAS-IP属性，NAS有多个地址时据此识别自身
+	BasIP bool
+}
+
+var Huawei = &Vendor{
+	Name: "huawei",
+	Errors: map[byte]map[byte]ErrorDef{
+		ACK_CHALLENGE: {
+			1: {"请求Challenge被拒绝", false},
+			2: {"此链接已建立", false},
+			3: {"有一个用户正在认证过程中，请稍后再试", true},
+			4: {"此用户请求Challenge失败（发生错误）", true},
+		},
+		ACK_AUTH: {
+			1: {"认证请求被拒绝", false},
+			2: {"此链接已建立", false},
+			3: {"有一个用户正在认证过程中，请稍后再试", true},
+			4: {"此用户请求认证失败（发生错误）", true},
+		},
+		ACK_LOGOUT: {
+			1: {"下线请求被拒绝", false},
+			2: {"下线请求出现错误", true},
+		},
+		ACK_INFO: {
+			1: {"不支持信息查询功能或者处理失败", false},
+			2: {"消息处理失败", true},
+		},
+	},
+}
+
+var H3C = &Vendor{
+	Name: "h3c",
+	Errors: map[byte]map[byte]ErrorDef{
+		ACK_AUTH: {
+			5: {"用户认证超时", true},
+		},
+		ACK_LOGOUT: {
+			3: {"用户不在线", false},
+		},
+	},
+	Types: map[byte]string{
+		NTF_HEARTBEAT:          "NTF_HEARTBEAT",
+		NTF_USER_HEARTBEAT:     "NTF_USER_HEARTBEAT",
+		ACK_NTF_USER_HEARTBEAT: "ACK_NTF_USER_HEARTBEAT",
+	},
+	Attrs: map[byte]AttrDef{
+		ATTR_USERLIST: {"User-List", KindIPList, 0, MaxAttrLen - MaxAttrLen%4, false},
+	},
+	BasIP: true,
+}
+
+var Ruijie = &Vendor{
+	Name: "ruijie",
+	Errors: map[byte]map[byte]ErrorDef{
+		ACK_CHALLENGE: {
+			5: {"用户MAC地址未知", false},
+		},
+		ACK_AUTH: {
+			5: {"用户已在其他设备上线", false},
+			6: {"用户账号已欠费", false},
+		},
+	},
+	Attrs: map[byte]AttrDef{
+		ATTR_SESSIONID: {"User-MAC", KindMAC, 6, 6, false},
+		0xf0:           {"Ruijie-SSID", KindString, 1, 32, false},
+	},
+	BasIP: true,
+}
+
+var ZTE = &Vendor{
+	Name: "zte",
+	Errors: map[byte]map[byte]ErrorDef{
+		ACK_AUTH: {
+			5: {"在线用户数已达上限", true},
+			6: {"RADIUS服务器无响应", true},
+		},
+	},
+	Attrs: map[byte]AttrDef{
+		0xe0: {"ZTE-VlanID", KindUint32, 4, 4, false},
+	},
+}
+
+var Vendors = map[string]*Vendor{
+	Huawei.Name: Huawei,
+	H3C.Name:    H3C,
+	Ruijie.Name: Ruijie,
+	ZTE.Name:    ZTE,
+}
+
+// VendorByName 按名称查找厂商方言，名称为空时返回华为
+func VendorByName(name string) (*Vendor, error) {
+	if name == "" {
+		return Huawei, nil
+	}
+	v, ok := Vendors[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown portal vendor: %s", name)
+	}
+	return v, nil
+}
+
+// NewError 根据应答类型和错误码生成错误
+func (v *Vendor) NewError(typ byte, code byte) *Error {
+	e := &Error{Type: typ, Code: code, Desc: "未知错误"}
+	def, ok := v.Errors[typ][code]
+	if !ok && v != Huawei {
+		def, ok = Huawei.Errors[typ][code]
+	}
+	if ok {
+		e.Desc = def.Desc
+		e.Retryable = def.Retryable
+	}
+	return e
+}
+
+// TypeName 返回报文类型名称，包括厂商扩展类型
+func (v *Vendor) TypeName(typ byte) string {
+	if name, ok := v.Types[typ]; ok {
+		return name
+	}
+	return TypeName(typ)
+}
+
+// LookupAttr 返回属性定义，厂商定义优先于标准定义
+func (v *Vendor) LookupAttr(typ byte) (AttrDef, bool) {
+	if d, ok := v.Attrs[typ]; ok {
+		return d, true
+	}
+	return LookupAttr(typ)
+}
+
+// AttrName 返回属性名称，包括厂商扩展属性
+func (v *Vendor) AttrName(typ byte) string {
+	if d, ok := v.LookupAttr(typ); ok {
+		return d.Name
+	}
+	return AttrName(typ)
+}
+
+// Validate 检查报文中已知属性的长度，未知属性不检查
+func (v *Vendor) Validate(m Message) error {
+	for i := 0; i < m.AttributeLen(); i++ {
+		a := m.Attribute(i)
+		d, ok := v.LookupAttr(a.Type())
+		if !ok {
+			continue
+		}
+		if err := d.Validate(a.Byte()); err != nil {
+			return fmt.Errorf("invalid attribute %w", err)
+		}
+	}
+	return nil
+}
+
+// Check 检查NAS能否使用该方言通信。BAS-IP属性只有4字节，需要携带时BAS-IP必须是IPv4地址
+func (v *Vendor) Check(nas Nas) error {
+	if v.BasIP && basIP(nas).To4() == nil {
+		return fmt.Errorf("%s requires an IPv4 BAS-IP, got %s; set bas_ip", v.Name, basIP(nas))
+	}
+	return nil
+}
+
+// prepare 按方言补充请求报文的属性并重新签名
+func (v *Vendor) prepare(m Message, nas Nas, secret string) error {
+	if !v.BasIP {
+		return nil
+	}
+	for i := 0; i < m.AttributeLen(); i++ {
+		if m.Attribute(i).Type() == ATTR_BASIP {
+			return nil
+		}
+	}
+	if err := v.Check(nas); err != nil {
+		return err
+	}
+	m.AddAttribute(ATTR_BASIP, basIP(nas).To4())
+	m.Sign(secret)
+	return nil
+}
+
+// isRequest 报文是否由Portal Server发往NAS
+func isRequest(typ byte) bool {
+	switch typ {
+	case REQ_CHALLENGE, REQ_AUTH, REQ_LOGOUT, AFF_ACK_AUTH, REQ_INFO, ACK_NTF_LOGOUT, ACK_NTF_USER_HEARTBEAT,
+		ACK_MACINFO, AFF_NTF_USERIPCHANGE:
+		return true
+	}
+	return false
+}
+
+// basIP 未配置时使用NAS的地址作为BAS-IP
+func basIP(nas Nas) net.IP {
+	if nas.BasIP != nil {
+		return nas.BasIP
+	}
+	return nas.IP
+}
+
+// HeartbeatUsers 返回NTF_USER_HEARTBEAT通告的在线用户，包括报文头中的用户IP和User-List属性
+func HeartbeatUsers(m Message) []net.IP {
+	var ips []net.IP
+	if ip := m.UserIp(); ip != nil && !ip.IsUnspecified() {
+		ips = append(ips, ip)
+	}
+	for i := 0; i < m.AttributeLen(); i++ {
+		a := m.Attribute(i)
+		if a.Type() != ATTR_USERLIST {
+			continue
+		}
+		if list, ok := H3C.Attrs[ATTR_USERLIST].Decode(a.Byte()).([]net.IP); ok {
+			ips = append(ips, list...)
+		}
+	}
+	return ips
+}
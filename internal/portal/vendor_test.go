@@ -0,0 +1,119 @@
+package portal_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"syler/internal/portal"
+	v1 "syler/internal/portal/v1"
+	v2 "syler/internal/portal/v2"
+	"syler/internal/simulator"
+)
+
+func TestVendorCodec(t *testing.T) {
+	portal.RegisterFallBack(func(portal.Message, net.IP) {})
+	go portal.ListenAndService("127.0.0.1:0")
+	for !portal.Bound() {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	versions := map[int]portal.Version{1: new(v1.Version), 2: new(v2.Version)}
+	for _, vendor := range portal.Vendors {
+		for ver, codec := range versions {
+			nas := simulator.New(simulator.Config{Addr: "127.0.0.1:0", Version: ver, Secret: "secret"})
+			if err := nas.Start(); err != nil {
+				t.Fatal(err)
+			}
+			ip, port := nas.Addr().IP, nas.Addr().Port
+			portal.RegisterNas(portal.Nas{
+				IP:            ip,
+				Port:          port,
+				Secret:        "secret",
+				RetryInterval: 200 * time.Millisecond,
+				Version:       codec,
+				Vendor:        vendor,
+				BasIP:         net.IPv4(192, 168, 10, 1),
+			})
+
+			// 请求按方言携带BAS-IP属性且签名正确
+			if _, err := portal.Challenge(context.Background(), net.IPv4(10, 0, 0, 1), "secret", ip, port); err != nil {
+				t.Fatalf("%s v%d: %v", vendor.Name, ver, err)
+			}
+			req := nas.Received()[0]
+			hasBasIP := false
+			for i := 0; i < req.AttributeLen(); i++ {
+				a := req.Attribute(i)
+				if a.Type() == portal.ATTR_BASIP && net.IP(a.Byte()).Equal(net.IPv4(192, 168, 10, 1)) {
+					hasBasIP = true
+				}
+			}
+			if hasBasIP != vendor.BasIP {
+				t.Errorf("%s v%d: BAS-IP attribute present: %v", vendor.Name, ver, hasBasIP)
+			}
+			if nas.BadAuthenticators() != 0 {
+				t.Errorf("%s v%d: request authenticator rejected", vendor.Name, ver)
+			}
+
+			// 错误码按方言解释
+			nas.Inject(portal.REQ_CHALLENGE, &simulator.Fault{ErrCode: 5})
+			_, err := portal.Challenge(context.Background(), net.IPv4(10, 0, 0, 2), "secret", ip, port)
+			var perr *portal.Error
+			if !errors.As(err, &perr) {
+				t.Fatalf("%s v%d: want portal error, got %v", vendor.Name, ver, err)
+			}
+			if want := vendor.NewError(portal.ACK_CHALLENGE, 5).Desc; perr.Desc != want {
+				t.Errorf("%s v%d: got %q, want %q", vendor.Name, ver, perr.Desc, want)
+			}
+			nas.Close()
+		}
+	}
+}
+
+// BAS-IP属性只能携带IPv4地址，IPv6的NAS需要配置IPv4的bas_ip
+func TestVendorBasIPv6(t *testing.T) {
+	nasip := net.ParseIP("2001:db8::1")
+	for _, vendor := range portal.Vendors {
+		nas := portal.Nas{IP: nasip, Port: 2000, Secret: "secret", Vendor: vendor, RetryInterval: 100 * time.Millisecond}
+		if err := vendor.Check(nas); (err != nil) != vendor.BasIP {
+			t.Errorf("%s: check IPv6 NAS: %v", vendor.Name, err)
+		}
+		nas.BasIP = net.IPv4(192, 168, 10, 1)
+		if err := vendor.Check(nas); err != nil {
+			t.Errorf("%s: check with IPv4 BAS-IP: %v", vendor.Name, err)
+		}
+	}
+
+	portal.RegisterNas(portal.Nas{IP: nasip, Port: 2000, Secret: "secret", Vendor: portal.Huawei, Version: new(v2.Version)})
+	_, err := portal.Challenge(context.Background(), net.IPv4(10, 0, 0, 1), "secret", nasip, 2000)
+	if err == nil || errors.Is(err, portal.ErrTimeout) {
+		t.Errorf("challenge to IPv6 NAS without BAS-IP: %v", err)
+	}
+}
+
+func TestVendorErrors(t *testing.T) {
+	cases := []struct {
+		vendor    *portal.Vendor
+		typ, code byte
+		desc      string
+		retryable bool
+	}{
+		{portal.Huawei, portal.ACK_AUTH, 1, "认证请求被拒绝", false},
+		{portal.H3C, portal.ACK_AUTH, 1, "认证请求被拒绝", false},
+		{portal.H3C, portal.ACK_LOGOUT, 3, "用户不在线", false},
+		{portal.Ruijie, portal.ACK_AUTH, 6, "用户账号已欠费", false},
+		{portal.ZTE, portal.ACK_AUTH, 5, "在线用户数已达上限", true},
+		{portal.Huawei, portal.ACK_AUTH, 6, "未知错误", false},
+	}
+	for _, c := range cases {
+		e := c.vendor.NewError(c.typ, c.code)
+		if e.Desc != c.desc || e.Retryable != c.retryable {
+			t.Errorf("%s type %d code %d: got %q/%v", c.vendor.Name, c.typ, c.code, e.Desc, e.Retryable)
+		}
+	}
+	if _, err := portal.VendorByName("cisco"); err == nil {
+		t.Error("unknown vendor accepted")
+	}
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	"syler/internal/audit"
+	"syler/internal/logger"
+)
+
+// adminAuth 管理接口要求 Authorization: Bearer <admin.token>，未配置admin.token时不开放
+func adminAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := viper.GetString("admin.token")
+		if token == "" {
+			handleResponse(w, http.StatusNotFound, Response{
+				Message: "管理接口未启用",
+			})
+			return
+		}
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			logger.WithRequest(r).WithField("source_ip", r.RemoteAddr).Warn("Rejected admin request with invalid token")
+			handleResponse(w, http.StatusUnauthorized, Response{
+				Message: "未授权",
+			})
+			return
+		}
+		next(w, r)
+	}
+}
+
+// operator 管理操作的操作人，取X-Operator请求头，未提供时为来源地址
+func operator(r *http.Request) string {
+	if op := strings.TrimSpace(r.Header.Get("X-Operator")); op != "" {
+		return op
+	}
+	return r.RemoteAddr
+}
+
+// HandleLists 黑白名单管理：GET列出条目，POST添加（JSON，ttl为有效时长如"24h"），
+// DELETE删除（参数list、kind、pattern）
+func (a *Authenticator) HandleLists(w http.ResponseWriter, r *http.Request) {
+	log := logger.WithRequest(r).WithField("operator", operator(r))
+
+	switch r.Method {
+	case http.MethodGet:
+		entries, err := Lists.All(r.Context())
+		if err != nil {
+			log.WithField("error", err).Error("Failed to load access lists")
+			handleResponse(w, http.StatusServiceUnavailable, Response{
+				Message: "状态存储不可用，请稍后重试",
+			})
+			return
+		}
+		handleResponse(w, http.StatusOK, Response{
+			Message: "ok",
+			Data:    entries,
+		})
+
+	case http.MethodPost:
+		var req struct {
+			ListEntry
+			TTL string `json:"ttl"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			handleResponse(w, http.StatusBadRequest, Response{
+				Message: "无效的请求参数",
+			})
+			return
+		}
+		e := req.ListEntry
+		e.Operator = operator(r)
+		e.Created = time.Now()
+		if req.TTL != "" {
+			ttl, err := time.ParseDuration(req.TTL)
+			if err != nil || ttl <= 0 {
+				handleResponse(w, http.StatusBadRequest, Response{
+					Message: "无效的有效时长",
+				})
+				return
+			}
+			e.Expire = e.Created.Add(ttl)
+		}
+		e, err := Lists.Add(r.Context(), e)
+		if errors.Is(err, errInvalidEntry) {
+			handleResponse(w, http.StatusBadRequest, Response{
+				Message: err.Error(),
+			})
+			return
+		}
+		auditList(log, r, e, "add", err)
+		if err != nil {
+			handleResponse(w, http.StatusServiceUnavailable, Response{
+				Message: "状态存储不可用，保存名单失败，请稍后重试",
+			})
+			return
+		}
+		if n := enforceDeny(r.Context(), e); n > 0 {
+			log.WithField("kicked", n).Info("Logged out sessions matching new deny entry")
+		}
+		handleResponse(w, http.StatusOK, Response{
+			Message: "已添加",
+			Data:    e,
+		})
+
+	case http.MethodDelete:
+		e := ListEntry{
+			List:     r.FormValue("list"),
+			Kind:     r.FormValue("kind"),
+			Pattern:  r.FormValue("pattern"),
+			Operator: operator(r),
+		}
+		ok, err := Lists.Remove(r.Context(), e.List, e.Kind, e.Pattern)
+		if errors.Is(err, errInvalidEntry) {
+			handleResponse(w, http.StatusBadRequest, Response{
+				Message: err.Error(),
+			})
+			return
+		}
+		if err == nil && !ok {
+			handleResponse(w, http.StatusNotFound, Response{
+				Message: "条目不存在",
+			})
+			return
+		}
+		auditList(log, r, e, "remove", err)
+		if err != nil {
+			handleResponse(w, http.StatusServiceUnavailable, Response{
+				Message: "状态存储不可用，保存名单失败，请稍后重试",
+			})
+			return
+		}
+		handleResponse(w, http.StatusOK, Response{
+			Message: "已删除",
+		})
+
+	default:
+		handleResponse(w, http.StatusMethodNotAllowed, Response{
+			Message: "仅支持GET、POST、DELETE请求",
+		})
+	}
+}
+
+// auditList 记录名单变更，Reason格式为“操作 名单/对象 匹配规则: 原因”
+func auditList(log *logrus.Entry, r *http.Request, e ListEntry, op string, err error) {
+	fields := logrus.Fields{
+		"list":         e.List,
+		"list_kind":    e.Kind,
+		"list_pattern": e.Pattern,
+	}
+	if err != nil {
+		log.WithFields(fields).WithField("error", err).Error("Failed to update access list")
+	} else {
+		log.WithFields(fields).Info("Updated access list")
+	}
+	reason := op + " " + e.List + "/" + e.Kind + " " + e.Pattern
+	if e.Reason != "" {
+		reason += ": " + e.Reason
+	}
+	audit.Log(audit.Record{
+		Event:     audit.ListChange,
+		Username:  e.Username,
+		Operator:  e.Operator,
+		Success:   err == nil,
+		Reason:    reason,
+		RequestID: logger.RequestID(r.Context()),
+	})
+}
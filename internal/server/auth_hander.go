@@ -3,45 +3,41 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math/rand"
 	"net"
 	"net/http"
 	"regexp"
-	"strings"
+	"strconv"
 	"time"
 
-	"github.com/redis/go-redis/v9"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 
+	"syler/internal/audit"
 	"syler/internal/logger"
+	"syler/internal/portal"
 	"syler/internal/sms"
+	"syler/internal/store"
+	"syler/internal/web"
 )
 
 const (
-	SMSCodePrefix     = "user:"         // Redis key prefix for SMS codes
+	SMSCodePrefix     = "user:"         // Store key prefix for SMS codes
 	SMSCodeExpire     = 5 * time.Minute // Code expiration time
-	MacSessionPfrefix = "mac:"          // Redis key prefix for MAC addresses
+	MacSessionPfrefix = "mac:"          // Store key prefix for MAC addresses
 	MacSessionExpire  = 7 * 24 * time.Hour
 )
 
-type AuthInfo struct {
-	Name  []byte
-	Pwd   []byte
-	Mac   net.HardwareAddr
-	IP    net.IP
-	NasIP net.IP
-}
-
 type Authenticator struct {
-	authUserInfo map[string]*AuthInfo
-	smsProvider  sms.SMSProvider
-	redisClient  *redis.Client
-	log          *logrus.Logger
+	smsProvider sms.SMSProvider
+	state       store.Store
+	log         *logrus.Logger
 }
 
 type Response struct {
+	Code    string      `json:"code,omitempty"` // 失败时的错误码，供前端区分处理
 	Message string      `json:"message"`
 	Data    interface{} `json:"data,omitempty"`
 }
@@ -58,42 +54,84 @@ func validatePhone(phone string) bool {
 	return matched
 }
 
+// authMethod 根据用户名判断认证方式，短信验证码登录的用户名为手机号
+func (a *Authenticator) authMethod(username string) string {
+	if a.smsProvider != nil && validatePhone(username) {
+		return "sms"
+	}
+	return "chap"
+}
+
 var AuthHandler = new(Authenticator)
 
+// codeStore 保存短信验证码的存储。外部RADIUS只从Redis读取验证码，
+// Redis不可用时不能降级写入本地内存，否则用户收到的验证码永远无法通过
+func (a *Authenticator) codeStore() store.Store {
+	if f, ok := a.state.(*store.Fallback); ok {
+		return f.Primary()
+	}
+	return a.state
+}
+
+// storeConfig 读取状态存储配置，redis.addr等单机配置保持兼容
+func storeConfig() store.Config {
+	return store.Config{
+		Backend: viper.GetString("store.backend"),
+		File:    viper.GetString("store.file"),
+		Redis: store.RedisConfig{
+			Mode:             viper.GetString("redis.mode"),
+			Addr:             viper.GetString("redis.addr"),
+			Addrs:            viper.GetStringSlice("redis.addrs"),
+			MasterName:       viper.GetString("redis.master_name"),
+			Password:         viper.GetString("redis.password"),
+			SentinelPassword: viper.GetString("redis.sentinel_password"),
+			DB:               viper.GetInt("redis.db"),
+		},
+	}
+}
+
+// CloseAuthenticator 关闭状态存储，本地存储在此写入最后一次快照，退出前调用
+func CloseAuthenticator() {
+	if AuthHandler == nil || AuthHandler.state == nil {
+		return
+	}
+	if err := AuthHandler.state.Close(); err != nil {
+		AuthHandler.log.WithField("error", err).Error("Failed to close state store")
+	}
+}
+
 func InitAuthenticator() {
 	log := logger.GetLogger()
 
 	AuthHandler = &Authenticator{
-		authUserInfo: make(map[string]*AuthInfo),
-		log:          log,
-	}
-
-	rdb := redis.NewClient(&redis.Options{
-		Addr:         viper.GetString("redis.addr"),
-		Password:     viper.GetString("redis.password"),
-		DB:           viper.GetInt("redis.db"),
-		DialTimeout:  5 * time.Second,
-		ReadTimeout:  3 * time.Second,
-		WriteTimeout: 3 * time.Second,
-		PoolSize:     10,
-		MaxRetries:   3,
-	})
+		log: log,
+	}
+
+	state, err := store.New(storeConfig())
+	if err != nil {
+		log.WithField("error", err).Fatal("Failed to initialize state store")
+	}
+	AuthHandler.state = state
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	if err := rdb.Ping(ctx).Err(); err != nil {
-		log.WithFields(logrus.Fields{
-			"error": err,
-			"addr":  viper.GetString("redis.addr"),
-		}).Fatal("Failed to connect to Redis")
+	fields := logrus.Fields{
+		"backend": viper.GetString("store.backend"),
+		"mode":    viper.GetString("redis.mode"),
+	}
+	if err := state.Ping(ctx); err != nil {
+		// Redis暂时不可用时不退出，降级使用本地内存，恢复后自动切回
+		log.WithFields(fields).WithField("error", err).Error("State store unavailable, starting in degraded mode")
 	} else {
-		AuthHandler.redisClient = rdb
-		log.WithFields(logrus.Fields{
-			"addr": viper.GetString("redis.addr"),
-		}).Info("Redis connection initialized successfully")
+		log.WithFields(fields).Info("State store initialized successfully")
 	}
 
+	LoadMessageRules()
+	LoadSessionLimits()
+	LoadAccessPolicies()
+	LoadLoginGuard()
+
 	if viper.GetString("sms.provider") != "" {
 		smsConfig := sms.SMSConfig{
 			Provider:     sms.Provider(viper.GetString("sms.provider")),
@@ -128,13 +166,6 @@ func (a *Authenticator) HandleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !strings.Contains(r.Header.Get("Referer"), "/portal") {
-		handleResponse(w, http.StatusForbidden, Response{
-			Message: "请从Portal页面进行登录",
-		})
-		return
-	}
-
 	userip_str := r.FormValue("userip")
 	userip := net.ParseIP(userip_str)
 	if userip == nil {
@@ -154,6 +185,12 @@ func (a *Authenticator) HandleLogin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	usermac_str := r.FormValue("usermac")
+	if usermac_str == "" {
+		// 页面未带MAC时使用NAS在NTF_USERDISCOVERY中通告的MAC
+		if mac, ok := Discovered.Lookup(userip); ok {
+			usermac_str = mac.String()
+		}
+	}
 	username := []byte(r.FormValue("username"))
 	userpwd := []byte(r.FormValue("userpwd"))
 
@@ -163,6 +200,40 @@ func (a *Authenticator) HandleLogin(w http.ResponseWriter, r *http.Request) {
 	})
 	log.Info("Received login request")
 
+	nas := portal.NasFor(nasip)
+	if err := verifyRedirect(r, nas, userip); err != nil {
+		log.WithFields(logrus.Fields{
+			"error":     err,
+			"source_ip": r.RemoteAddr,
+		}).Warn("Rejected login with unverified redirect parameters")
+		handleResponse(w, http.StatusForbidden, Response{
+			Message: "请从Portal页面进行登录",
+		})
+		return
+	}
+	release, err := a.claimRedirect(r.Context(), r, nas)
+	if errors.Is(err, errRedirectReused) {
+		log.WithField("source_ip", r.RemoteAddr).Warn("Rejected login with a used redirect signature")
+		handleResponse(w, http.StatusForbidden, Response{
+			Message: "请从Portal页面进行登录",
+		})
+		return
+	}
+	if err != nil {
+		log.WithField("error", err).Error("Failed to claim redirect signature")
+		handleResponse(w, http.StatusServiceUnavailable, Response{
+			Message: "系统繁忙，请稍后重试",
+		})
+		return
+	}
+	// 登录未成功时归还签名
+	loggedIn := false
+	defer func() {
+		if !loggedIn {
+			release()
+		}
+	}()
+
 	if len(username) == 0 {
 		log.Warn("Empty username provided")
 		handleResponse(w, http.StatusBadRequest, Response{
@@ -171,36 +242,173 @@ func (a *Authenticator) HandleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	a.authUserInfo[string(username)] = &AuthInfo{
-		Name:  username,
-		Pwd:   userpwd,
-		Mac:   []byte{},
-		IP:    userip,
-		NasIP: nasip,
+	lockRecord := audit.Record{
+		Event:     audit.LoginFailure,
+		Username:  string(username),
+		UserIP:    userip.String(),
+		UserMAC:   usermac_str,
+		NasIP:     nasip.String(),
+		Method:    a.authMethod(string(username)),
+		RequestID: logger.RequestID(r.Context()),
+	}
+	if d, scope := a.loginLocked(r.Context(), string(username), sourceIP(r), nasip); d > 0 {
+		rejectLocked(w, log.WithField("username", string(username)), lockRecord, d, scope)
+		return
 	}
 
-	if err := Auth(userip, nasip, username, userpwd); err != nil {
+	if e, ok := denied(string(username), usermac_str); ok {
+		logDenied(log.WithField("username", string(username)), e)
+		audit.Log(audit.Record{
+			Event:     audit.LoginFailure,
+			Username:  string(username),
+			UserIP:    userip.String(),
+			UserMAC:   usermac_str,
+			NasIP:     nasip.String(),
+			Method:    a.authMethod(string(username)),
+			Reason:    "denied: " + e.Reason,
+			RequestID: logger.RequestID(r.Context()),
+		})
+		handleResponse(w, http.StatusForbidden, Response{
+			Code:    CodeDenied,
+			Message: "该账号或设备已被禁止上网，请联系管理员",
+		})
+		return
+	}
+
+	// 白名单中的账号和设备不受在线设备数和访问策略限制
+	exempt := allowed(string(username), trustedMAC(nas, userip, usermac_str))
+
+	if !exempt {
+		err = checkSessionLimit(string(username), userip, usermac_str)
+	}
+	if err != nil {
 		log.WithFields(logrus.Fields{
 			"username": string(username),
 			"error":    err,
+		}).Warn("Rejected login over session limit")
+		audit.Log(audit.Record{
+			Event:     audit.LoginFailure,
+			Username:  string(username),
+			UserIP:    userip.String(),
+			UserMAC:   usermac_str,
+			NasIP:     nasip.String(),
+			Method:    a.authMethod(string(username)),
+			Reason:    err.Error(),
+			RequestID: logger.RequestID(r.Context()),
+		})
+		handleResponse(w, http.StatusForbidden, Response{
+			Code:    CodeSessionLimit,
+			Message: "该账号在线设备数已达上限，请先在其他设备上退出登录",
+		})
+		return
+	}
+
+	method := a.authMethod(string(username))
+	var deadline time.Time
+	if !exempt {
+		deadline, err = checkAccessPolicy(nasip, string(username), method, time.Now())
+	}
+	if err != nil {
+		code, message := CodeOutsideHours, "当前时段不开放上网"
+		if errors.Is(err, ErrDailyCap) {
+			code, message = CodeDailyCap, "今日上网时长已用完"
+		}
+		log.WithFields(logrus.Fields{
+			"username": string(username),
+			"error":    err,
+		}).Warn("Rejected login by access policy")
+		audit.Log(audit.Record{
+			Event:     audit.LoginFailure,
+			Username:  string(username),
+			UserIP:    userip.String(),
+			UserMAC:   usermac_str,
+			NasIP:     nasip.String(),
+			Method:    method,
+			Reason:    err.Error(),
+			RequestID: logger.RequestID(r.Context()),
+		})
+		handleResponse(w, http.StatusForbidden, Response{
+			Code:    code,
+			Message: message,
+		})
+		return
+	}
+
+	// 认证前占用失败计数，并发的猜测不能同时通过锁定检查
+	attempt, d, scope := a.beginLogin(r.Context(), string(username), sourceIP(r), nasip, method)
+	if attempt == nil {
+		rejectLocked(w, log.WithField("username", string(username)), lockRecord, d, scope)
+		return
+	}
+
+	if err := Auth(r.Context(), userip, nasip, username, userpwd); err != nil {
+		code, message := userMessage(err)
+		log.WithFields(logrus.Fields{
+			"username":   string(username),
+			"error":      err,
+			"error_code": code,
 		}).Error("Authentication failed")
+		audit.Log(audit.Record{
+			Event:     audit.LoginFailure,
+			Username:  string(username),
+			UserIP:    userip.String(),
+			UserMAC:   usermac_str,
+			NasIP:     nasip.String(),
+			Method:    a.authMethod(string(username)),
+			Reason:    err.Error(),
+			RequestID: logger.RequestID(r.Context()),
+		})
+		attempt.failed(r.Context(), countsAsFailure(code))
 		handleResponse(w, http.StatusUnauthorized, Response{
-			Message: "用户名或密码错误",
+			Code:    code,
+			Message: message,
 		})
 		return
 	}
 
+	attempt.succeeded(r.Context())
+	loggedIn = true
+
+	session := &Session{
+		Username: string(username),
+		UserIP:   userip,
+		UserMAC:  usermac_str,
+		NasIP:    nasip,
+		LoginAt:  time.Now(),
+		Method:   method,
+		Deadline: deadline,
+	}
+	session.Browser, _ = web.SessionID(r)
+	// 同一IP上的旧会话先结束并累计时长，同一用户重新登录沿用原来的下线时间，不能重置时长上限
+	if old := endSession(userip); old != nil && old.Username == session.Username {
+		session.Deadline = earlier(session.Deadline, old.Deadline)
+	}
+	Sessions.Add(session)
+	if !exempt {
+		enforceSessionLimit(r.Context(), string(username), userip, usermac_str)
+		shareDailyCap(nasip, string(username), method, session.LoginAt)
+	}
+	audit.Log(audit.Record{
+		Event:     audit.LoginSuccess,
+		Username:  string(username),
+		UserIP:    userip.String(),
+		UserMAC:   usermac_str,
+		NasIP:     nasip.String(),
+		Method:    a.authMethod(string(username)),
+		Success:   true,
+		RequestID: logger.RequestID(r.Context()),
+	})
+
 	if usermac_str != "" {
 		ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
 		defer cancel()
 
-		formatmac := strings.ToLower(strings.ReplaceAll(strings.ReplaceAll(usermac_str, ":", ""), "-", ""))
-		key := MacSessionPfrefix + formatmac
-		if err := a.redisClient.SetEx(ctx, key, string(username), MacSessionExpire).Err(); err != nil {
+		key := MacSessionPfrefix + normalizeMAC(usermac_str)
+		if err := a.state.Set(ctx, key, string(username), MacSessionExpire); err != nil {
 			log.WithFields(logrus.Fields{
 				"error": err,
 				"mac":   usermac_str,
-			}).Error("Failed to save MAC to Redis")
+			}).Error("Failed to save MAC binding")
 			handleResponse(w, http.StatusInternalServerError, Response{
 				Message: "系统错误，请稍后重试",
 			})
@@ -219,9 +427,37 @@ func (a *Authenticator) HandleLogin(w http.ResponseWriter, r *http.Request) {
 	handleResponse(w, http.StatusOK, Response{
 		Message: "登录成功",
 		Data: map[string]interface{}{
-			"username": string(username),
-			"userip":   userip.String(),
-			"timeout":  "7天",
+			"username":       string(username),
+			"userip":         userip.String(),
+			"timeout":        formatDuration(MacSessionExpire),
+			"expire_seconds": int(MacSessionExpire.Seconds()),
+			"token":          sessionToken(nas, *session),
+		},
+	})
+}
+
+// rejectLocked 应答处于锁定中的登录请求
+func rejectLocked(w http.ResponseWriter, log *logrus.Entry, record audit.Record, d time.Duration, scope string) {
+	log.WithFields(logrus.Fields{
+		"scope":       scope,
+		"retry_after": d.String(),
+	}).Warn("Rejected login during lockout")
+	record.Reason = "locked out by " + scope
+	audit.Log(record)
+	if scope == ScopeSMS {
+		handleResponse(w, http.StatusTooManyRequests, Response{
+			Code:    CodeTooMany,
+			Message: "验证码错误次数过多，请重新获取验证码",
+		})
+		return
+	}
+	retry := int(d.Round(time.Second).Seconds())
+	w.Header().Set("Retry-After", strconv.Itoa(retry))
+	handleResponse(w, http.StatusTooManyRequests, Response{
+		Code:    CodeTooMany,
+		Message: "登录失败次数过多，请稍后再试",
+		Data: map[string]interface{}{
+			"retry_after": retry,
 		},
 	})
 }
@@ -252,7 +488,33 @@ func (a *Authenticator) HandleLogout(w http.ResponseWriter, r *http.Request) {
 	})
 	log.Info("Received logout request")
 
-	if _, err := Logout(userip, nasip); err != nil {
+	if err := verifySession(r, portal.NasFor(nasip), userip); err != nil {
+		log.WithFields(logrus.Fields{
+			"error":     err,
+			"source_ip": r.RemoteAddr,
+		}).Warn("Rejected unverified logout request")
+		handleResponse(w, http.StatusForbidden, Response{
+			Message: "无权下线该用户",
+		})
+		return
+	}
+
+	_, err := Logout(r.Context(), userip, nasip)
+	record := audit.Record{
+		Event:     audit.Logout,
+		UserIP:    userip.String(),
+		UserMAC:   r.FormValue("usermac"),
+		NasIP:     nasip.String(),
+		Operator:  r.RemoteAddr,
+		Success:   err == nil,
+		RequestID: logger.RequestID(r.Context()),
+	}
+	if err != nil {
+		record.Reason = err.Error()
+	}
+	audit.Log(record)
+
+	if err != nil {
 		log.WithFields(logrus.Fields{
 			"error": err,
 		}).Error("Logout failed")
@@ -262,6 +524,7 @@ func (a *Authenticator) HandleLogout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	endSession(userip)
 	log.Info("User logged out successfully")
 
 	handleResponse(w, http.StatusOK, Response{
@@ -270,8 +533,14 @@ func (a *Authenticator) HandleLogout(w http.ResponseWriter, r *http.Request) {
 }
 
 func (a *Authenticator) HandleRoot(w http.ResponseWriter, r *http.Request) {
-	handleResponse(w, http.StatusOK, Response{
-		Message: "抱歉，您无权访问此页面。请通过正确的认证流程访问网络。",
+	status := http.StatusOK
+	message := "抱歉，您无权访问此页面。请通过正确的认证流程访问网络。"
+	if r.URL.Path != "/" {
+		status = http.StatusNotFound
+		message = "页面不存在"
+	}
+	handleResponse(w, status, Response{
+		Message: message,
 		Data: struct {
 			RemoteAddr string
 			Path       string
@@ -300,13 +569,6 @@ func (a *Authenticator) HandleSendCode(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !strings.Contains(r.Header.Get("Referer"), "/portal") {
-		handleResponse(w, http.StatusForbidden, Response{
-			Message: "请从Portal页面获取验证码",
-		})
-		return
-	}
-
 	var req struct {
 		Phone string `json:"phone"`
 	}
@@ -324,37 +586,72 @@ func (a *Authenticator) HandleSendCode(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	code := fmt.Sprintf("%06d", rand.Intn(1000000))
-
 	log := logger.WithRequest(r).WithFields(logrus.Fields{
 		"phone": req.Phone,
 	})
-
-	if err := a.smsProvider.SendCode(req.Phone, code); err != nil {
-		log.WithFields(logrus.Fields{
-			"error": err,
-		}).Error("Failed to send SMS")
-		handleResponse(w, http.StatusInternalServerError, Response{
-			Message: "发送验证码失败，请稍后重试",
+	if e, ok := Lists.Lookup(ListDeny, KindPhone, req.Phone); ok {
+		logDenied(log, e)
+		audit.Log(audit.Record{
+			Event:     audit.SMSSend,
+			Phone:     req.Phone,
+			Reason:    "denied: " + e.Reason,
+			RequestID: logger.RequestID(r.Context()),
+		})
+		handleResponse(w, http.StatusForbidden, Response{
+			Code:    CodeDenied,
+			Message: "该手机号已被禁止使用，请联系管理员",
 		})
 		return
 	}
 
+	code := fmt.Sprintf("%06d", rand.Intn(1000000))
+	smsLog := logger.Subsystem(logger.SMS).WithFields(logrus.Fields{
+		"phone":      req.Phone,
+		"request_id": logger.RequestID(r.Context()),
+	})
+
+	// 先保存验证码再发送短信，保存失败时不发出无法验证的验证码
 	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
 	defer cancel()
 
 	key := SMSCodePrefix + req.Phone
-	if err := a.redisClient.SetEx(ctx, key, code, SMSCodeExpire).Err(); err != nil {
+	if err := a.codeStore().Set(ctx, key, code, SMSCodeExpire); err != nil {
 		log.WithFields(logrus.Fields{
 			"error": err,
-		}).Error("Failed to save code to Redis")
+		}).Error("Failed to save SMS code")
+		handleResponse(w, http.StatusServiceUnavailable, Response{
+			Message: "系统繁忙，请稍后重试",
+		})
+		return
+	}
+
+	err := a.smsProvider.SendCode(req.Phone, code)
+	record := audit.Record{
+		Event:     audit.SMSSend,
+		Phone:     req.Phone,
+		Success:   err == nil,
+		RequestID: logger.RequestID(r.Context()),
+	}
+	if err != nil {
+		record.Reason = err.Error()
+	}
+	audit.Log(record)
+
+	if err != nil {
+		smsLog.WithFields(logrus.Fields{
+			"error": err,
+		}).Error("Failed to send SMS")
+		a.codeStore().Del(ctx, key)
 		handleResponse(w, http.StatusInternalServerError, Response{
-			Message: "系统错误，请稍后重试",
+			Message: "发送验证码失败，请稍后重试",
 		})
 		return
 	}
 
-	log.Info("Successfully sent SMS code")
+	// 新验证码重新计算输错次数
+	a.state.Del(ctx, smsFailPrefix+req.Phone)
+
+	smsLog.Info("Successfully sent SMS code")
 
 	handleResponse(w, http.StatusOK, Response{
 		Message: "验证码已发送",
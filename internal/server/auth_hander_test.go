@@ -0,0 +1,51 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"syler/internal/store"
+)
+
+// down 写入总是失败的存储，模拟Redis不可用
+type down struct {
+	*store.Memory
+}
+
+func (d *down) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	return errors.New("connection refused")
+}
+
+type countingSMS struct {
+	sent int
+}
+
+func (c *countingSMS) SendCode(phone, code string) error {
+	c.sent++
+	return nil
+}
+
+func TestSendCodeStoreDown(t *testing.T) {
+	defer func(l *AccessLists) { Lists = l }(Lists)
+	Lists = new(AccessLists)
+
+	provider := new(countingSMS)
+	a := &Authenticator{
+		state:       store.NewFallback(&down{Memory: store.NewMemory()}, store.NewMemory()),
+		smsProvider: provider,
+	}
+	r := httptest.NewRequest(http.MethodPost, "/api/sendcode", strings.NewReader(`{"phone":"13800000000"}`))
+	w := httptest.NewRecorder()
+	a.HandleSendCode(w, r)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status %d, want 503", w.Code)
+	}
+	if provider.sent != 0 {
+		t.Errorf("sent %d SMS while the code could not be saved", provider.sent)
+	}
+}
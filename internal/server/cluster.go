@@ -0,0 +1,56 @@
+package server
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	"syler/internal/cluster"
+	"syler/internal/logger"
+	"syler/internal/portal"
+	"syler/internal/store"
+)
+
+var clusterNode *cluster.Cluster
+
+// StartCluster 启用集群模式：会话存入Redis，应答在节点间转发，空闲检测只在主节点执行。
+// 需在InitAuthenticator之后、StartPortal之前调用
+func StartCluster(done <-chan struct{}) {
+	if !viper.GetBool("cluster.enabled") {
+		return
+	}
+	log := logger.Subsystem(logger.Portal)
+	rdb, ok := store.RedisClient(AuthHandler.state)
+	if !ok {
+		log.Fatal("Cluster mode requires the redis store backend")
+	}
+
+	prefix := viper.GetString("cluster.prefix")
+	clusterNode = cluster.New(rdb, cluster.Config{
+		NodeID:    viper.GetString("cluster.node_id"),
+		Prefix:    prefix,
+		LeaderTTL: viper.GetDuration("cluster.leader_ttl"),
+	})
+	Sessions = NewRedisSessions(rdb, prefix)
+	IsLeader = clusterNode.IsLeader
+	portal.SetForwarder(clusterNode)
+
+	log.WithFields(logrus.Fields{
+		"node_id": clusterNode.NodeID(),
+		"prefix":  prefix,
+	}).Info("Starting in cluster mode")
+	go clusterNode.Run(done)
+}
+
+func checkCluster() check {
+	if clusterNode == nil {
+		return check{Status: "disabled"}
+	}
+	role := "follower"
+	if clusterNode.IsLeader() {
+		role = "leader"
+	}
+	return check{Status: "ok", Detail: map[string]string{
+		"node_id": clusterNode.NodeID(),
+		"role":    role,
+	}}
+}
@@ -0,0 +1,351 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	"syler/internal/audit"
+	"syler/internal/logger"
+	"syler/internal/store"
+)
+
+// LoginGuard 登录失败次数限制。按用户名、请求来源IP和NAS分别计数，达到上限后锁定，
+// 锁定期满后再次失败时锁定时长翻倍，直到max_lockout。
+// IP计数使用请求的来源地址而不是表单中的userip，客户端无法借伪造的userip绕过或锁定他人
+type LoginGuard struct {
+	Window         time.Duration `mapstructure:"window"`           // 失败计数在最后一次失败后保留的时长
+	MaxFailures    int           `mapstructure:"max_failures"`     // 同一用户名的失败次数上限，0表示不限制
+	IPMaxFailures  int           `mapstructure:"ip_max_failures"`  // 同一来源IP的失败次数上限
+	NasMaxFailures int           `mapstructure:"nas_max_failures"` // 同一NAS下所有用户的失败次数上限，任一客户端都能借此锁定整个NAS，默认不启用
+	Lockout        time.Duration `mapstructure:"lockout"`          // 首次锁定时长
+	MaxLockout     time.Duration `mapstructure:"max_lockout"`
+	SMSMaxFailures int           `mapstructure:"sms_max_failures"` // 验证码输错该次数后作废，需重新获取
+}
+
+var defaultLoginGuard = LoginGuard{
+	Window:         time.Hour,
+	MaxFailures:    5,
+	IPMaxFailures:  20,
+	Lockout:        time.Minute,
+	MaxLockout:     time.Hour,
+	SMSMaxFailures: 3,
+}
+
+var loginGuard = defaultLoginGuard
+
+// 计数键前缀
+const (
+	loginFailPrefix = "login_fail:"
+	loginLockPrefix = "login_lock:"
+	smsFailPrefix   = "sms_fail:"
+)
+
+// 登录失败和锁定次数，在/readyz中展示
+var (
+	loginFailures atomic.Int64
+	loginLockouts atomic.Int64
+)
+
+// LoadLoginGuard 读取 login_guard 配置，未配置的项使用默认值
+func LoadLoginGuard() {
+	g := defaultLoginGuard
+	if err := viper.UnmarshalKey("login_guard", &g); err != nil {
+		logger.Subsystem(logger.HTTP).WithField("error", err).Warn("Invalid login_guard, using defaults")
+		g = defaultLoginGuard
+	}
+	loginGuard = g
+}
+
+// guardScope 一个失败计数的对象
+type guardScope struct {
+	scope string // username, ip, nas
+	id    string
+	max   int
+}
+
+func (s guardScope) failKey() string {
+	return loginFailPrefix + s.scope + ":" + s.id
+}
+
+func (s guardScope) lockKey() string {
+	return loginLockPrefix + s.scope + ":" + s.id
+}
+
+func (g *LoginGuard) scopes(username string, clientip, nasip net.IP) []guardScope {
+	all := []guardScope{
+		{"username", username, g.MaxFailures},
+		{"ip", clientip.String(), g.IPMaxFailures},
+		{"nas", nasip.String(), g.NasMaxFailures},
+	}
+	scopes := all[:0]
+	for _, s := range all {
+		if s.max > 0 {
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes
+}
+
+// lockoutFor 失败次数超过上限excess次时的锁定时长
+func (g *LoginGuard) lockoutFor(excess int) time.Duration {
+	d := g.Lockout
+	for i := 0; i < excess && d < g.MaxLockout; i++ {
+		d *= 2
+	}
+	if g.MaxLockout > 0 && d > g.MaxLockout {
+		d = g.MaxLockout
+	}
+	return d
+}
+
+// countsAsFailure 只有凭证类的失败计入次数，NAS超时、繁忙等不计
+func countsAsFailure(code string) bool {
+	switch code {
+	case CodeTimeout, CodeBusy, CodeSystemError, CodeAlreadyOnline:
+		return false
+	}
+	return true
+}
+
+// lockState 锁定记录，值为 <截止时间>/<超过上限的次数>。锁定期满后记录在window内保留，
+// 超过上限后的下一次尝试须接在上一次锁定之后，同时到达的请求只有一个能继续
+type lockState struct {
+	until  time.Time
+	excess int64
+}
+
+func (a *Authenticator) readLock(ctx context.Context, s guardScope) (lockState, bool) {
+	v, err := a.state.Get(ctx, s.lockKey())
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			logger.FromContext(ctx).WithField("error", err).Error("Failed to read login lockout")
+		}
+		return lockState{}, false
+	}
+	until, excess, _ := strings.Cut(v, "/")
+	sec, _ := strconv.ParseInt(until, 10, 64)
+	n, _ := strconv.ParseInt(excess, 10, 64)
+	return lockState{until: time.Unix(sec, 0), excess: n}, true
+}
+
+func (a *Authenticator) writeLock(ctx context.Context, s guardScope, l lockState) error {
+	v := strconv.FormatInt(l.until.Unix(), 10) + "/" + strconv.FormatInt(l.excess, 10)
+	ttl := loginGuard.Window
+	if d := time.Until(l.until); d > 0 {
+		ttl += d
+	}
+	return a.state.Set(ctx, s.lockKey(), v, ttl)
+}
+
+// loginLocked 返回锁定剩余时长和锁定的对象，未锁定时返回0。存储不可用时不锁定。
+// clientip为请求的来源地址
+func (a *Authenticator) loginLocked(ctx context.Context, username string, clientip, nasip net.IP) (time.Duration, string) {
+	if a.state == nil {
+		return 0, ""
+	}
+	now := time.Now()
+	for _, s := range loginGuard.scopes(username, clientip, nasip) {
+		if l, ok := a.readLock(ctx, s); ok {
+			if d := l.until.Sub(now); d > 0 {
+				return d, s.scope
+			}
+		}
+	}
+	return 0, ""
+}
+
+// reservation 一次尝试在某个对象上占用的计数
+type reservation struct {
+	guardScope
+	n    int64
+	prev *lockState // 本次尝试写入锁定前的记录，nil表示写入前没有锁定
+	lock bool       // 本次尝试达到上限并写入了锁定
+}
+
+// loginAttempt 认证前预留的一次登录尝试。并发的猜测在认证结果返回前就占用计数，
+// 超过上限的请求直接拒绝，不能借同时提交绕过失败次数和验证码输错次数的限制
+type loginAttempt struct {
+	a        *Authenticator
+	username string
+	clientip net.IP
+	nasip    net.IP
+	method   string
+	reserved []reservation
+	sms      int64 // 验证码的输错计数，0表示未计数
+}
+
+// ScopeSMS 验证码输错次数达到上限时返回的锁定对象
+const ScopeSMS = "sms"
+
+// beginLogin 在各对象的失败计数上预留一次尝试，超过上限时返回锁定剩余时长和对象。
+// 验证码输错次数超过上限时作废验证码，返回的对象为ScopeSMS，剩余时长为0
+func (a *Authenticator) beginLogin(ctx context.Context, username string, clientip, nasip net.IP, method string) (*loginAttempt, time.Duration, string) {
+	t := &loginAttempt{a: a, username: username, clientip: clientip, nasip: nasip, method: method}
+	if a.state == nil {
+		return t, 0, ""
+	}
+	log := logger.FromContext(ctx)
+	now := time.Now()
+	for _, s := range loginGuard.scopes(username, clientip, nasip) {
+		l, locked := a.readLock(ctx, s)
+		if locked && l.until.After(now) {
+			t.release(ctx)
+			return nil, l.until.Sub(now), s.scope
+		}
+		n, err := a.state.Incr(ctx, s.failKey(), loginGuard.Window)
+		if err != nil {
+			log.WithField("error", err).Error("Failed to count login attempt")
+			continue
+		}
+		r := reservation{guardScope: s, n: n}
+		excess := n - int64(s.max)
+		if excess > 0 && (!locked || l.excess != excess-1) {
+			// 上一次锁定之后已有其他请求在尝试
+			a.state.IncrBy(ctx, s.failKey(), -1, loginGuard.Window)
+			t.release(ctx)
+			return nil, loginGuard.lockoutFor(int(excess) - 1), s.scope
+		}
+		if excess >= 0 {
+			if locked {
+				r.prev = &l
+			}
+			next := lockState{until: now.Add(loginGuard.lockoutFor(int(excess))), excess: excess}
+			if err := a.writeLock(ctx, s, next); err != nil {
+				log.WithField("error", err).Error("Failed to save login lockout")
+			} else {
+				r.lock = true
+			}
+		}
+		t.reserved = append(t.reserved, r)
+	}
+
+	if method != "sms" || loginGuard.SMSMaxFailures <= 0 {
+		return t, 0, ""
+	}
+	n, err := a.state.Incr(ctx, smsFailPrefix+username, SMSCodeExpire)
+	if err != nil {
+		log.WithField("error", err).Error("Failed to count SMS code attempt")
+		return t, 0, ""
+	}
+	t.sms = n
+	if n > int64(loginGuard.SMSMaxFailures) {
+		// 计数保留到重新获取验证码，期间的尝试都被拒绝
+		a.state.Del(ctx, SMSCodePrefix+username)
+		t.sms = 0
+		t.release(ctx)
+		log.WithField("attempts", n).Warn("Too many SMS code attempts, code invalidated")
+		return nil, 0, ScopeSMS
+	}
+	return t, 0, ""
+}
+
+// release 归还预留的计数，用于NAS超时、繁忙等不计入失败的结果
+func (t *loginAttempt) release(ctx context.Context) {
+	st := t.a.state
+	for _, r := range t.reserved {
+		st.IncrBy(ctx, r.failKey(), -1, loginGuard.Window)
+		t.unlock(ctx, r)
+	}
+	t.reserved = nil
+	if t.sms > 0 {
+		st.IncrBy(ctx, smsFailPrefix+t.username, -1, SMSCodeExpire)
+		t.sms = 0
+	}
+}
+
+// unlock 撤销本次尝试写入的锁定，恢复之前已期满的记录
+func (t *loginAttempt) unlock(ctx context.Context, r reservation) {
+	if !r.lock {
+		return
+	}
+	if r.prev == nil {
+		t.a.state.Del(ctx, r.lockKey())
+		return
+	}
+	t.a.writeLock(ctx, r.guardScope, *r.prev)
+}
+
+// failed 认证失败。counts为false时不计入失败次数；达到上限的对象保持锁定，
+// 验证码输错次数达到上限时作废验证码
+func (t *loginAttempt) failed(ctx context.Context, counts bool) {
+	if !counts {
+		t.release(ctx)
+		return
+	}
+	if t.a.state == nil {
+		return
+	}
+	loginFailures.Add(1)
+	log := logger.FromContext(ctx).WithFields(logrus.Fields{
+		"username":  t.username,
+		"source_ip": t.clientip.String(),
+		"nas_ip":    t.nasip.String(),
+	})
+	for _, r := range t.reserved {
+		if !r.lock {
+			continue
+		}
+		d := loginGuard.lockoutFor(int(r.n) - r.max)
+		loginLockouts.Add(1)
+		log.WithFields(logrus.Fields{
+			"scope":    r.scope,
+			"failures": r.n,
+			"lockout":  d.String(),
+		}).Warn("Too many failed logins, locking out")
+		audit.Log(audit.Record{
+			Event:     audit.Lockout,
+			Username:  t.username,
+			NasIP:     t.nasip.String(),
+			Method:    t.method,
+			Success:   true,
+			Reason:    fmt.Sprintf("%s %s: %d failures, locked %s", r.scope, r.id, r.n, d),
+			RequestID: logger.RequestID(ctx),
+		})
+	}
+
+	if t.sms >= int64(loginGuard.SMSMaxFailures) && t.sms > 0 {
+		t.a.state.Del(ctx, SMSCodePrefix+t.username)
+		log.WithField("failures", t.sms).Warn("Too many wrong SMS codes, code invalidated")
+	}
+}
+
+// succeeded 登录成功后清除用户名和来源IP的失败计数和锁定，NAS的计数只归还本次预留的
+func (t *loginAttempt) succeeded(ctx context.Context) {
+	st := t.a.state
+	if st == nil {
+		return
+	}
+	for _, r := range t.reserved {
+		if r.scope == "nas" {
+			st.IncrBy(ctx, r.failKey(), -1, loginGuard.Window)
+			t.unlock(ctx, r)
+			continue
+		}
+		st.Del(ctx, r.failKey())
+		if r.lock {
+			st.Del(ctx, r.lockKey())
+		}
+	}
+	st.Del(ctx, smsFailPrefix+t.username)
+}
+
+// checkLoginGuard readyz中展示启动以来的失败和锁定次数
+func checkLoginGuard() check {
+	detail := map[string]int64{
+		"failures": loginFailures.Load(),
+		"lockouts": loginLockouts.Load(),
+	}
+	if len(loginGuard.scopes("", nil, nil)) == 0 && loginGuard.SMSMaxFailures <= 0 {
+		return check{Status: "disabled", Detail: detail}
+	}
+	return check{Status: "ok", Detail: detail}
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"syler/internal/portal"
+	"syler/internal/store"
+)
+
+func TestLockoutBackoff(t *testing.T) {
+	g := LoginGuard{Lockout: time.Minute, MaxLockout: 10 * time.Minute}
+	for excess, want := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 10 * time.Minute, 10 * time.Minute} {
+		if d := g.lockoutFor(excess); d != want {
+			t.Errorf("excess %d: %v, want %v", excess, d, want)
+		}
+	}
+}
+
+// fail 记录一次计入失败次数的认证失败
+func fail(ctx context.Context, a *Authenticator, username string, clientip, nasip net.IP, method string) {
+	if t, _, _ := a.beginLogin(ctx, username, clientip, nasip, method); t != nil {
+		t.failed(ctx, true)
+	}
+}
+
+func succeed(ctx context.Context, a *Authenticator, username string, clientip, nasip net.IP) {
+	if t, _, _ := a.beginLogin(ctx, username, clientip, nasip, "chap"); t != nil {
+		t.succeeded(ctx)
+	}
+}
+
+func TestLoginGuard(t *testing.T) {
+	defer func(s store.Store, g LoginGuard) { AuthHandler.state = s; loginGuard = g }(AuthHandler.state, loginGuard)
+	AuthHandler.state = store.NewMemory()
+	loginGuard = LoginGuard{Window: time.Hour, MaxFailures: 3, IPMaxFailures: 5, Lockout: time.Minute, MaxLockout: time.Hour, SMSMaxFailures: 2}
+	ctx := context.Background()
+	a := AuthHandler
+	userip, nasip := net.ParseIP("10.0.0.8"), net.ParseIP("192.168.10.3")
+	phone := "13800000000"
+	a.state.Set(ctx, SMSCodePrefix+phone, "123456", SMSCodeExpire)
+
+	fail(ctx, a, phone, userip, nasip, "sms")
+	if d, _ := a.loginLocked(ctx, phone, userip, nasip); d != 0 {
+		t.Errorf("locked after one failure: %v", d)
+	}
+	fail(ctx, a, phone, userip, nasip, "sms")
+	if _, err := a.state.Get(ctx, SMSCodePrefix+phone); err != store.ErrNotFound {
+		t.Errorf("SMS code not invalidated: %v", err)
+	}
+	if attempt, _, scope := a.beginLogin(ctx, phone, userip, nasip, "sms"); attempt != nil || scope != ScopeSMS {
+		t.Errorf("SMS attempt allowed after the code was invalidated")
+	}
+	// 重新获取验证码后重新计算输错次数
+	a.state.Del(ctx, smsFailPrefix+phone)
+	fail(ctx, a, phone, userip, nasip, "sms")
+	d, scope := a.loginLocked(ctx, phone, userip, nasip)
+	if scope != "username" || d <= 0 || d > time.Minute {
+		t.Errorf("lockout: %v %q", d, scope)
+	}
+	// 其他用户名在同一IP上不受用户名锁定影响
+	if d, _ := a.loginLocked(ctx, "alice", userip, nasip); d != 0 {
+		t.Errorf("other user locked: %v", d)
+	}
+
+	// 锁定期满后再次失败，锁定时长翻倍
+	a.writeLock(ctx, guardScope{"username", phone, 3}, lockState{until: time.Now().Add(-time.Second)})
+	fail(ctx, a, phone, userip, nasip, "sms")
+	if d, _ := a.loginLocked(ctx, phone, userip, nasip); d <= time.Minute {
+		t.Errorf("backoff not applied: %v", d)
+	}
+
+	// 同一IP失败次数达到上限后锁定该IP
+	fail(ctx, a, "bob", userip, nasip, "chap")
+	if d, scope := a.loginLocked(ctx, "carol", userip, nasip); d <= 0 || scope != "ip" {
+		t.Errorf("ip lockout: %v %q", d, scope)
+	}
+
+	other := net.ParseIP("10.0.0.9")
+	succeed(ctx, a, "dave", other, nasip)
+	fail(ctx, a, "dave", other, nasip, "chap")
+	succeed(ctx, a, "dave", other, nasip)
+	if _, err := a.state.Get(ctx, guardScope{"username", "dave", 3}.failKey()); err != store.ErrNotFound {
+		t.Errorf("failures not reset after success: %v", err)
+	}
+
+	// NAS超时等不计入失败的结果归还预留的计数
+	for i := 0; i < 5; i++ {
+		if t, _, _ := a.beginLogin(ctx, "erin", other, nasip, "chap"); t != nil {
+			t.failed(ctx, false)
+		}
+	}
+	if d, _ := a.loginLocked(ctx, "erin", other, nasip); d != 0 {
+		t.Errorf("locked by failures that do not count: %v", d)
+	}
+}
+
+// 同时提交的猜测在认证结果返回前就占用计数，超过上限的直接拒绝
+func TestLoginGuardConcurrent(t *testing.T) {
+	defer func(s store.Store, g LoginGuard) { AuthHandler.state = s; loginGuard = g }(AuthHandler.state, loginGuard)
+	AuthHandler.state = store.NewMemory()
+	loginGuard = LoginGuard{Window: time.Hour, MaxFailures: 3, Lockout: time.Minute, MaxLockout: time.Hour, SMSMaxFailures: 2}
+	a := AuthHandler
+
+	nas := startTestNas(t, 2)
+	defer nas.Close()
+	var wg sync.WaitGroup
+	codes := make(chan int, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			userip := fmt.Sprintf("10.0.3.%d", i+1)
+			form := url.Values{"userip": {userip}, "nasip": {nas.Addr().IP.String()}, "username": {"user"}, "userpwd": {"wrong"}}
+			r := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.RemoteAddr = userip + ":40000"
+			w := httptest.NewRecorder()
+			a.HandleLogin(w, r)
+			codes <- w.Code
+		}(i)
+	}
+	wg.Wait()
+	close(codes)
+	tried := 0
+	for code := range codes {
+		if code != http.StatusTooManyRequests {
+			tried++
+		}
+	}
+	if tried != 3 {
+		t.Errorf("%d concurrent guesses reached the NAS, want 3", tried)
+	}
+
+	// 并发提交验证码时最多尝试sms_max_failures次
+	ctx := context.Background()
+	phone := "13800000001"
+	a.state.Set(ctx, SMSCodePrefix+phone, "123456", SMSCodeExpire)
+	var allowed atomic.Int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if t, _, _ := a.beginLogin(ctx, phone, net.IPv4(10, 0, 4, byte(i+1)), nas.Addr().IP, "sms"); t != nil {
+				allowed.Add(1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if n := allowed.Load(); n != 2 {
+		t.Errorf("%d concurrent SMS code attempts allowed, want 2", n)
+	}
+	if _, err := a.state.Get(ctx, SMSCodePrefix+phone); err != store.ErrNotFound {
+		t.Errorf("SMS code not invalidated after too many attempts: %v", err)
+	}
+}
+
+// 表单中的userip由客户端填写，更换userip不能绕过来源地址的锁定
+func TestLoginGuardSourceIP(t *testing.T) {
+	defer func(s store.Store, g LoginGuard, n portal.Nas) {
+		AuthHandler.state = s
+		loginGuard = g
+		portal.DefaultNas = n
+	}(AuthHandler.state, loginGuard, portal.DefaultNas)
+	AuthHandler.state = store.NewMemory()
+	loginGuard = LoginGuard{Window: time.Hour, IPMaxFailures: 1, Lockout: time.Minute, MaxLockout: time.Hour}
+	portal.DefaultNas.RedirectAuth = RedirectNone
+
+	a := AuthHandler
+	fail(context.Background(), a, "bob", net.ParseIP("192.0.2.1"), net.ParseIP("192.168.10.3"), "chap")
+
+	form := url.Values{"userip": {"10.0.0.99"}, "nasip": {"192.168.10.3"}, "username": {"carol"}, "userpwd": {"x"}}
+	r := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.RemoteAddr = "192.0.2.1:40000"
+	w := httptest.NewRecorder()
+	a.HandleLogin(w, r)
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("status %d, want 429 for a locked source address", w.Code)
+	}
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"syler/internal/portal"
+	"syler/internal/store"
+)
+
+type check struct {
+	Status string      `json:"status"` // ok, degraded, fail, disabled
+	Error  string      `json:"error,omitempty"`
+	Detail interface{} `json:"detail,omitempty"`
+}
+
+// HandleHealthz 进程存活检查
+func (a *Authenticator) HandleHealthz(w http.ResponseWriter, r *http.Request) {
+	handleResponse(w, http.StatusOK, Response{
+		Message: "ok",
+	})
+}
+
+// HandleReadyz 就绪检查，依次检查状态存储、Portal端口、NAS可达性和短信服务
+func (a *Authenticator) HandleReadyz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]check{
+		"store":   a.checkStore(r.Context()),
+		"portal":  checkPortal(),
+		"nas":     checkNas(),
+		"sms":     a.checkSMS(),
+		"cluster": checkCluster(),
+		"login":   checkLoginGuard(),
+	}
+
+	status := http.StatusOK
+	message := "ready"
+	for _, c := range checks {
+		if c.Status == "fail" {
+			status = http.StatusServiceUnavailable
+			message = "not ready"
+			break
+		}
+	}
+
+	handleResponse(w, status, Response{
+		Message: message,
+		Data:    checks,
+	})
+}
+
+// checkStore Redis不可用但已降级到本地内存时仍可服务，状态为degraded
+func (a *Authenticator) checkStore(ctx context.Context) check {
+	if a.state == nil {
+		return check{Status: "fail", Error: "state store not initialized"}
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	err := a.state.Ping(ctx)
+	if err == nil {
+		return check{Status: "ok"}
+	}
+	if _, ok := a.state.(*store.Fallback); ok {
+		return check{Status: "degraded", Error: err.Error()}
+	}
+	return check{Status: "fail", Error: err.Error()}
+}
+
+func checkPortal() check {
+	if !portal.Bound() {
+		return check{Status: "fail", Error: "portal UDP socket not bound"}
+	}
+	return check{Status: "ok", Detail: map[string]int{"port": viper.GetInt("portal.port")}}
+}
+
+// checkNas NAS可达性取自最近一次Portal交互，最近一次请求超时的NAS视为不可达。
+// 单台NAS故障不影响其他NAS下的用户认证，只报告degraded，不使就绪检查失败
+func checkNas() check {
+	return nasCheck(portal.NasStates())
+}
+
+func nasCheck(states map[string]portal.NasState) check {
+	c := check{Status: "ok", Detail: states}
+	var down []string
+	for ip, state := range states {
+		if !state.Reachable() {
+			down = append(down, ip)
+		}
+	}
+	if len(down) > 0 {
+		sort.Strings(down)
+		c.Status = "degraded"
+		c.Error = "NAS " + strings.Join(down, ", ") + " did not answer the last request"
+	}
+	return c
+}
+
+func (a *Authenticator) checkSMS() check {
+	if viper.GetString("sms.provider") == "" {
+		return check{Status: "disabled"}
+	}
+	if a.smsProvider == nil {
+		return check{Status: "fail", Error: "sms provider not initialized"}
+	}
+	return check{Status: "ok", Detail: map[string]string{"provider": viper.GetString("sms.provider")}}
+}
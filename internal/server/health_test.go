@@ -0,0 +1,47 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"syler/internal/portal"
+)
+
+func TestReadyzNotReady(t *testing.T) {
+	a := &Authenticator{}
+	w := httptest.NewRecorder()
+	a.HandleReadyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("readyz without redis and portal socket: got %d", w.Code)
+	}
+}
+
+func TestNasCheck(t *testing.T) {
+	now := time.Now()
+	c := nasCheck(map[string]portal.NasState{
+		"192.168.10.3": {LastSuccess: now},
+		"192.168.10.4": {LastSuccess: now.Add(-time.Minute), LastFailure: now},
+	})
+	if c.Status != "degraded" || c.Error != "NAS 192.168.10.4 did not answer the last request" {
+		t.Errorf("one NAS down: %s %q", c.Status, c.Error)
+	}
+	if c := nasCheck(nil); c.Status != "ok" {
+		t.Errorf("no NAS: %s", c.Status)
+	}
+}
+
+func TestRootNotFound(t *testing.T) {
+	a := &Authenticator{}
+	for path, want := range map[string]int{
+		"/":        http.StatusOK,
+		"/missing": http.StatusNotFound,
+	} {
+		w := httptest.NewRecorder()
+		a.HandleRoot(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != want {
+			t.Errorf("%s: got %d, want %d", path, w.Code, want)
+		}
+	}
+}
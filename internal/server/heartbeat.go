@@ -0,0 +1,163 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"syler/internal/audit"
+	"syler/internal/logger"
+	"syler/internal/portal"
+)
+
+// SweepInterval 空闲检测的间隔
+var SweepInterval = 10 * time.Second
+
+// IsLeader 集群模式下只有主节点执行空闲检测
+var IsLeader = func() bool { return true }
+
+// UserHeartbeat 处理H3C设备的NTF_USER_HEARTBEAT，刷新通告中在线用户的活动时间并应答
+func UserHeartbeat(msg portal.Message, basip net.IP) {
+	log := logger.Subsystem(logger.Portal).WithFields(logrus.Fields{
+		"nas_ip":    basip.String(),
+		"serial_no": msg.SerialId(),
+	})
+	users := portal.HeartbeatUsers(msg)
+	unknown := 0
+	for _, ip := range users {
+		if !Sessions.Touch(ip) {
+			unknown++
+		}
+	}
+	log.WithFields(logrus.Fields{
+		"users":   len(users),
+		"unknown": unknown,
+	}).Debug("Received user heartbeat")
+
+	nas := portal.NasFor(basip)
+	if _, err := portal.AckUserHeartbeat(context.Background(), msg.UserIp(), nas.Secret, basip, nas.Port, msg.SerialId(), msg.ReqId()); err != nil {
+		log.WithField("error", err).Error("Failed to acknowledge user heartbeat")
+	}
+}
+
+// StartSweeper 定期将超过NAS空闲时长未收到心跳、或超过访问策略截止时间的用户强制下线
+func StartSweeper(done <-chan struct{}) {
+	ticker := time.NewTicker(SweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			if IsLeader() {
+				sweepIdle(now)
+				sweepExpired(now)
+			}
+		}
+	}
+}
+
+// idleTimeout NAS下的用户超过这个时长没有心跳即下线，0表示不检查
+func idleTimeout(nasip net.IP) time.Duration {
+	return portal.NasFor(nasip).IdleTimeout
+}
+
+// sweepIdle 下线空闲用户
+func sweepIdle(now time.Time) {
+	idle := Sessions.Idle(now, idleTimeout)
+	for _, s := range idle {
+		logger.Subsystem(logger.Portal).WithFields(logrus.Fields{
+			"username":  s.Username,
+			"user_ip":   s.UserIP.String(),
+			"last_seen": s.LastSeen.Format(time.RFC3339),
+		}).Info("Heartbeat timed out, logging user out")
+		kick(context.Background(), s, "idle-timeout", "heartbeat timeout")
+	}
+}
+
+// kick 强制下线会话并记录审计日志。先删除会话再通知NAS，NAS未应答时也不再重复下发；
+// 会话已被其他请求下线时返回false
+func kick(ctx context.Context, s Session, operator, reason string) bool {
+	log := logger.FromContext(ctx).WithFields(logrus.Fields{
+		"username": s.Username,
+		"user_ip":  s.UserIP.String(),
+		"nas_ip":   s.NasIP.String(),
+		"operator": operator,
+	})
+	if endSession(s.UserIP) == nil {
+		return false
+	}
+	_, err := Logout(ctx, s.UserIP, s.NasIP)
+	record := audit.Record{
+		Event:     audit.Kick,
+		Username:  s.Username,
+		UserIP:    s.UserIP.String(),
+		UserMAC:   s.UserMAC,
+		NasIP:     s.NasIP.String(),
+		Operator:  operator,
+		Success:   err == nil,
+		Reason:    reason,
+		RequestID: logger.RequestID(ctx),
+	}
+	if err != nil {
+		log.WithField("error", err).Error("Failed to log out user")
+		record.Reason += ": " + err.Error()
+	}
+	audit.Log(record)
+	return true
+}
+
+// HandleHeartbeat 客户端心跳，刷新用户的活动时间。请求需来自用户IP、带有登录令牌token或来自登录时的浏览器
+func (a *Authenticator) HandleHeartbeat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		handleResponse(w, http.StatusMethodNotAllowed, Response{
+			Message: "仅支持POST请求",
+		})
+		return
+	}
+
+	userip := net.ParseIP(r.FormValue("userip"))
+	if userip == nil {
+		handleResponse(w, http.StatusBadRequest, Response{
+			Message: "无效的用户IP地址",
+		})
+		return
+	}
+
+	s, ok := Sessions.Get(userip)
+	if !ok {
+		logger.WithRequest(r).WithField("user_ip", userip).Debug("Heartbeat from offline user")
+		handleResponse(w, http.StatusNotFound, Response{
+			Message: "用户不在线",
+		})
+		return
+	}
+	// 与登出相同，只有会话本人能刷新活动时间，否则任何人都能让他人的会话永不空闲
+	if err := verifySession(r, portal.NasFor(s.NasIP), userip); err != nil {
+		logger.WithRequest(r).WithFields(logrus.Fields{
+			"user_ip":   userip,
+			"source_ip": r.RemoteAddr,
+			"error":     err,
+		}).Warn("Rejected heartbeat for another user's session")
+		handleResponse(w, http.StatusForbidden, Response{
+			Message: "无权操作该用户",
+		})
+		return
+	}
+	if !Sessions.Touch(userip) {
+		handleResponse(w, http.StatusNotFound, Response{
+			Message: "用户不在线",
+		})
+		return
+	}
+
+	handleResponse(w, http.StatusOK, Response{
+		Message: "ok",
+		Data: map[string]interface{}{
+			"idle_timeout": int(portal.NasFor(s.NasIP).IdleTimeout.Seconds()),
+		},
+	})
+}
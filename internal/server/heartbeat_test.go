@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"syler/internal/portal"
+)
+
+func TestHeartbeatSweep(t *testing.T) {
+	for _, ver := range []int{1, 2} {
+		nas := startTestNas(t, ver)
+		n := portal.NasFor(nas.Addr().IP)
+		n.Vendor = portal.H3C
+		n.IdleTimeout = time.Minute
+		portal.RegisterNas(n)
+
+		alive, idle := net.IPv4(10, 0, 1, 1).To4(), net.IPv4(10, 0, 1, 2).To4()
+		for _, ip := range []net.IP{alive, idle} {
+			if _, err := portal.PapAuth(context.Background(), ip, "secret", nas.Addr().IP, nas.Addr().Port, []byte("user"), []byte("pwd")); err != nil {
+				t.Fatalf("v%d: auth failed: %v", ver, err)
+			}
+			Sessions.Add(&Session{Username: "user", UserIP: ip, NasIP: nas.Addr().IP, LastSeen: time.Now().Add(-2 * time.Minute)})
+		}
+		// 模拟idle用户已在NAS上下线，心跳中只通告alive
+		Logout(context.Background(), idle, nas.Addr().IP)
+		if err := nas.Heartbeat(nil); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(100 * time.Millisecond)
+
+		acked := false
+		for _, m := range nas.Received() {
+			if m.Type() == portal.ACK_NTF_USER_HEARTBEAT {
+				acked = true
+			}
+		}
+		if !acked {
+			t.Errorf("v%d: NTF_USER_HEARTBEAT was not acknowledged", ver)
+		}
+
+		sweepIdle(time.Now())
+		if _, ok := Sessions.Get(alive); !ok {
+			t.Errorf("v%d: user with heartbeat was logged out", ver)
+		}
+		if _, ok := Sessions.Get(idle); ok {
+			t.Errorf("v%d: idle user still online", ver)
+		}
+
+		// 超过空闲时长后下线并通知NAS
+		sweepIdle(time.Now().Add(2 * time.Minute))
+		if _, ok := Sessions.Get(alive); ok || nas.Online(alive) {
+			t.Errorf("v%d: idle user not logged out", ver)
+		}
+		nas.Close()
+	}
+}
+
+func TestHandleHeartbeatOwner(t *testing.T) {
+	userip := net.ParseIP("10.0.6.1")
+	s := &Session{Username: "alice", UserIP: userip, NasIP: net.ParseIP("192.168.60.1"), LoginAt: time.Now()}
+	Sessions.Add(s)
+	defer Sessions.Remove(userip)
+
+	q := url.Values{"userip": {userip.String()}}
+	cases := []struct {
+		remote string
+		token  string
+		code   int
+	}{
+		{"10.0.6.1:5000", "", http.StatusOK},
+		{"10.0.6.2:5000", "", http.StatusForbidden},
+		{"10.0.6.2:5000", sessionToken(portal.NasFor(s.NasIP), *s), http.StatusOK},
+	}
+	for _, c := range cases {
+		q.Set("token", c.token)
+		w := httptest.NewRecorder()
+		AuthHandler.HandleHeartbeat(w, postForm("/api/heartbeat", q, c.remote))
+		if w.Code != c.code {
+			t.Errorf("%s token=%t: %d, want %d", c.remote, c.token != "", w.Code, c.code)
+		}
+	}
+}
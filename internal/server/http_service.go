@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"syler/internal/logger"
+	"syler/internal/web"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
@@ -36,55 +37,174 @@ func ErrorWrap(w http.ResponseWriter) {
 	}
 }
 
+// requestID 为每个请求分配请求ID，写入context和响应头
+func requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(logger.RequestIDHeader)
+		if id == "" || len(id) > 64 {
+			id = logger.NewRequestID()
+		}
+		w.Header().Set(logger.RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(logger.WithRequestID(r.Context(), id)))
+	})
+}
+
+// csrfProtect 要求页面提交的请求带有会话Cookie和CSRF令牌，http.csrf为false时不校验
+func csrfProtect(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if viper.GetBool("http.csrf") {
+			if err := web.VerifyCSRF(r); err != nil {
+				logger.WithRequest(r).WithFields(logrus.Fields{
+					"error":     err,
+					"source_ip": r.RemoteAddr,
+				}).Warn("Rejected request without valid CSRF token")
+				handleResponse(w, http.StatusForbidden, Response{
+					Code:    "csrf_failed",
+					Message: "页面已过期，请刷新后重试",
+				})
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
 func StartHttp() {
 
-	log := logger.GetLogger()
+	log := logger.Subsystem(logger.HTTP)
+
+	if key := viper.GetString("http.cookie_key"); key != "" {
+		web.SetCookieKey(key)
+	}
 
 	http.HandleFunc("/api/login", func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			ErrorWrap(w)
 		}()
 
-		AuthHandler.HandleLogin(w, r)
+		csrfProtect(AuthHandler.HandleLogin)(w, r)
 	})
 	http.HandleFunc("/api/logout", func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			ErrorWrap(w)
 		}()
 
-		AuthHandler.HandleLogout(w, r)
+		csrfProtect(AuthHandler.HandleLogout)(w, r)
 	})
 	http.HandleFunc("/api/sendcode", func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			ErrorWrap(w)
 		}()
 
-		AuthHandler.HandleSendCode(w, r)
+		csrfProtect(AuthHandler.HandleSendCode)(w, r)
+	})
+	http.HandleFunc("/api/status", func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			ErrorWrap(w)
+		}()
+
+		AuthHandler.HandleStatus(w, r)
+	})
+	http.HandleFunc("/api/heartbeat", func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			ErrorWrap(w)
+		}()
+
+		csrfProtect(AuthHandler.HandleHeartbeat)(w, r)
+	})
+	http.HandleFunc("/api/admin/lists", func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			ErrorWrap(w)
+		}()
+
+		adminAuth(AuthHandler.HandleLists)(w, r)
 	})
+	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			ErrorWrap(w)
+		}()
+
+		AuthHandler.HandleHealthz(w, r)
+	})
+	http.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			ErrorWrap(w)
+		}()
+
+		AuthHandler.HandleReadyz(w, r)
+	})
+	// 启用内置页面时由web包渲染认证页面，否则页面由nginx等单独提供
+	root := http.HandlerFunc(AuthHandler.HandleRoot)
+	if viper.GetBool("web.enabled") {
+		var cfg web.Config
+		if err := viper.UnmarshalKey("web", &cfg); err != nil {
+			log.WithField("error", err).Fatal("Invalid web config")
+		}
+		root = web.New(cfg).ServeHTTP
+		log.WithField("theme_dir", cfg.ThemeDir).Info("Serving built-in portal page")
+	}
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			ErrorWrap(w)
 		}()
 
-		AuthHandler.HandleRoot(w, r)
+		if AuthHandler.HandleProbe(w, r) {
+			return
+		}
+		root(w, r)
 	})
 
 	server := &http.Server{
 		Addr:              fmt.Sprintf("%s:%d", viper.GetString("http.host"), viper.GetInt("http.port")),
+		Handler:           requestID(http.DefaultServeMux),
 		ReadTimeout:       5 * time.Second,
 		WriteTimeout:      10 * time.Second,
 		IdleTimeout:       120 * time.Second,
 		ReadHeaderTimeout: 2 * time.Second,
 	}
 
+	var tlsCfg TLSConfig
+	if err := viper.UnmarshalKey("http.tls", &tlsCfg); err != nil {
+		log.WithField("error", err).Fatal("Invalid http.tls config")
+	}
+	if !tlsCfg.Enabled {
+		log.WithFields(logrus.Fields{
+			"host": viper.GetString("http.host"),
+			"port": viper.GetInt("http.port"),
+		}).Info("Starting HTTP server")
+
+		if err := server.ListenAndServe(); err != nil {
+			log.WithFields(logrus.Fields{
+				"error": err,
+			}).Fatal("Failed to start HTTP server")
+		}
+		return
+	}
+
+	config, certs, err := newTLSConfig(tlsCfg)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error":     err,
+			"cert_file": tlsCfg.CertFile,
+		}).Fatal("Invalid TLS config")
+	}
+	server.TLSConfig = config
+	if tlsCfg.ReloadInterval > 0 {
+		go certs.watch(tlsCfg.ReloadInterval)
+	}
+	if tlsCfg.RedirectPort > 0 {
+		go startRedirect(viper.GetString("http.host"), tlsCfg.RedirectPort, redirectHost(tlsCfg), viper.GetInt("http.port"))
+	}
+
 	log.WithFields(logrus.Fields{
-		"host": viper.GetString("http.host"),
-		"port": viper.GetInt("http.port"),
-	}).Info("Starting HTTP server")
+		"host":        viper.GetString("http.host"),
+		"port":        viper.GetInt("http.port"),
+		"min_version": tlsCfg.MinVersion,
+	}).Info("Starting HTTPS server")
 
-	if err := server.ListenAndServe(); err != nil {
+	if err := server.ListenAndServeTLS("", ""); err != nil {
 		log.WithFields(logrus.Fields{
 			"error": err,
-		}).Fatal("Failed to start HTTP server")
+		}).Fatal("Failed to start HTTPS server")
 	}
 }
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"regexp"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	"syler/internal/logger"
+)
+
+// 同一用户名在线设备数超出限制时的处理方式
+const (
+	LimitReject     = "reject"      // 拒绝新的登录
+	LimitKickOldest = "kick_oldest" // 登录成功后下线最早登录的设备
+)
+
+var ErrSessionLimit = errors.New("concurrent session limit reached")
+
+// LimitRule 用户名的最大在线设备数，Username为匹配用户名的正则表达式，为空时匹配所有用户
+type LimitRule struct {
+	Username string `mapstructure:"username"`
+	Max      int    `mapstructure:"max"` // 0表示不限制
+	Policy   string `mapstructure:"policy"`
+
+	pattern *regexp.Regexp
+}
+
+var limitRules []LimitRule
+
+// LoadSessionLimits 读取 session_limit 配置，rules按顺序匹配，都不匹配时使用max和policy
+func LoadSessionLimits() {
+	log := logger.Subsystem(logger.HTTP)
+
+	var rules []LimitRule
+	if err := viper.UnmarshalKey("session_limit.rules", &rules); err != nil {
+		log.WithField("error", err).Warn("Invalid session_limit.rules, ignoring")
+		rules = nil
+	}
+	rules = append(rules, LimitRule{
+		Max:    viper.GetInt("session_limit.max"),
+		Policy: viper.GetString("session_limit.policy"),
+	})
+	limitRules = compileLimits(rules)
+}
+
+func compileLimits(rules []LimitRule) []LimitRule {
+	compiled := make([]LimitRule, 0, len(rules))
+	for _, r := range rules {
+		if r.Username != "" {
+			p, err := regexp.Compile(r.Username)
+			if err != nil {
+				logger.Subsystem(logger.HTTP).WithFields(logrus.Fields{
+					"username": r.Username,
+					"error":    err,
+				}).Warn("Ignore session limit rule with invalid pattern")
+				continue
+			}
+			r.pattern = p
+		}
+		if r.Policy == "" {
+			r.Policy = LimitReject
+		}
+		compiled = append(compiled, r)
+	}
+	return compiled
+}
+
+// limitFor 返回用户名适用的限制
+func limitFor(username string) LimitRule {
+	for _, r := range limitRules {
+		if r.pattern == nil || r.pattern.MatchString(username) {
+			return r
+		}
+	}
+	return LimitRule{}
+}
+
+// otherDevices 返回用户名在其他设备上的会话，按登录时间从早到晚排序。
+// 同一IP或同一MAC的会话视为本设备重新登录，不计入
+func otherDevices(username string, userip net.IP, usermac string) []Session {
+	var list []Session
+	for _, s := range Sessions.ByUsername(username) {
+		if s.UserIP.Equal(userip) || (usermac != "" && normalizeMAC(s.UserMAC) == normalizeMAC(usermac)) {
+			continue
+		}
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].LoginAt.Before(list[j].LoginAt)
+	})
+	return list
+}
+
+// checkSessionLimit 认证前检查，reject策略下在线设备数已满时拒绝登录
+func checkSessionLimit(username string, userip net.IP, usermac string) error {
+	rule := limitFor(username)
+	if rule.Max <= 0 || rule.Policy != LimitReject {
+		return nil
+	}
+	if len(otherDevices(username, userip, usermac)) >= rule.Max {
+		return ErrSessionLimit
+	}
+	return nil
+}
+
+// enforceSessionLimit 认证成功后执行，kick_oldest策略下下线最早登录的设备，使在线设备数不超过限制
+func enforceSessionLimit(ctx context.Context, username string, userip net.IP, usermac string) {
+	rule := limitFor(username)
+	if rule.Max <= 0 || rule.Policy != LimitKickOldest {
+		return
+	}
+	others := otherDevices(username, userip, usermac)
+	for i := 0; i < len(others)-(rule.Max-1); i++ {
+		s := others[i]
+		logger.FromContext(ctx).WithFields(logrus.Fields{
+			"username": username,
+			"user_ip":  s.UserIP.String(),
+			"max":      rule.Max,
+		}).Info("Session limit reached, logging out oldest device")
+		kick(ctx, s, "session-limit", "concurrent session limit")
+	}
+}
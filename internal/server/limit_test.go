@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSessionLimit(t *testing.T) {
+	defer func() { limitRules = nil }()
+	limitRules = compileLimits([]LimitRule{
+		{Username: `^v-`, Max: 1, Policy: LimitKickOldest},
+		{Max: 2},
+	})
+
+	ips := []net.IP{net.IPv4(10, 2, 0, 1), net.IPv4(10, 2, 0, 2), net.IPv4(10, 2, 0, 3)}
+	macs := []string{"aa:bb:cc:00:00:01", "aa:bb:cc:00:00:02"}
+	now := time.Now()
+	for i, ip := range ips[:2] {
+		Sessions.Add(&Session{Username: "alice", UserIP: ip, UserMAC: macs[i], LoginAt: now.Add(time.Duration(i) * time.Minute)})
+		defer Sessions.Remove(ip)
+	}
+	if err := checkSessionLimit("alice", ips[2], ""); err != ErrSessionLimit {
+		t.Errorf("third device: %v", err)
+	}
+	if err := checkSessionLimit("alice", ips[0], ""); err != nil {
+		t.Errorf("re-login from same IP: %v", err)
+	}
+	if err := checkSessionLimit("alice", ips[2], "AA-BB-CC-00-00-01"); err != nil {
+		t.Errorf("same MAC with new IP: %v", err)
+	}
+	if err := checkSessionLimit("bob", ips[2], ""); err != nil {
+		t.Errorf("other user: %v", err)
+	}
+
+	// 凭证账号只允许一台设备，新设备登录后旧设备被下线
+	nas := startTestNas(t, 2)
+	defer nas.Close()
+	old, cur := net.IPv4(10, 2, 1, 1), net.IPv4(10, 2, 1, 2)
+	for _, ip := range []net.IP{old, cur} {
+		if err := Auth(context.Background(), ip, nas.Addr().IP, []byte("user"), []byte("pwd")); err != nil {
+			t.Fatal(err)
+		}
+		Sessions.Add(&Session{Username: "v-user", UserIP: ip, NasIP: nas.Addr().IP})
+		defer Sessions.Remove(ip)
+	}
+	if err := checkSessionLimit("v-user", cur, ""); err != nil {
+		t.Errorf("kick_oldest should not reject: %v", err)
+	}
+	enforceSessionLimit(context.Background(), "v-user", cur, "")
+	if _, ok := Sessions.Get(old); ok || nas.Online(old) {
+		t.Error("oldest device still online")
+	}
+	if _, ok := Sessions.Get(cur); !ok || !nas.Online(cur) {
+		t.Error("new device was logged out")
+	}
+}
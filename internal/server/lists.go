@@ -0,0 +1,355 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"syler/internal/logger"
+	"syler/internal/portal"
+	"syler/internal/store"
+)
+
+// 名单类型
+const (
+	ListAllow = "allow" // 白名单：不受在线设备数和访问策略限制，MAC可免认证上网
+	ListDeny  = "deny"  // 黑名单：禁止获取验证码和登录
+)
+
+// 名单条目匹配的对象
+const (
+	KindPhone    = "phone"
+	KindMAC      = "mac"
+	KindUsername = "username"
+)
+
+// ListKey 名单在状态存储中的键，所有条目以JSON数组保存
+const ListKey = "acl"
+
+// listCacheTTL 各节点缓存名单的时长，其他节点的修改在此时间内生效
+var listCacheTTL = 10 * time.Second
+
+var errInvalidEntry = errors.New("invalid list entry")
+
+// ListEntry 黑白名单条目。号码和用户名支持 * 和 ? 通配符，如 1380013*；
+// MAC为完整地址、6位OUI（如 aa:bb:cc）或带通配符
+type ListEntry struct {
+	List     string    `json:"list"`
+	Kind     string    `json:"kind"`
+	Pattern  string    `json:"pattern"`
+	Username string    `json:"username,omitempty"` // 白名单MAC免认证时使用的用户名
+	Reason   string    `json:"reason,omitempty"`
+	Operator string    `json:"operator,omitempty"`
+	Created  time.Time `json:"created_at"`
+	Expire   time.Time `json:"expire_at"` // 零值表示永久有效
+}
+
+func (e *ListEntry) expired(now time.Time) bool {
+	return !e.Expire.IsZero() && now.After(e.Expire)
+}
+
+// normalize 校验条目并统一MAC格式
+func (e *ListEntry) normalize() error {
+	if e.List != ListAllow && e.List != ListDeny {
+		return fmt.Errorf("%w: list must be allow or deny", errInvalidEntry)
+	}
+	e.Pattern = strings.TrimSpace(e.Pattern)
+	if e.Pattern == "" {
+		return fmt.Errorf("%w: empty pattern", errInvalidEntry)
+	}
+	switch e.Kind {
+	case KindPhone, KindUsername:
+	case KindMAC:
+		e.Pattern = normalizeMAC(e.Pattern)
+		for _, c := range e.Pattern {
+			if !strings.ContainsRune("0123456789abcdef*?", c) {
+				return fmt.Errorf("%w: invalid MAC pattern", errInvalidEntry)
+			}
+		}
+	default:
+		return fmt.Errorf("%w: kind must be phone, mac or username", errInvalidEntry)
+	}
+	if _, err := path.Match(e.Pattern, ""); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidEntry, err)
+	}
+	return nil
+}
+
+func (e *ListEntry) match(kind, value string) bool {
+	if e.Kind != kind || value == "" {
+		return false
+	}
+	if kind == KindMAC {
+		value = normalizeMAC(value)
+		if len(e.Pattern) == 6 && !strings.ContainsAny(e.Pattern, "*?") {
+			return strings.HasPrefix(value, e.Pattern)
+		}
+	}
+	ok, _ := path.Match(e.Pattern, value)
+	return ok
+}
+
+// AccessLists 黑白名单，保存在状态存储中由各节点共享。
+// 查询使用缓存的快照，缓存过期时由一个请求在锁外重新读取，其他请求继续使用旧快照
+type AccessLists struct {
+	lock       sync.Mutex
+	entries    []ListEntry // 只整体替换，不原地修改
+	loaded     time.Time   // 上次读取的时间，读取失败时同样更新，避免存储故障期间每次查询都重试
+	refreshing bool
+
+	write sync.Mutex // 串行化Add和Remove的读-改-写
+}
+
+var Lists = new(AccessLists)
+
+func (l *AccessLists) state() store.Store {
+	return AuthHandler.state
+}
+
+// primary 管理接口读写名单使用的存储。Redis不可用时名单不能降级写入本地内存，
+// 否则修改只在本节点生效，Redis恢复后还会被旧名单覆盖
+func (l *AccessLists) primary() store.Store {
+	if f, ok := l.state().(*store.Fallback); ok {
+		return f.Primary()
+	}
+	return l.state()
+}
+
+// fetch 从存储读取名单，不持有锁
+func (l *AccessLists) fetch(ctx context.Context, st store.Store) ([]ListEntry, error) {
+	if st == nil {
+		l.lock.Lock()
+		defer l.lock.Unlock()
+		return l.entries, nil
+	}
+	data, err := st.Get(ctx, ListKey)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []ListEntry
+	if err := json.Unmarshal([]byte(data), &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (l *AccessLists) set(entries []ListEntry) {
+	l.lock.Lock()
+	l.entries, l.loaded = entries, time.Now()
+	l.lock.Unlock()
+}
+
+// snapshot 返回缓存的名单，缓存过期时由当前请求刷新
+func (l *AccessLists) snapshot() []ListEntry {
+	l.lock.Lock()
+	entries := l.entries
+	stale := !l.refreshing && time.Since(l.loaded) >= listCacheTTL
+	if stale {
+		l.refreshing = true
+	}
+	l.lock.Unlock()
+	if !stale {
+		return entries
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	fresh, err := l.fetch(ctx, l.state())
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.refreshing, l.loaded = false, time.Now()
+	if err != nil {
+		logger.Subsystem(logger.HTTP).WithField("error", err).Warn("Failed to load access lists, using cached entries")
+		return entries
+	}
+	l.entries = fresh
+	return fresh
+}
+
+func (l *AccessLists) save(ctx context.Context, entries []ListEntry) error {
+	now := time.Now()
+	kept := make([]ListEntry, 0, len(entries))
+	for _, e := range entries {
+		if !e.expired(now) {
+			kept = append(kept, e)
+		}
+	}
+	if st := l.primary(); st != nil {
+		data, err := json.Marshal(kept)
+		if err != nil {
+			return err
+		}
+		if err := st.Set(ctx, ListKey, string(data), 0); err != nil {
+			return err
+		}
+	}
+	l.set(kept)
+	return nil
+}
+
+// Lookup 返回第一条匹配且未过期的条目
+func (l *AccessLists) Lookup(list, kind, value string) (ListEntry, bool) {
+	now := time.Now()
+	for _, e := range l.snapshot() {
+		if e.List == list && !e.expired(now) && e.match(kind, value) {
+			return e, true
+		}
+	}
+	return ListEntry{}, false
+}
+
+// All 返回所有未过期的条目
+func (l *AccessLists) All(ctx context.Context) ([]ListEntry, error) {
+	entries, err := l.fetch(ctx, l.primary())
+	if err != nil {
+		return nil, err
+	}
+	l.set(entries)
+	now := time.Now()
+	list := make([]ListEntry, 0, len(entries))
+	for _, e := range entries {
+		if !e.expired(now) {
+			list = append(list, e)
+		}
+	}
+	return list, nil
+}
+
+// Add 添加条目，同一名单中相同对象的条目被替换
+func (l *AccessLists) Add(ctx context.Context, e ListEntry) (ListEntry, error) {
+	if err := e.normalize(); err != nil {
+		return e, err
+	}
+	if e.Created.IsZero() {
+		e.Created = time.Now()
+	}
+	l.write.Lock()
+	defer l.write.Unlock()
+	entries, err := l.fetch(ctx, l.primary())
+	if err != nil {
+		return e, err
+	}
+	entries, _ = without(entries, e.List, e.Kind, e.Pattern)
+	return e, l.save(ctx, append(entries, e))
+}
+
+// Remove 删除条目，条目不存在时返回false
+func (l *AccessLists) Remove(ctx context.Context, list, kind, pattern string) (bool, error) {
+	e := ListEntry{List: list, Kind: kind, Pattern: pattern}
+	if err := e.normalize(); err != nil {
+		return false, err
+	}
+	l.write.Lock()
+	defer l.write.Unlock()
+	entries, err := l.fetch(ctx, l.primary())
+	if err != nil {
+		return false, err
+	}
+	entries, ok := without(entries, e.List, e.Kind, e.Pattern)
+	if !ok {
+		return false, nil
+	}
+	return true, l.save(ctx, entries)
+}
+
+// without 返回去掉指定条目后的新切片
+func without(entries []ListEntry, list, kind, pattern string) ([]ListEntry, bool) {
+	kept := make([]ListEntry, 0, len(entries))
+	found := false
+	for _, e := range entries {
+		if e.List == list && e.Kind == kind && e.Pattern == pattern {
+			found = true
+			continue
+		}
+		kept = append(kept, e)
+	}
+	return kept, found
+}
+
+// denied 检查用户名、手机号和MAC是否在黑名单中
+func denied(username, usermac string) (ListEntry, bool) {
+	if e, ok := Lists.Lookup(ListDeny, KindUsername, username); ok {
+		return e, true
+	}
+	if validatePhone(username) {
+		if e, ok := Lists.Lookup(ListDeny, KindPhone, username); ok {
+			return e, true
+		}
+	}
+	return Lists.Lookup(ListDeny, KindMAC, usermac)
+}
+
+// allowed 白名单中的用户名、手机号或MAC不受在线设备数和访问策略限制
+func allowed(username, usermac string) bool {
+	if _, ok := Lists.Lookup(ListAllow, KindUsername, username); ok {
+		return true
+	}
+	if validatePhone(username) {
+		if _, ok := Lists.Lookup(ListAllow, KindPhone, username); ok {
+			return true
+		}
+	}
+	_, ok := Lists.Lookup(ListAllow, KindMAC, usermac)
+	return ok
+}
+
+// trustedMAC 返回可用于白名单判断的MAC：hmac方式下MAC已随重定向参数签名，
+// 否则只信任NAS在NTF_USERDISCOVERY中通告的MAC。页面提交的MAC不可信时返回空
+func trustedMAC(nas portal.Nas, userip net.IP, usermac string) string {
+	if nas.RedirectAuth == RedirectHMAC {
+		return usermac
+	}
+	mac, ok := Discovered.Lookup(userip)
+	if !ok || (usermac != "" && normalizeMAC(mac.String()) != normalizeMAC(usermac)) {
+		return ""
+	}
+	return mac.String()
+}
+
+// enforceDeny 黑名单条目生效后下线匹配的在线会话，并删除这些设备的MAC绑定，返回下线的会话数
+func enforceDeny(ctx context.Context, e ListEntry) int {
+	if e.List != ListDeny {
+		return 0
+	}
+	st := AuthHandler.state
+	kicked := 0
+	for _, s := range Sessions.List() {
+		hit := e.match(KindUsername, s.Username) ||
+			(validatePhone(s.Username) && e.match(KindPhone, s.Username)) ||
+			e.match(KindMAC, s.UserMAC)
+		if !hit {
+			continue
+		}
+		if s.UserMAC != "" && st != nil {
+			st.Del(ctx, MacSessionPfrefix+normalizeMAC(s.UserMAC))
+		}
+		if kick(ctx, s, e.Operator, "denied: "+e.Reason) {
+			kicked++
+		}
+	}
+	// 离线设备的绑定在MAC信息查询时按黑名单拒绝，完整MAC可直接删除
+	if e.Kind == KindMAC && len(e.Pattern) == 12 && !strings.ContainsAny(e.Pattern, "*?") && st != nil {
+		st.Del(ctx, MacSessionPfrefix+e.Pattern)
+	}
+	return kicked
+}
+
+// logDenied 记录被黑名单拒绝的请求
+func logDenied(log *logrus.Entry, e ListEntry) {
+	log.WithFields(logrus.Fields{
+		"list_kind":    e.Kind,
+		"list_pattern": e.Pattern,
+		"list_reason":  e.Reason,
+	}).Warn("Rejected by deny list")
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"syler/internal/portal"
+	"syler/internal/store"
+)
+
+func TestAccessLists(t *testing.T) {
+	defer func(s store.Store, l *AccessLists) { AuthHandler.state = s; Lists = l }(AuthHandler.state, Lists)
+	AuthHandler.state = store.NewMemory()
+	Lists = new(AccessLists)
+	ctx := context.Background()
+
+	entries := []ListEntry{
+		{List: ListDeny, Kind: KindPhone, Pattern: "1380013*", Reason: "spam"},
+		{List: ListDeny, Kind: KindMAC, Pattern: "AA-BB-CC"},
+		{List: ListAllow, Kind: KindMAC, Pattern: "11:22:33:44:55:66", Username: "printer"},
+		{List: ListAllow, Kind: KindUsername, Pattern: "staff-*"},
+		{List: ListDeny, Kind: KindUsername, Pattern: "old", Expire: time.Now().Add(-time.Minute)},
+	}
+	for _, e := range entries {
+		if _, err := Lists.Add(ctx, e); err != nil {
+			t.Fatalf("add %v: %v", e, err)
+		}
+	}
+	if _, err := Lists.Add(ctx, ListEntry{List: ListDeny, Kind: KindMAC, Pattern: "zz:zz"}); err == nil {
+		t.Error("invalid MAC pattern accepted")
+	}
+
+	if e, ok := denied("13800138000", ""); !ok || e.Reason != "spam" {
+		t.Errorf("phone range not denied: %v %v", e, ok)
+	}
+	if _, ok := denied("13900138000", ""); ok {
+		t.Error("phone outside range denied")
+	}
+	if _, ok := denied("alice", "aa:bb:cc:01:02:03"); !ok {
+		t.Error("OUI not denied")
+	}
+	if _, ok := denied("old", ""); ok {
+		t.Error("expired entry still denied")
+	}
+	if !allowed("staff-li", "") || allowed("li", "") {
+		t.Error("username allow list mismatch")
+	}
+	if got := AuthHandler.macBinding("11-22-33-44-55-66"); got != "printer" {
+		t.Errorf("allow-listed MAC binding = %q", got)
+	}
+	if got := AuthHandler.macBinding("aa:bb:cc:00:00:01"); got != "" {
+		t.Errorf("denied MAC bound to %q", got)
+	}
+
+	// 其他节点通过存储看到的名单
+	Lists = new(AccessLists)
+	all, err := Lists.All(ctx)
+	if err != nil || len(all) != 4 {
+		t.Fatalf("reload: %d %v", len(all), err)
+	}
+	if ok, err := Lists.Remove(ctx, ListDeny, KindMAC, "aabbcc"); !ok || err != nil {
+		t.Errorf("remove: %v %v", ok, err)
+	}
+	if _, ok := denied("alice", "aa:bb:cc:01:02:03"); ok {
+		t.Error("removed entry still denied")
+	}
+}
+
+func TestHandleLists(t *testing.T) {
+	defer func(s store.Store, l *AccessLists) { AuthHandler.state = s; Lists = l }(AuthHandler.state, Lists)
+	AuthHandler.state = store.NewMemory()
+	Lists = new(AccessLists)
+	viper.Set("admin.token", "admin-secret")
+	defer viper.Set("admin.token", "")
+
+	do := func(method, target, body, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		r.Header.Set("X-Operator", "ops")
+		w := httptest.NewRecorder()
+		adminAuth(AuthHandler.HandleLists)(w, r)
+		return w
+	}
+
+	if w := do(http.MethodGet, "/api/admin/lists", "", "wrong"); w.Code != http.StatusUnauthorized {
+		t.Errorf("wrong token: %d", w.Code)
+	}
+	w := do(http.MethodPost, "/api/admin/lists", `{"list":"deny","kind":"phone","pattern":"170*","reason":"fraud","ttl":"24h"}`, "admin-secret")
+	if w.Code != http.StatusOK {
+		t.Fatalf("add: %d %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodPost, "/api/admin/lists", `{"list":"grey","kind":"phone","pattern":"1"}`, "admin-secret"); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid list: %d", w.Code)
+	}
+
+	w = do(http.MethodGet, "/api/admin/lists", "", "admin-secret")
+	var resp struct {
+		Data []ListEntry `json:"data"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || len(resp.Data) != 1 {
+		t.Fatalf("list: %v %v", resp.Data, err)
+	}
+	if e := resp.Data[0]; e.Operator != "ops" || e.Reason != "fraud" || e.Expire.IsZero() {
+		t.Errorf("entry: %+v", e)
+	}
+
+	if w := do(http.MethodDelete, "/api/admin/lists?list=deny&kind=phone&pattern=170*", "", "admin-secret"); w.Code != http.StatusOK {
+		t.Errorf("delete: %d %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodDelete, "/api/admin/lists?list=deny&kind=phone&pattern=170*", "", "admin-secret"); w.Code != http.StatusNotFound {
+		t.Errorf("delete missing: %d", w.Code)
+	}
+}
+
+func TestTrustedMAC(t *testing.T) {
+	userip := net.ParseIP("10.0.7.1")
+	staff := "11:22:33:44:55:66"
+	if got := trustedMAC(portal.Nas{}, userip, staff); got != "" {
+		t.Errorf("unverified form MAC trusted: %q", got)
+	}
+	if got := trustedMAC(portal.Nas{RedirectAuth: RedirectHMAC}, userip, staff); got != staff {
+		t.Errorf("signed MAC not trusted: %q", got)
+	}
+	Discovered.Add(userip, net.HardwareAddr{0x11, 0x22, 0x33, 0x44, 0x55, 0x66})
+	if got := trustedMAC(portal.Nas{}, userip, "11-22-33-44-55-66"); got != staff {
+		t.Errorf("discovered MAC not trusted: %q", got)
+	}
+	if got := trustedMAC(portal.Nas{}, userip, "aa:bb:cc:dd:ee:ff"); got != "" {
+		t.Errorf("form MAC differing from discovery trusted: %q", got)
+	}
+}
+
+func TestEnforceDeny(t *testing.T) {
+	defer func(s store.Store, l *AccessLists) { AuthHandler.state = s; Lists = l }(AuthHandler.state, Lists)
+	AuthHandler.state = store.NewMemory()
+	Lists = new(AccessLists)
+	ctx := context.Background()
+	nas := startTestNas(t, 2)
+	defer nas.Close()
+
+	userip, mac := net.ParseIP("10.0.7.2"), "00:0c:29:07:00:02"
+	Sessions.Add(&Session{Username: "13800000001", UserIP: userip, UserMAC: mac, NasIP: nas.Addr().IP})
+	defer Sessions.Remove(userip)
+	AuthHandler.state.Set(ctx, MacSessionPfrefix+normalizeMAC(mac), "13800000001", time.Hour)
+	AuthHandler.state.Set(ctx, MacSessionPfrefix+"000c29070003", "13800000001", time.Hour)
+
+	e, err := Lists.Add(ctx, ListEntry{List: ListDeny, Kind: KindPhone, Pattern: "138000000*", Operator: "ops"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := enforceDeny(ctx, e); n != 1 {
+		t.Errorf("kicked %d sessions", n)
+	}
+	if _, ok := Sessions.Get(userip); ok {
+		t.Error("banned session still online")
+	}
+	if _, err := AuthHandler.state.Get(ctx, MacSessionPfrefix+normalizeMAC(mac)); err != store.ErrNotFound {
+		t.Errorf("binding of kicked device kept: %v", err)
+	}
+	// 离线设备的绑定仍在，但查询时不再视为已绑定
+	if got := AuthHandler.macBinding("00:0c:29:07:00:03"); got != "" {
+		t.Errorf("banned user still bound: %q", got)
+	}
+}
+
+// flaky 读取总是失败的存储
+type flaky struct {
+	*store.Memory
+	gets int
+}
+
+func (f *flaky) Get(ctx context.Context, key string) (string, error) {
+	f.gets++
+	return "", errors.New("connection refused")
+}
+
+func TestListsBackoff(t *testing.T) {
+	defer func(s store.Store, l *AccessLists) { AuthHandler.state = s; Lists = l }(AuthHandler.state, Lists)
+	st := &flaky{Memory: store.NewMemory()}
+	AuthHandler.state = st
+	Lists = new(AccessLists)
+	for i := 0; i < 10; i++ {
+		denied("alice", "aa:bb:cc:dd:ee:ff")
+	}
+	if st.gets != 1 {
+		t.Errorf("store read %d times during outage, want 1", st.gets)
+	}
+}
+
+// Redis不可用时名单修改返回503，不写入本节点的内存
+func TestHandleListsStoreDown(t *testing.T) {
+	defer func(s store.Store, l *AccessLists) { AuthHandler.state = s; Lists = l }(AuthHandler.state, Lists)
+	local := store.NewMemory()
+	AuthHandler.state = store.NewFallback(&down{Memory: store.NewMemory()}, local)
+	Lists = new(AccessLists)
+	viper.Set("admin.token", "admin-secret")
+	defer viper.Set("admin.token", "")
+
+	r := httptest.NewRequest(http.MethodPost, "/api/admin/lists", strings.NewReader(`{"list":"deny","kind":"phone","pattern":"170*"}`))
+	r.Header.Set("Authorization", "Bearer admin-secret")
+	w := httptest.NewRecorder()
+	adminAuth(AuthHandler.HandleLists)(w, r)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status %d, want 503", w.Code)
+	}
+	if _, err := local.Get(context.Background(), ListKey); err != store.ErrNotFound {
+		t.Errorf("list written to local fallback: %v", err)
+	}
+	if _, ok := Lists.Lookup(ListDeny, KindPhone, "17000000000"); ok {
+		t.Error("failed change applied to the local cache")
+	}
+}
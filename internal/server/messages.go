@@ -0,0 +1,152 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	"syler/internal/logger"
+	"syler/internal/portal"
+)
+
+// 认证步骤
+const (
+	StepChallenge = "challenge"
+	StepAuth      = "auth"
+)
+
+// AuthError 认证失败的结构化原因，包含失败的步骤、NAS返回的错误码和TextInfo
+type AuthError struct {
+	Step     string
+	Code     byte   // NAS应答的错误码，超时等本地错误为0
+	TextInfo string // NAS应答的TextInfo，一般为RADIUS的Reply-Message
+	Err      error
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Step, e.Err)
+}
+
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+func newAuthError(step string, err error) *AuthError {
+	e := &AuthError{Step: step, Err: err}
+	var perr *portal.Error
+	if errors.As(err, &perr) {
+		e.Code = perr.Code
+		e.TextInfo = perr.TextInfo
+	}
+	return e
+}
+
+// MessageRule 将认证错误映射为给用户看的提示，各条件为空时不限制
+type MessageRule struct {
+	Step    string `mapstructure:"step"`     // challenge 或 auth
+	ErrCode int    `mapstructure:"err_code"` // 0表示任意错误码
+	Text    string `mapstructure:"text"`     // 匹配TextInfo的正则表达式
+	Code    string `mapstructure:"code"`     // 返回给前端的错误码
+	Message string `mapstructure:"message"`
+
+	pattern *regexp.Regexp
+}
+
+// 返回给前端的错误码
+const (
+	CodeAuthRejected  = "auth_rejected"
+	CodeAlreadyOnline = "already_online"
+	CodeBusy          = "nas_busy"
+	CodeTimeout       = "nas_timeout"
+	CodeQuota         = "quota_exceeded"
+	CodeWrongCode     = "invalid_sms_code"
+	CodeAccountLocked = "account_locked"
+	CodeSessionLimit  = "session_limit"
+	CodeOutsideHours  = "outside_hours"
+	CodeDailyCap      = "daily_cap_reached"
+	CodeDenied        = "denied"
+	CodeTooMany       = "too_many_attempts"
+	CodeSystemError   = "system_error"
+)
+
+// defaultMessageRules 常见的RADIUS Reply-Message，配置中的规则优先
+var defaultMessageRules = []MessageRule{
+	{Text: `(?i)欠费|余额不足|arrear|balance|quota|流量已用`, Code: CodeQuota, Message: "账户已欠费或流量已用尽，请充值后再试"},
+	{Text: `(?i)验证码|sms.?code|verification`, Code: CodeWrongCode, Message: "验证码错误或已过期"},
+	{Text: `(?i)锁定|冻结|disabled|locked|expired`, Code: CodeAccountLocked, Message: "账户已停用，请联系管理员"},
+	{ErrCode: 2, Code: CodeAlreadyOnline, Message: "该设备已在线，无需重复登录"},
+	{Step: StepChallenge, ErrCode: 3, Code: CodeBusy, Message: "正在认证中，请稍后再试"},
+	{Step: StepAuth, ErrCode: 3, Code: CodeBusy, Message: "正在认证中，请稍后再试"},
+}
+
+var messageRules = compileRules(defaultMessageRules)
+
+// LoadMessageRules 读取 auth_messages 配置，配置的规则排在内置规则之前
+func LoadMessageRules() {
+	log := logger.Subsystem(logger.HTTP)
+
+	var rules []MessageRule
+	if err := viper.UnmarshalKey("auth_messages", &rules); err != nil {
+		log.WithField("error", err).Warn("Invalid auth_messages, using defaults")
+	}
+	messageRules = compileRules(append(rules, defaultMessageRules...))
+}
+
+func compileRules(rules []MessageRule) []MessageRule {
+	compiled := make([]MessageRule, 0, len(rules))
+	for _, r := range rules {
+		if r.Text != "" {
+			p, err := regexp.Compile(r.Text)
+			if err != nil {
+				logger.Subsystem(logger.HTTP).WithFields(logrus.Fields{
+					"text":  r.Text,
+					"error": err,
+				}).Warn("Ignore auth message rule with invalid pattern")
+				continue
+			}
+			r.pattern = p
+		}
+		compiled = append(compiled, r)
+	}
+	return compiled
+}
+
+func (r *MessageRule) match(e *AuthError) bool {
+	if r.Step != "" && r.Step != e.Step {
+		return false
+	}
+	if r.ErrCode != 0 && r.ErrCode != int(e.Code) {
+		return false
+	}
+	if r.pattern != nil && !r.pattern.MatchString(e.TextInfo) {
+		return false
+	}
+	return true
+}
+
+// userMessage 返回认证失败时给前端的错误码和提示
+func userMessage(err error) (code string, message string) {
+	var aerr *AuthError
+	if !errors.As(err, &aerr) {
+		aerr = newAuthError(StepAuth, err)
+	}
+	for i := range messageRules {
+		if messageRules[i].match(aerr) {
+			return messageRules[i].Code, messageRules[i].Message
+		}
+	}
+	if errors.Is(err, portal.ErrTimeout) {
+		return CodeTimeout, "认证服务器无响应，请稍后重试"
+	}
+	var perr *portal.Error
+	if !errors.As(err, &perr) {
+		return CodeSystemError, "系统错误，请稍后重试"
+	}
+	if perr.Retryable {
+		return CodeBusy, "认证服务器繁忙，请稍后重试"
+	}
+	return CodeAuthRejected, "用户名或密码错误"
+}
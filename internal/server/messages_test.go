@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"syler/internal/portal"
+	"syler/internal/simulator"
+)
+
+func TestAuthErrorMessage(t *testing.T) {
+	nas := startTestNas(t, 2)
+	defer nas.Close()
+	basip := nas.Addr().IP
+
+	cases := []struct {
+		typ   byte
+		fault *simulator.Fault
+		pwd   string
+		step  string
+		code  string
+	}{
+		{portal.REQ_AUTH, nil, "wrong", StepAuth, CodeAuthRejected},
+		{portal.REQ_AUTH, &simulator.Fault{ErrCode: 1, TextInfo: "账户已欠费"}, "pwd", StepAuth, CodeQuota},
+		{portal.REQ_AUTH, &simulator.Fault{ErrCode: 1, TextInfo: "Account locked"}, "pwd", StepAuth, CodeAccountLocked},
+		{portal.REQ_CHALLENGE, &simulator.Fault{ErrCode: 3}, "pwd", StepChallenge, CodeBusy},
+		{portal.REQ_CHALLENGE, &simulator.Fault{Drop: -1}, "pwd", StepChallenge, CodeTimeout},
+	}
+	for i, c := range cases {
+		nas.Inject(c.typ, c.fault)
+		userip := net.IPv4(10, 0, 2, byte(i+1))
+		err := Auth(context.Background(), userip, basip, []byte("user"), []byte(c.pwd))
+		nas.Inject(c.typ, nil)
+
+		var aerr *AuthError
+		if !errors.As(err, &aerr) {
+			t.Fatalf("case %d: want AuthError, got %v", i, err)
+		}
+		if aerr.Step != c.step {
+			t.Errorf("case %d: step %s, want %s", i, aerr.Step, c.step)
+		}
+		if c.fault != nil && aerr.TextInfo != c.fault.TextInfo {
+			t.Errorf("case %d: TextInfo %q, want %q", i, aerr.TextInfo, c.fault.TextInfo)
+		}
+		if code, _ := userMessage(err); code != c.code {
+			t.Errorf("case %d: code %s, want %s", i, code, c.code)
+		}
+	}
+}
+
+func TestMessageRuleOverride(t *testing.T) {
+	defer func() { messageRules = compileRules(defaultMessageRules) }()
+	messageRules = compileRules(append([]MessageRule{
+		{Step: StepAuth, ErrCode: 1, Text: "欠费", Code: "pay", Message: "请缴费"},
+		{Text: "(", Code: "broken"},
+	}, defaultMessageRules...))
+
+	err := newAuthError(StepAuth, &portal.Error{Type: portal.ACK_AUTH, Code: 1, TextInfo: "账户已欠费"})
+	if code, msg := userMessage(err); code != "pay" || msg != "请缴费" {
+		t.Errorf("got %s %s", code, msg)
+	}
+	err = newAuthError(StepChallenge, &portal.Error{Type: portal.ACK_CHALLENGE, Code: 1, TextInfo: "账户已欠费"})
+	if code, _ := userMessage(err); code != CodeQuota {
+		t.Errorf("challenge rejection: got %s", code)
+	}
+}
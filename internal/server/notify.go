@@ -0,0 +1,245 @@
+package server
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"syler/internal/audit"
+	"syler/internal/logger"
+	"syler/internal/portal"
+	"syler/internal/store"
+)
+
+// nasHandlers NAS主动发送的报文按类型分发，未列出的类型只记录日志
+var nasHandlers = map[byte]func(portal.Message, net.IP){
+	portal.NTF_LOGOUT:         NotifyLogout,
+	portal.NTF_USER_HEARTBEAT: UserHeartbeat,
+	portal.NTF_HEARTBEAT:      nasHeartbeat,
+	portal.NTF_USERDISCOVERY:  UserDiscovery,
+	portal.NTF_USERIPCHANGE:   UserIpChange,
+	portal.REQ_MACINFO:        MacInfo,
+}
+
+// handleNasMessage 处理NAS主动发送的报文
+func handleNasMessage(msg portal.Message, src net.IP) {
+	log := logger.Subsystem(logger.Portal).WithFields(logrus.Fields{
+		"message_type": portal.NasFor(src).Vendor.TypeName(msg.Type()),
+		"source_ip":    src.String(),
+	})
+	// 未经校验的报文可查询MAC绑定的用户、迁移会话，必须来自登记的NAS且密钥正确
+	if !portal.Registered(src) {
+		log.Warn("Drop portal message from unregistered NAS")
+		return
+	}
+	if !msg.Verify(portal.NasFor(src).Secret) {
+		log.Warn("Drop portal message with bad authenticator")
+		return
+	}
+	handler, ok := nasHandlers[msg.Type()]
+	if !ok {
+		log.Warn("Ignore unsupported portal message from NAS")
+		return
+	}
+	log.Debug("Received portal message from NAS")
+	handler(msg, src)
+}
+
+// nasHeartbeat NAS心跳无需应答，收到报文时已记录NAS可达
+func nasHeartbeat(msg portal.Message, src net.IP) {}
+
+// DiscoveryTable 记录NAS通告的用户IP与MAC，条目在一段时间后过期
+type DiscoveryTable struct {
+	lock    sync.Mutex
+	entries map[string]discovery
+	ttl     time.Duration
+}
+
+type discovery struct {
+	mac  net.HardwareAddr
+	seen time.Time
+}
+
+var Discovered = &DiscoveryTable{entries: make(map[string]discovery), ttl: 24 * time.Hour}
+
+// Add 记录用户IP对应的MAC
+func (t *DiscoveryTable) Add(userip net.IP, mac net.HardwareAddr) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	now := time.Now()
+	for k, e := range t.entries {
+		if now.Sub(e.seen) > t.ttl {
+			delete(t.entries, k)
+		}
+	}
+	t.entries[userip.String()] = discovery{mac: mac, seen: now}
+}
+
+// Lookup 返回用户IP最近通告的MAC
+func (t *DiscoveryTable) Lookup(userip net.IP) (net.HardwareAddr, bool) {
+	if userip == nil {
+		return nil, false
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	e, ok := t.entries[userip.String()]
+	if !ok || time.Since(e.seen) > t.ttl {
+		return nil, false
+	}
+	return e.mac, true
+}
+
+// UserDiscovery 处理NTF_USERDISCOVERY，记录用户MAC供登录时绑定，无需应答
+func UserDiscovery(msg portal.Message, basip net.IP) {
+	log := logger.Subsystem(logger.Portal).WithFields(logrus.Fields{
+		"user_ip":   msg.UserIp().String(),
+		"nas_ip":    basip.String(),
+		"serial_no": msg.SerialId(),
+	})
+	mac := msg.UserMAC()
+	if mac == nil {
+		log.Warn("Received user discovery without MAC")
+		return
+	}
+	Discovered.Add(msg.UserIp(), mac)
+	Sessions.SetMAC(msg.UserIp(), mac.String())
+	log.WithField("user_mac", mac.String()).Debug("Discovered user")
+}
+
+// UserIpChange 处理NTF_USERIPCHANGE，按MAC找到会话并改用新IP
+func UserIpChange(msg portal.Message, basip net.IP) {
+	userip := msg.UserIp()
+	log := logger.Subsystem(logger.Portal).WithFields(logrus.Fields{
+		"user_ip":   userip.String(),
+		"nas_ip":    basip.String(),
+		"serial_no": msg.SerialId(),
+	})
+	if mac := msg.UserMAC(); mac != nil {
+		Discovered.Add(userip, mac)
+		if s, ok := Sessions.ByMAC(mac.String()); ok && !s.UserIP.Equal(userip) {
+			Sessions.Rekey(s.UserIP, userip)
+			log.WithFields(logrus.Fields{
+				"user_mac": mac.String(),
+				"old_ip":   s.UserIP.String(),
+				"username": s.Username,
+			}).Info("User IP changed")
+		}
+	} else {
+		log.Warn("Received user IP change without MAC")
+	}
+
+	nas := portal.NasFor(basip)
+	if _, err := portal.AffAckUserIpChange(context.Background(), userip, nas.Secret, basip, nas.Port, msg.SerialId(), msg.ReqId()); err != nil {
+		log.WithField("error", err).Error("Failed to acknowledge user IP change")
+	}
+}
+
+// MacInfo 处理REQ_MACINFO，返回MAC绑定的用户名，未绑定时ErrCode为1
+func MacInfo(msg portal.Message, basip net.IP) {
+	log := logger.Subsystem(logger.Portal).WithFields(logrus.Fields{
+		"user_ip":   msg.UserIp().String(),
+		"nas_ip":    basip.String(),
+		"serial_no": msg.SerialId(),
+	})
+	mac := msg.UserMAC()
+	var username string
+	if mac != nil {
+		username = AuthHandler.macBinding(mac.String())
+	}
+	if username != "" && !admitMAC(username, msg.UserIp(), basip, mac.String()) {
+		username = ""
+	}
+	log.WithFields(logrus.Fields{
+		"user_mac": mac.String(),
+		"bound":    username != "",
+	}).Debug("Received MAC info query")
+
+	nas := portal.NasFor(basip)
+	if _, err := portal.AckMacInfo(context.Background(), msg.UserIp(), nas.Secret, basip, nas.Port, msg.SerialId(), msg.ReqId(), mac, username); err != nil {
+		log.WithField("error", err).Error("Failed to answer MAC info query")
+	}
+}
+
+// admitMAC MAC免认证上网与页面登录一样受在线设备数和访问策略限制，
+// 通过时登记会话和下线时间，不通过时按未绑定应答，用户需经页面登录
+func admitMAC(username string, userip, basip net.IP, mac string) bool {
+	log := logger.Subsystem(logger.Portal).WithFields(logrus.Fields{
+		"username": username,
+		"user_ip":  userip.String(),
+		"nas_ip":   basip.String(),
+	})
+	if s, ok := Sessions.Get(userip); ok && s.Username == username {
+		return true
+	}
+	now := time.Now()
+	exempt := allowed(username, mac)
+	var deadline time.Time
+	if !exempt {
+		if err := checkSessionLimit(username, userip, mac); err != nil {
+			log.WithField("error", err).Info("MAC binding over session limit, sending user to portal")
+			return false
+		}
+		var err error
+		deadline, err = checkAccessPolicy(basip, username, AuthHandler.authMethod(username), now)
+		if err != nil {
+			log.WithField("error", err).Info("MAC binding rejected by access policy, sending user to portal")
+			return false
+		}
+	}
+
+	endSession(userip)
+	Sessions.Add(&Session{
+		Username: username,
+		UserIP:   userip,
+		UserMAC:  mac,
+		NasIP:    basip,
+		LoginAt:  now,
+		Method:   "mac",
+		Deadline: deadline,
+	})
+	if !exempt {
+		enforceSessionLimit(context.Background(), username, userip, mac)
+		shareDailyCap(basip, username, AuthHandler.authMethod(username), now)
+	}
+	audit.Log(audit.Record{
+		Event:    audit.LoginSuccess,
+		Username: username,
+		UserIP:   userip.String(),
+		UserMAC:  mac,
+		NasIP:    basip.String(),
+		Method:   "mac",
+		Success:  true,
+	})
+	return true
+}
+
+// macBinding 返回MAC绑定的用户名，未绑定或存储不可用时返回空。
+// 黑名单中的MAC不视为已绑定，白名单中带用户名的MAC视为已绑定
+func (a *Authenticator) macBinding(mac string) string {
+	if _, ok := Lists.Lookup(ListDeny, KindMAC, mac); ok {
+		return ""
+	}
+	if e, ok := Lists.Lookup(ListAllow, KindMAC, mac); ok && e.Username != "" {
+		return e.Username
+	}
+	if a.state == nil {
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	username, err := a.state.Get(ctx, MacSessionPfrefix+normalizeMAC(mac))
+	if err != nil && err != store.ErrNotFound {
+		logger.Subsystem(logger.Portal).WithFields(logrus.Fields{
+			"error": err,
+			"mac":   mac,
+		}).Error("Failed to read MAC binding")
+	}
+	// 绑定后被加入黑名单的用户需重新通过页面登录
+	if _, ok := denied(username, ""); ok {
+		return ""
+	}
+	return username
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"syler/internal/portal"
+	v2 "syler/internal/portal/v2"
+)
+
+func TestNasInitiatedMessages(t *testing.T) {
+	for _, ver := range []int{1, 2} {
+		nas := startTestNas(t, ver)
+		basip := nas.Addr().IP
+		mac := net.HardwareAddr{0x00, 0x0c, 0x29, 0x00, 0x00, byte(ver)}
+		userip := net.IPv4(10, 0, 3, byte(ver)).To4()
+		newip := net.IPv4(10, 0, 4, byte(ver)).To4()
+
+		// 先通信一次让模拟NAS知道Portal Server的地址
+		if _, err := portal.PapAuth(context.Background(), userip, "secret", basip, nas.Addr().Port, []byte("user"), []byte("pwd")); err != nil {
+			t.Fatalf("v%d: auth failed: %v", ver, err)
+		}
+		Sessions.Add(&Session{Username: "user", UserIP: userip, NasIP: basip})
+
+		if err := nas.Discover(userip, mac, nil); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(50 * time.Millisecond)
+		if got, ok := Discovered.Lookup(userip); !ok || got.String() != mac.String() {
+			t.Errorf("v%d: discovered MAC %v", ver, got)
+		}
+		if s, _ := Sessions.Get(userip); s.UserMAC != mac.String() {
+			t.Errorf("v%d: session MAC %q", ver, s.UserMAC)
+		}
+
+		if err := nas.ChangeIP(userip, newip, mac, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := nas.QueryMAC(newip, mac, nil); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(100 * time.Millisecond)
+		if _, ok := Sessions.Get(newip); !ok {
+			t.Errorf("v%d: session not moved to new IP", ver)
+		}
+		if _, ok := Sessions.Get(userip); ok {
+			t.Errorf("v%d: session still on old IP", ver)
+		}
+		Sessions.Remove(newip)
+
+		acks := map[byte]portal.Message{}
+		for _, m := range nas.Received() {
+			acks[m.Type()] = m
+		}
+		if acks[portal.AFF_NTF_USERIPCHANGE] == nil {
+			t.Errorf("v%d: NTF_USERIPCHANGE was not acknowledged", ver)
+		}
+		// 没有Redis时MAC视为未绑定
+		if m := acks[portal.ACK_MACINFO]; m == nil || m.ErrCode() != 1 || m.UserMAC().String() != mac.String() {
+			t.Errorf("v%d: bad ACK_MACINFO %v", ver, m)
+		}
+		if nas.BadAuthenticators() != 0 {
+			t.Errorf("v%d: NAS rejected %d authenticators", ver, nas.BadAuthenticators())
+		}
+		nas.Close()
+	}
+}
+
+func TestDropUnverifiedMessages(t *testing.T) {
+	defer func(h func(portal.Message, net.IP)) { nasHandlers[portal.REQ_MACINFO] = h }(nasHandlers[portal.REQ_MACINFO])
+	var handled int
+	nasHandlers[portal.REQ_MACINFO] = func(portal.Message, net.IP) { handled++ }
+
+	nasip := net.IPv4(192, 168, 50, 1).To4()
+	portal.RegisterNas(portal.Nas{IP: nasip, Secret: "secret", Version: new(v2.Version)})
+	query := func(secret string) portal.Message {
+		m := new(v2.Version).NewReqInfo(net.IPv4(10, 0, 5, 1), secret)
+		m.(*v2.T_Message).Header.Type = portal.REQ_MACINFO
+		m.Sign(secret)
+		return m
+	}
+
+	handleNasMessage(query("secret"), nasip)
+	handleNasMessage(query("wrong"), nasip)
+	handleNasMessage(query("secret"), net.IPv4(192, 168, 50, 2))
+	if handled != 1 {
+		t.Errorf("handled %d messages, want only the signed one from the registered NAS", handled)
+	}
+}
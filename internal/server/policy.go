@@ -0,0 +1,338 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	"syler/internal/logger"
+)
+
+var (
+	ErrOutsideHours = errors.New("outside allowed access hours")
+	ErrDailyCap     = errors.New("daily online time used up")
+)
+
+// UsagePrefix 用户每天累计上网时长的存储键前缀，键为 usage:<username>:<yyyymmdd>，值为秒数
+const UsagePrefix = "usage:"
+
+// AccessPolicy 按NAS、用户组和认证方式限制上网时段和时长，各条件为空时不限
+type AccessPolicy struct {
+	Name       string        `mapstructure:"name"`
+	Nas        []string      `mapstructure:"nas"`      // NAS的IP或网段，代表一个站点
+	Username   string        `mapstructure:"username"` // 用户组，匹配用户名的正则表达式
+	Method     string        `mapstructure:"method"`   // 认证方式：sms、chap
+	Weekdays   []int         `mapstructure:"weekdays"` // 允许上网的星期，0为周日，为空时每天
+	Hours      []string      `mapstructure:"hours"`    // 允许上网的时段，如 08:00-18:00，结束早于开始时跨零点
+	MaxSession time.Duration `mapstructure:"max_session"`
+	DailyCap   time.Duration `mapstructure:"daily_cap"` // 每天累计上网时长上限
+
+	pattern *regexp.Regexp
+	nets    []*net.IPNet
+	windows []window
+}
+
+// window 一天中的时段，为距零点的时长
+type window struct {
+	start, end time.Duration
+}
+
+var accessPolicies []AccessPolicy
+
+// LoadAccessPolicies 读取 access_policies 配置，登录时使用第一条匹配的策略，都不匹配时不限制
+func LoadAccessPolicies() {
+	var policies []AccessPolicy
+	if err := viper.UnmarshalKey("access_policies", &policies); err != nil {
+		logger.Subsystem(logger.HTTP).WithField("error", err).Warn("Invalid access_policies, ignoring")
+		policies = nil
+	}
+	accessPolicies = compilePolicies(policies)
+}
+
+func compilePolicies(policies []AccessPolicy) []AccessPolicy {
+	compiled := make([]AccessPolicy, 0, len(policies))
+	for _, p := range policies {
+		if err := p.compile(); err != nil {
+			logger.Subsystem(logger.HTTP).WithFields(logrus.Fields{
+				"policy": p.Name,
+				"error":  err,
+			}).Warn("Ignore invalid access policy")
+			continue
+		}
+		compiled = append(compiled, p)
+	}
+	return compiled
+}
+
+func (p *AccessPolicy) compile() (err error) {
+	if p.Username != "" {
+		if p.pattern, err = regexp.Compile(p.Username); err != nil {
+			return err
+		}
+	}
+	for _, n := range p.Nas {
+		if !strings.Contains(n, "/") {
+			if ip := net.ParseIP(n); ip != nil && ip.To4() != nil {
+				n += "/32"
+			} else {
+				n += "/128"
+			}
+		}
+		_, ipnet, err := net.ParseCIDR(n)
+		if err != nil {
+			return err
+		}
+		p.nets = append(p.nets, ipnet)
+	}
+	for _, h := range p.Hours {
+		w, err := parseWindow(h)
+		if err != nil {
+			return err
+		}
+		p.windows = append(p.windows, w)
+	}
+	if len(p.windows) == 0 && len(p.Weekdays) > 0 {
+		p.windows = []window{{0, 24 * time.Hour}}
+	}
+	return nil
+}
+
+// parseWindow 解析 08:00-18:00 格式的时段
+func parseWindow(s string) (window, error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return window{}, fmt.Errorf("invalid hours %q", s)
+	}
+	start, err := parseClock(from)
+	if err != nil {
+		return window{}, err
+	}
+	end, err := parseClock(to)
+	if err != nil {
+		return window{}, err
+	}
+	return window{start, end}, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	hh, mm, ok := strings.Cut(strings.TrimSpace(s), ":")
+	h, err1 := strconv.Atoi(hh)
+	m, err2 := strconv.Atoi(mm)
+	if !ok || err1 != nil || err2 != nil || h < 0 || h > 24 || m < 0 || m > 59 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+func (p *AccessPolicy) match(nasip net.IP, username, method string) bool {
+	if p.pattern != nil && !p.pattern.MatchString(username) {
+		return false
+	}
+	if p.Method != "" && p.Method != method {
+		return false
+	}
+	if len(p.nets) == 0 {
+		return true
+	}
+	for _, n := range p.nets {
+		if n.Contains(nasip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *AccessPolicy) weekday(d time.Weekday) bool {
+	if len(p.Weekdays) == 0 {
+		return true
+	}
+	for _, w := range p.Weekdays {
+		if time.Weekday(w) == d {
+			return true
+		}
+	}
+	return false
+}
+
+// windowEnd 返回now所在时段的结束时间，首尾相接的时段合并计算。不限时段时返回零值
+func (p *AccessPolicy) windowEnd(now time.Time) (time.Time, bool) {
+	if len(p.windows) == 0 {
+		return time.Time{}, true
+	}
+	end, ok := p.containing(now)
+	if !ok {
+		return time.Time{}, false
+	}
+	// 如周一至周五全天开放时，周一的时段结束即周二的时段开始
+	for i := 0; i < 8; i++ {
+		next, ok := p.containing(end)
+		if !ok || !next.After(end) {
+			break
+		}
+		end = next
+	}
+	return end, true
+}
+
+// containing 查找包含t的时段，跨零点的时段属于开始的那一天
+func (p *AccessPolicy) containing(t time.Time) (time.Time, bool) {
+	today := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	for _, offset := range []int{0, -1} {
+		day := today.AddDate(0, 0, offset)
+		if !p.weekday(day.Weekday()) {
+			continue
+		}
+		for _, w := range p.windows {
+			start, end := day.Add(w.start), day.Add(w.end)
+			if w.end <= w.start {
+				end = end.Add(24 * time.Hour)
+			}
+			if !t.Before(start) && t.Before(end) {
+				return end, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+func policyFor(nasip net.IP, username, method string) *AccessPolicy {
+	for i := range accessPolicies {
+		if accessPolicies[i].match(nasip, username, method) {
+			return &accessPolicies[i]
+		}
+	}
+	return nil
+}
+
+// checkAccessPolicy 登录前检查访问策略，返回会话必须下线的时间，零值表示不限
+func checkAccessPolicy(nasip net.IP, username, method string, now time.Time) (time.Time, error) {
+	p := policyFor(nasip, username, method)
+	if p == nil {
+		return time.Time{}, nil
+	}
+	deadline, ok := p.windowEnd(now)
+	if !ok {
+		return time.Time{}, ErrOutsideHours
+	}
+	if p.MaxSession > 0 {
+		deadline = earlier(deadline, now.Add(p.MaxSession))
+	}
+	if p.DailyCap > 0 {
+		used := usageToday(username, now)
+		if used >= p.DailyCap {
+			return time.Time{}, ErrDailyCap
+		}
+		deadline = earlier(deadline, now.Add(p.DailyCap-used))
+	}
+	return deadline, nil
+}
+
+// shareDailyCap 同一用户的多台设备同时在线时按设备数平分当天剩余时长，
+// 新会话登记后提前所有会话的下线时间，避免每台设备各自用满上限
+func shareDailyCap(nasip net.IP, username, method string, now time.Time) {
+	p := policyFor(nasip, username, method)
+	if p == nil || p.DailyCap <= 0 {
+		return
+	}
+	sessions := Sessions.ByUsername(username)
+	if len(sessions) == 0 {
+		return
+	}
+	left := p.DailyCap - usageToday(username, now)
+	if left < 0 {
+		left = 0
+	}
+	deadline := now.Add(left / time.Duration(len(sessions)))
+	for _, s := range sessions {
+		Sessions.Shorten(s.UserIP, deadline)
+	}
+}
+
+func midnight(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+func usageKey(username string, day time.Time) string {
+	return UsagePrefix + username + ":" + day.Format("20060102")
+}
+
+// usageToday 用户当天已结束会话的累计时长，加上其他设备上正在进行的会话在当天的时长
+func usageToday(username string, now time.Time) time.Duration {
+	day := midnight(now)
+	var used time.Duration
+	if AuthHandler.state != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		if v, err := AuthHandler.state.Get(ctx, usageKey(username, day)); err == nil {
+			sec, _ := strconv.ParseInt(v, 10, 64)
+			used = time.Duration(sec) * time.Second
+		}
+	}
+	for _, s := range Sessions.ByUsername(username) {
+		from := s.LoginAt
+		if from.Before(day) {
+			from = day
+		}
+		if now.After(from) {
+			used += now.Sub(from)
+		}
+	}
+	return used
+}
+
+// recordUsage 将会话的上网时长按天累加到存储中
+func recordUsage(s *Session, end time.Time) {
+	if AuthHandler.state == nil || s.LoginAt.IsZero() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	for from := s.LoginAt; from.Before(end); {
+		day := midnight(from)
+		to := day.AddDate(0, 0, 1)
+		if end.Before(to) {
+			to = end
+		}
+		// 多个设备或节点同时结束会话时使用原子递增，避免互相覆盖
+		sec := int64(to.Sub(from).Seconds())
+		if _, err := AuthHandler.state.IncrBy(ctx, usageKey(s.Username, day), sec, 48*time.Hour); err != nil {
+			logger.Subsystem(logger.Portal).WithFields(logrus.Fields{
+				"username": s.Username,
+				"error":    err,
+			}).Warn("Failed to record online time")
+		}
+		from = to
+	}
+}
+
+// endSession 删除会话并累计用户的上网时长，返回被删除的会话
+func endSession(userip net.IP) *Session {
+	s := Sessions.Remove(userip)
+	if s != nil {
+		recordUsage(s, time.Now())
+	}
+	return s
+}
+
+// sweepExpired 下线超过访问策略截止时间的会话，如时段结束、超过单次或当天的时长上限
+func sweepExpired(now time.Time) {
+	for _, s := range Sessions.List() {
+		if s.Deadline.IsZero() || now.Before(s.Deadline) {
+			continue
+		}
+		logger.Subsystem(logger.Portal).WithFields(logrus.Fields{
+			"username": s.Username,
+			"user_ip":  s.UserIP.String(),
+			"deadline": s.Deadline.Format(time.RFC3339),
+		}).Info("Access policy deadline reached, logging user out")
+		kick(context.Background(), s, "schedule", "access policy deadline")
+	}
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"syler/internal/store"
+)
+
+func TestPolicyWindow(t *testing.T) {
+	policies := compilePolicies([]AccessPolicy{
+		{Name: "office", Weekdays: []int{1, 2, 3, 4, 5}, Hours: []string{"08:00-12:00", "13:00-18:00"}},
+		{Name: "night", Hours: []string{"22:00-06:00"}},
+		{Name: "weekdays", Weekdays: []int{1, 2, 3, 4, 5}},
+		{Name: "bad", Hours: []string{"8-18"}},
+	})
+	if len(policies) != 3 {
+		t.Fatalf("invalid policy not ignored: %d", len(policies))
+	}
+	at := func(day, hour, min int) time.Time {
+		// 2024-05-06 为周一
+		return time.Date(2024, 5, day, hour, min, 0, 0, time.Local)
+	}
+	cases := []struct {
+		policy int
+		now    time.Time
+		end    time.Time
+		ok     bool
+	}{
+		{0, at(6, 10, 0), at(6, 12, 0), true},
+		{0, at(6, 12, 30), time.Time{}, false},
+		{0, at(6, 17, 59), at(6, 18, 0), true},
+		{0, at(11, 10, 0), time.Time{}, false}, // 周六
+		{1, at(7, 2, 0), at(7, 6, 0), true},
+		{1, at(7, 23, 0), at(8, 6, 0), true},
+		{1, at(7, 12, 0), time.Time{}, false},
+		{2, at(6, 10, 0), at(11, 0, 0), true}, // 周一至周五连续开放到周六零点
+	}
+	for i, c := range cases {
+		end, ok := policies[c.policy].windowEnd(c.now)
+		if ok != c.ok || !end.Equal(c.end) {
+			t.Errorf("case %d: got %v %v, want %v %v", i, end, ok, c.end, c.ok)
+		}
+	}
+}
+
+func TestAccessPolicy(t *testing.T) {
+	defer func(s store.Store) { AuthHandler.state = s; accessPolicies = nil }(AuthHandler.state)
+	AuthHandler.state = store.NewMemory()
+	accessPolicies = compilePolicies([]AccessPolicy{
+		{Name: "guest", Nas: []string{"192.168.10.0/24"}, Method: "sms", MaxSession: 2 * time.Hour, DailyCap: 8 * time.Hour},
+	})
+	nasip := net.ParseIP("192.168.10.3")
+	now := time.Now()
+
+	if d, err := checkAccessPolicy(net.ParseIP("192.168.20.1"), "13800000000", "sms", now); err != nil || !d.IsZero() {
+		t.Errorf("other site: %v %v", d, err)
+	}
+	if d, err := checkAccessPolicy(nasip, "13800000000", "sms", now); err != nil || !d.Equal(now.Add(2*time.Hour)) {
+		t.Errorf("max session: %v %v", d, err)
+	}
+
+	// 当天已上网7小时，只剩1小时
+	start := midnight(now)
+	recordUsage(&Session{Username: "13800000000", LoginAt: start}, start.Add(7*time.Hour))
+	if d, err := checkAccessPolicy(nasip, "13800000000", "sms", start.Add(9*time.Hour)); err != nil || !d.Equal(start.Add(10*time.Hour)) {
+		t.Errorf("daily cap: %v %v", d, err)
+	}
+	recordUsage(&Session{Username: "13800000000", LoginAt: start.Add(8 * time.Hour)}, start.Add(9*time.Hour))
+	if _, err := checkAccessPolicy(nasip, "13800000000", "sms", start.Add(10*time.Hour)); err != ErrDailyCap {
+		t.Errorf("daily cap used up: %v", err)
+	}
+}
+
+func TestSweepExpired(t *testing.T) {
+	nas := startTestNas(t, 2)
+	defer nas.Close()
+	expired, valid := net.IPv4(10, 3, 0, 1), net.IPv4(10, 3, 0, 2)
+	for _, ip := range []net.IP{expired, valid} {
+		if err := Auth(context.Background(), ip, nas.Addr().IP, []byte("user"), []byte("pwd")); err != nil {
+			t.Fatal(err)
+		}
+		defer Sessions.Remove(ip)
+	}
+	now := time.Now()
+	Sessions.Add(&Session{Username: "user", UserIP: expired, NasIP: nas.Addr().IP, Deadline: now.Add(-time.Second)})
+	Sessions.Add(&Session{Username: "user", UserIP: valid, NasIP: nas.Addr().IP, Deadline: now.Add(time.Hour)})
+
+	sweepExpired(now)
+	if _, ok := Sessions.Get(expired); ok || nas.Online(expired) {
+		t.Error("expired session not logged out")
+	}
+	if _, ok := Sessions.Get(valid); !ok || !nas.Online(valid) {
+		t.Error("valid session logged out")
+	}
+}
+
+func TestAdmitMAC(t *testing.T) {
+	defer func(s store.Store) { AuthHandler.state = s; accessPolicies = nil }(AuthHandler.state)
+	AuthHandler.state = store.NewMemory()
+	accessPolicies = compilePolicies([]AccessPolicy{
+		{Name: "guest", Nas: []string{"192.168.70.0/24"}, MaxSession: time.Hour, DailyCap: 2 * time.Hour},
+	})
+	nasip := net.ParseIP("192.168.70.1")
+	userip := net.ParseIP("10.0.70.1")
+	defer Sessions.Remove(userip)
+
+	if !admitMAC("guest1", userip, nasip, "00:0c:29:70:00:01") {
+		t.Fatal("MAC binding rejected within policy")
+	}
+	s, ok := Sessions.Get(userip)
+	if !ok || s.Method != "mac" || s.Deadline.IsZero() || time.Until(s.Deadline) > time.Hour {
+		t.Errorf("session: %+v", s)
+	}
+
+	// 当天时长已用完时按未绑定应答
+	recordUsage(&Session{Username: "guest2", LoginAt: time.Now().Add(-2 * time.Hour)}, time.Now())
+	if admitMAC("guest2", net.ParseIP("10.0.70.2"), nasip, "00:0c:29:70:00:02") {
+		t.Error("MAC binding admitted past the daily cap")
+	}
+}
+
+func TestRecordUsageConcurrent(t *testing.T) {
+	defer func(s store.Store) { AuthHandler.state = s }(AuthHandler.state)
+	AuthHandler.state = store.NewMemory()
+	end := time.Date(2024, 5, 6, 12, 0, 0, 0, time.Local)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			recordUsage(&Session{Username: "alice", LoginAt: end.Add(-time.Minute)}, end)
+		}()
+	}
+	wg.Wait()
+	if v, _ := AuthHandler.state.Get(context.Background(), usageKey("alice", midnight(end))); v != "600" {
+		t.Errorf("recorded %s seconds, want 600", v)
+	}
+}
+
+// 同一IP重新登录或在第二台设备登录都不能超过当天的时长上限
+func TestLoginDailyCap(t *testing.T) {
+	defer func(s store.Store) { AuthHandler.state = s; accessPolicies = nil }(AuthHandler.state)
+	AuthHandler.state = store.NewMemory()
+	nas := startTestNas(t, 2)
+	defer nas.Close()
+	accessPolicies = compilePolicies([]AccessPolicy{
+		{Name: "capped", Nas: []string{nas.Addr().IP.String()}, DailyCap: 2 * time.Hour},
+	})
+	first, second := net.IPv4(10, 4, 0, 1), net.IPv4(10, 4, 0, 2)
+	defer Sessions.Remove(first)
+	defer Sessions.Remove(second)
+
+	login := func(userip net.IP) {
+		t.Helper()
+		form := url.Values{"userip": {userip.String()}, "nasip": {nas.Addr().IP.String()}, "username": {"user"}, "userpwd": {"pwd"}}
+		r := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.RemoteAddr = userip.String() + ":40000"
+		w := httptest.NewRecorder()
+		AuthHandler.HandleLogin(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("login from %s: %d %s", userip, w.Code, w.Body)
+		}
+	}
+	near := func(got, want time.Time) bool {
+		return got.Sub(want).Abs() < time.Minute
+	}
+
+	// 已上网90分钟后在同一IP重新登录，只剩30分钟
+	now := time.Now()
+	Sessions.Add(&Session{Username: "user", UserIP: first, NasIP: nas.Addr().IP, LoginAt: now.Add(-90 * time.Minute), Deadline: now.Add(30 * time.Minute)})
+	login(first)
+	if s, _ := Sessions.Get(first); !near(s.Deadline, now.Add(30*time.Minute)) {
+		t.Errorf("re-login deadline %v, want about 30 minutes", time.Until(s.Deadline))
+	}
+
+	// 第二台设备登录后两台平分剩余的30分钟
+	login(second)
+	for _, ip := range []net.IP{first, second} {
+		if s, _ := Sessions.Get(ip); !near(s.Deadline, now.Add(15*time.Minute)) {
+			t.Errorf("%s deadline %v, want about 15 minutes", ip, time.Until(s.Deadline))
+		}
+	}
+}
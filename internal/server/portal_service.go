@@ -1,11 +1,18 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
+	"os"
+	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
 
+	"syler/internal/audit"
+	"syler/internal/capture"
 	"syler/internal/logger"
 	"syler/internal/portal"
 	v1 "syler/internal/portal/v1"
@@ -15,77 +22,329 @@ import (
 )
 
 type PortalConfig struct {
-	Secret  string
-	NasPort int
-	Version int
-	Port    int
-	Host    string
+	Secret        string
+	NasPort       int
+	Version       int
+	Port          int
+	Host          string
+	Retries       int
+	RetryInterval time.Duration
+	Vendor        string
+	IdleTimeout   time.Duration
+	RedirectAuth  string
+	RedirectKey   string
+	Nas           []NasConfig
+}
+
+// NasConfig 单台NAS的配置，未填写的字段沿用portal节的默认值
+type NasConfig struct {
+	IP            string         `mapstructure:"ip"`
+	Secret        string         `mapstructure:"secret"`
+	Port          int            `mapstructure:"port"`
+	Retries       *int           `mapstructure:"retries"`
+	RetryInterval time.Duration  `mapstructure:"retry_interval"`
+	Version       int            `mapstructure:"version"`
+	Vendor        string         `mapstructure:"vendor"`
+	BasIP         string         `mapstructure:"bas_ip"`
+	IdleTimeout   *time.Duration `mapstructure:"idle_timeout"`
+	RedirectAuth  string         `mapstructure:"redirect_auth"`
+	RedirectKey   string         `mapstructure:"redirect_key"`
 }
 
 func LoadPortalConfig() PortalConfig {
-	return PortalConfig{
-		Secret:  viper.GetString("portal.secret"),
-		NasPort: viper.GetInt("portal.nas_port"),
-		Version: viper.GetInt("portal.version"),
-		Port:    viper.GetInt("portal.port"),
-		Host:    viper.GetString("portal.host"),
+	cfg := PortalConfig{
+		Secret:        viper.GetString("portal.secret"),
+		NasPort:       viper.GetInt("portal.nas_port"),
+		Version:       viper.GetInt("portal.version"),
+		Port:          viper.GetInt("portal.port"),
+		Host:          viper.GetString("portal.host"),
+		Retries:       viper.GetInt("portal.retries"),
+		RetryInterval: viper.GetDuration("portal.retry_interval"),
+		Vendor:        viper.GetString("portal.vendor"),
+		IdleTimeout:   viper.GetDuration("portal.idle_timeout"),
+		RedirectAuth:  viper.GetString("portal.redirect_auth"),
+		RedirectKey:   viper.GetString("portal.redirect_key"),
+	}
+	viper.UnmarshalKey("portal.nas", &cfg.Nas)
+	return cfg
+}
+
+// registerNas 将配置中的NAS登记到portal包，供收发报文时查询密钥和重传策略
+func registerNas(cfg PortalConfig) {
+	log := logger.Subsystem(logger.Portal)
+
+	vendor, err := portal.VendorByName(cfg.Vendor)
+	if err != nil {
+		log.WithField("error", err).Warn("Unknown portal vendor, using huawei")
+		vendor = portal.Huawei
+	}
+	portal.DefaultNas = portal.Nas{
+		Port:          cfg.NasPort,
+		Secret:        cfg.Secret,
+		Retries:       cfg.Retries,
+		RetryInterval: cfg.RetryInterval,
+		Vendor:        vendor,
+		IdleTimeout:   cfg.IdleTimeout,
+		RedirectAuth:  cfg.RedirectAuth,
+		RedirectKey:   cfg.RedirectKey,
+	}
+	for _, n := range cfg.Nas {
+		ip := net.ParseIP(n.IP)
+		if ip == nil {
+			log.WithField("nas_ip", n.IP).Warn("Ignore NAS with invalid IP")
+			continue
+		}
+		nas := portal.DefaultNas
+		nas.IP = ip
+		if n.Secret != "" {
+			nas.Secret = n.Secret
+		}
+		if n.Port != 0 {
+			nas.Port = n.Port
+		}
+		if n.Retries != nil {
+			nas.Retries = *n.Retries
+		}
+		if n.RetryInterval != 0 {
+			nas.RetryInterval = n.RetryInterval
+		}
+		switch n.Version {
+		case 1:
+			nas.Version = new(v1.Version)
+		case 2:
+			nas.Version = new(v2.Version)
+		}
+		if n.Vendor != "" {
+			if nas.Vendor, err = portal.VendorByName(n.Vendor); err != nil {
+				log.WithFields(logrus.Fields{
+					"nas_ip": n.IP,
+					"error":  err,
+				}).Warn("Unknown portal vendor, using default")
+				nas.Vendor = vendor
+			}
+		}
+		if n.BasIP != "" {
+			if nas.BasIP = net.ParseIP(n.BasIP); nas.BasIP == nil {
+				log.WithFields(logrus.Fields{
+					"nas_ip": n.IP,
+					"bas_ip": n.BasIP,
+				}).Error("Ignore NAS with invalid BAS-IP")
+				continue
+			}
+		}
+		if err := nas.Vendor.Check(nas); err != nil {
+			log.WithFields(logrus.Fields{
+				"nas_ip": n.IP,
+				"error":  err,
+			}).Error("Ignore NAS the vendor cannot address")
+			continue
+		}
+		if n.IdleTimeout != nil {
+			nas.IdleTimeout = *n.IdleTimeout
+		}
+		if n.RedirectAuth != "" {
+			nas.RedirectAuth = n.RedirectAuth
+		}
+		if n.RedirectKey != "" {
+			nas.RedirectKey = n.RedirectKey
+		}
+		portal.RegisterNas(nas)
+	}
+	if len(cfg.Nas) == 0 {
+		log.Warn("No NAS listed in portal.nas, accepting NAS initiated messages from any source with the shared secret")
 	}
 }
 
 var portalConfig PortalConfig
 
 func StartPortal() {
-	log := logger.GetLogger()
+	log := logger.Subsystem(logger.Portal)
 
 	portalConfig = LoadPortalConfig()
+	registerNas(portalConfig)
 
-	portal.RegisterFallBack(func(msg portal.Message, src net.IP) {
-		if msg.Type() == portal.NTF_LOGOUT {
-			log.WithFields(logrus.Fields{
-				"message_type": "NTF_LOGOUT",
-				"source_ip":    src.String(),
-			}).Debug("Received portal logout notification")
-			NotifyLogout(msg, src)
-		}
-	})
+	portal.RegisterFallBack(handleNasMessage)
 	if portalConfig.Version == 1 {
 		portal.SetVersion(new(v1.Version))
 	} else {
 		portal.SetVersion(new(v2.Version))
 	}
 
+	if file := viper.GetString("portal.capture_file"); file != "" {
+		if err := startCapture(file); err != nil {
+			log.WithFields(logrus.Fields{
+				"error": err,
+				"file":  file,
+			}).Error("Failed to start portal capture")
+		} else {
+			log.WithField("file", file).Info("Capturing portal traffic")
+		}
+	}
+
 	log.WithFields(logrus.Fields{
 		"host": portalConfig.Host,
 		"port": portalConfig.Port,
 	}).Info("Starting portal server")
 
+	// 额外的监听地址，NAS按配置发往不同地址时使用
+	for _, addr := range viper.GetStringSlice("portal.listen") {
+		log.WithField("addr", addr).Info("Starting additional portal listener")
+		go portal.ListenAndService(addr)
+	}
+
 	addr := fmt.Sprintf("%s:%d", portalConfig.Host, portalConfig.Port)
 	portal.ListenAndService(addr)
 }
 
-func Challenge(userip net.IP, basip net.IP) (response portal.Message, err error) {
-	return portal.Challenge(userip, portalConfig.Secret, basip, portalConfig.NasPort)
+var (
+	captureLock sync.Mutex
+	captureFile *os.File
+)
+
+// startCapture 将收发的Portal报文写入pcap文件，可用 portalctl dump 解码
+func startCapture(file string) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	w, err := capture.NewWriter(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	captureLock.Lock()
+	captureFile = f
+	captureLock.Unlock()
+	portal.SetTap(func(src, dst *net.UDPAddr, data []byte) {
+		w.Write(&capture.Packet{Src: src, Dst: dst, Payload: data})
+	})
+	return nil
+}
+
+// StopCapture 停止抓包并关闭pcap文件，退出前调用
+func StopCapture() {
+	portal.SetTap(nil)
+	captureLock.Lock()
+	defer captureLock.Unlock()
+	if captureFile != nil {
+		captureFile.Close()
+		captureFile = nil
+	}
+}
+
+func Challenge(ctx context.Context, userip net.IP, basip net.IP) (response portal.Message, err error) {
+	nas := portal.NasFor(basip)
+	return portal.Challenge(ctx, userip, nas.Secret, basip, nas.Port)
 }
 
-func Auth(userip net.IP, basip net.IP, username, userpwd []byte) (err error) {
+// Auth 发起CHAP认证，失败时返回*AuthError
+func Auth(ctx context.Context, userip net.IP, basip net.IP, username, userpwd []byte) (err error) {
+	log := logger.FromContext(ctx).WithFields(logrus.Fields{
+		"user_ip": userip.String(),
+		"nas_ip":  basip.String(),
+	})
+	nas := portal.NasFor(basip)
 	var res portal.Message
-	if res, err = Challenge(userip, basip); err == nil {
+	res, err = Challenge(ctx, userip, basip)
+	// 超时已在portal.Send中重传，这里只对NAS返回的可重试错误重新请求Challenge
+	for i := 0; i < nas.Retries && err != nil && !errors.Is(err, portal.ErrTimeout) && portal.IsRetryable(err); i++ {
+		log.WithField("error", err).Warn("Challenge failed, retrying")
+		time.Sleep(nas.RetryInterval)
+		res, err = Challenge(ctx, userip, basip)
+	}
+	step := StepChallenge
+	if err == nil {
+		log.WithFields(logrus.Fields{
+			"serial_no":     res.SerialId(),
+			"portal_req_id": res.ReqId(),
+		}).Debug("Received ACK_CHALLENGE")
 		if cres, ok := res.(portal.ChallengeRes); ok {
-			res, err = portal.ChapAuth(userip, portalConfig.Secret, basip, portalConfig.NasPort, username, userpwd, res.ReqId(), cres.GetChallenge())
+			step = StepAuth
+			res, err = portal.ChapAuth(ctx, userip, nas.Secret, basip, nas.Port, username, userpwd, res.ReqId(), cres.GetChallenge())
 			if err == nil {
-				_, err = portal.AffAckAuth(userip, portalConfig.Secret, basip, portalConfig.NasPort, res.SerialId(), res.ReqId())
+				log.WithFields(logrus.Fields{
+					"serial_no":     res.SerialId(),
+					"portal_req_id": res.ReqId(),
+				}).Debug("Received ACK_AUTH, sending AFF_ACK_AUTH")
+				_, err = portal.AffAckAuth(ctx, userip, nas.Secret, basip, nas.Port, res.SerialId(), res.ReqId())
 			}
 		}
 	}
+	if err != nil {
+		log.WithField("error", err).Debug("Portal authentication failed")
+		cancelAuth(ctx, log, userip, basip, err)
+		err = newAuthError(step, err)
+	}
+	return
+}
+
+// cancelAuth 等待ACK_CHALLENGE或ACK_AUTH超时后发送REQ_LOGOUT(ErrCode=1)，
+// 否则NAS会认为该用户仍在认证中，下次认证时返回错误码3
+func cancelAuth(ctx context.Context, log *logrus.Entry, userip net.IP, basip net.IP, err error) {
+	var terr *portal.TimeoutError
+	if !errors.As(err, &terr) {
+		return
+	}
+	if terr.Type != portal.REQ_CHALLENGE && terr.Type != portal.REQ_AUTH {
+		return
+	}
+	log.WithFields(logrus.Fields{
+		"serial_no":     terr.SerialNo,
+		"portal_req_id": terr.ReqId,
+	}).Warn("Portal request timed out, cancelling authentication")
+	nas := portal.NasFor(basip)
+	if _, err := portal.CancelAuth(ctx, userip, nas.Secret, basip, nas.Port, terr.SerialNo, terr.ReqId); err != nil {
+		log.WithField("error", err).Error("Failed to send timeout logout")
+	}
+}
+
+func Logout(ctx context.Context, userip net.IP, basip net.IP) (response portal.Message, err error) {
+	nas := portal.NasFor(basip)
+	response, err = portal.Logout(ctx, userip, nas.Secret, basip, nas.Port)
+	if err == nil {
+		logger.FromContext(ctx).WithFields(logrus.Fields{
+			"user_ip":   userip.String(),
+			"nas_ip":    basip.String(),
+			"serial_no": response.SerialId(),
+		}).Debug("Received ACK_LOGOUT")
+	}
 	return
 }
 
-func Logout(userip net.IP, basip net.IP) (response portal.Message, err error) {
-	return portal.Logout(userip, portalConfig.Secret, basip, portalConfig.NasPort)
+// Info 通过REQ_INFO查询用户的上下行流量，ctx结束时不再等待NAS应答
+func Info(ctx context.Context, userip net.IP, basip net.IP) (up, down uint64, err error) {
+	nas := portal.NasFor(basip)
+	type result struct {
+		res portal.Message
+		err error
+	}
+	// 请求在portal.Send中按NAS的重传策略结束，放弃等待后由其自行退出
+	done := make(chan result, 1)
+	go func() {
+		res, err := portal.ReqInfo(ctx, userip, nas.Secret, basip, nas.Port)
+		done <- result{res, err}
+	}()
+	var res portal.Message
+	select {
+	case <-ctx.Done():
+		return 0, 0, ctx.Err()
+	case r := <-done:
+		if r.err != nil {
+			return 0, 0, r.err
+		}
+		res = r.res
+	}
+	up, down = portal.Flux(res)
+	logger.FromContext(ctx).WithFields(logrus.Fields{
+		"user_ip":   userip.String(),
+		"nas_ip":    basip.String(),
+		"serial_no": res.SerialId(),
+	}).Debug("Received ACK_INFO")
+	return up, down, nil
 }
 
 func NotifyLogout(msg portal.Message, basip net.IP) {
-	log := logger.GetLogger()
+	log := logger.Subsystem(logger.Portal)
 
 	userip := msg.UserIp()
 	if userip == nil {
@@ -96,8 +355,18 @@ func NotifyLogout(msg portal.Message, basip net.IP) {
 	}
 
 	log.WithFields(logrus.Fields{
-		"user_ip": userip.String(),
-		"nas_ip":  basip.String(),
+		"user_ip":       userip.String(),
+		"nas_ip":        basip.String(),
+		"serial_no":     msg.SerialId(),
+		"portal_req_id": msg.ReqId(),
 	}).Info("Received logout notification")
-	portal.AckNtfLogout(userip, portalConfig.Secret, basip, portalConfig.NasPort, msg.SerialId(), msg.ReqId())
+	endSession(userip)
+	audit.Log(audit.Record{
+		Event:   audit.NtfLogout,
+		UserIP:  userip.String(),
+		NasIP:   basip.String(),
+		Success: true,
+	})
+	nas := portal.NasFor(basip)
+	portal.AckNtfLogout(context.Background(), userip, nas.Secret, basip, nas.Port, msg.SerialId(), msg.ReqId())
 }
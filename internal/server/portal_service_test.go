@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"syler/internal/portal"
+	v1 "syler/internal/portal/v1"
+	v2 "syler/internal/portal/v2"
+	"syler/internal/simulator"
+)
+
+var listenOnce sync.Once
+
+func startTestPortal(t *testing.T) {
+	listenOnce.Do(func() {
+		portal.RegisterFallBack(handleNasMessage)
+		go portal.ListenAndService("127.0.0.1:0")
+	})
+	for !portal.Bound() {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// startTestNas 启动一台模拟NAS并登记到portal包
+func startTestNas(t *testing.T, ver int) *simulator.Nas {
+	startTestPortal(t)
+	if ver == 1 {
+		portal.SetVersion(new(v1.Version))
+	} else {
+		portal.SetVersion(new(v2.Version))
+	}
+	nas := simulator.New(simulator.Config{
+		Addr:    "127.0.0.1:0",
+		Version: ver,
+		Secret:  "secret",
+		Users:   map[string]string{"user": "pwd"},
+	})
+	if err := nas.Start(); err != nil {
+		t.Fatal(err)
+	}
+	portal.RegisterNas(portal.Nas{
+		IP:            nas.Addr().IP,
+		Port:          nas.Addr().Port,
+		Secret:        "secret",
+		Retries:       1,
+		RetryInterval: 100 * time.Millisecond,
+	})
+	return nas
+}
+
+func TestAuth(t *testing.T) {
+	for _, ver := range []int{1, 2} {
+		nas := startTestNas(t, ver)
+		userip := net.IPv4(10, 0, 0, 1)
+
+		var perr *portal.Error
+		err := Auth(context.Background(), userip, nas.Addr().IP, []byte("user"), []byte("wrong"))
+		if !errors.As(err, &perr) || perr.Code != 1 {
+			t.Errorf("v%d: wrong password: got %v", ver, err)
+		}
+		if err := Auth(context.Background(), userip, nas.Addr().IP, []byte("user"), []byte("pwd")); err != nil {
+			t.Errorf("v%d: auth failed: %v", ver, err)
+		}
+		if !nas.Online(userip) {
+			t.Errorf("v%d: user not online on NAS", ver)
+		}
+		if _, err := Logout(context.Background(), userip, nas.Addr().IP); err != nil {
+			t.Errorf("v%d: logout failed: %v", ver, err)
+		}
+		if nas.BadAuthenticators() != 0 {
+			t.Errorf("v%d: NAS rejected %d authenticators", ver, nas.BadAuthenticators())
+		}
+		nas.Close()
+	}
+}
+
+func TestAuthTimeoutSendsLogout(t *testing.T) {
+	for _, ver := range []int{1, 2} {
+		for _, typ := range []byte{portal.REQ_CHALLENGE, portal.REQ_AUTH} {
+			nas := startTestNas(t, ver)
+			userip := net.IPv4(10, 0, 0, 2)
+			nas.Inject(typ, &simulator.Fault{Drop: -1})
+
+			err := Auth(context.Background(), userip, nas.Addr().IP, []byte("user"), []byte("pwd"))
+			if !errors.Is(err, portal.ErrTimeout) {
+				t.Fatalf("v%d: want timeout, got %v", ver, err)
+			}
+			time.Sleep(50 * time.Millisecond)
+
+			var logout portal.Message
+			for _, m := range nas.Received() {
+				if m.Type() == portal.REQ_LOGOUT {
+					logout = m
+				}
+			}
+			if logout == nil || logout.ErrCode() != 1 {
+				t.Fatalf("v%d: no REQ_LOGOUT with ErrCode 1 after type %d timeout", ver, typ)
+			}
+
+			// 超时下线后NAS不再认为用户在认证中
+			nas.Inject(typ, nil)
+			if err := Auth(context.Background(), userip, nas.Addr().IP, []byte("user"), []byte("pwd")); err != nil {
+				t.Errorf("v%d: auth after timeout logout failed: %v", ver, err)
+			}
+			nas.Close()
+		}
+	}
+}
+
+func TestNotifyLogout(t *testing.T) {
+	for _, ver := range []int{1, 2} {
+		nas := startTestNas(t, ver)
+		userip := net.IPv4(10, 0, 0, 3)
+		if err := Auth(context.Background(), userip, nas.Addr().IP, []byte("user"), []byte("pwd")); err != nil {
+			t.Fatalf("v%d: auth failed: %v", ver, err)
+		}
+		if err := nas.NotifyLogout(userip, nil); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(100 * time.Millisecond)
+		acked := false
+		for _, m := range nas.Received() {
+			if m.Type() == portal.ACK_NTF_LOGOUT {
+				acked = true
+			}
+		}
+		if !acked {
+			t.Errorf("v%d: NTF_LOGOUT was not acknowledged", ver)
+		}
+		nas.Close()
+	}
+}
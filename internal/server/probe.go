@@ -0,0 +1,108 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	"syler/internal/logger"
+	"syler/internal/portal"
+)
+
+// probe 操作系统联网检测（CNA）请求在认证后期望的应答
+type probe struct {
+	status int
+	body   string
+}
+
+const appleSuccess = "<HTML><HEAD><TITLE>Success</TITLE></HEAD><BODY>Success</BODY></HTML>"
+
+// probes 按请求路径识别各系统的检测请求
+var probes = map[string]probe{
+	"/generate_204":              {http.StatusNoContent, ""}, // Android、Chrome
+	"/gen_204":                   {http.StatusNoContent, ""},
+	"/hotspot-detect.html":       {http.StatusOK, appleSuccess}, // iOS、macOS
+	"/library/test/success.html": {http.StatusOK, appleSuccess},
+	"/connecttest.txt":           {http.StatusOK, "Microsoft Connect Test"}, // Windows 10+
+	"/ncsi.txt":                  {http.StatusOK, "Microsoft NCSI"},
+	"/success.txt":               {http.StatusOK, "success\n"},                  // Firefox
+	"/check_network_status.txt":  {http.StatusOK, "NetworkManager is online\n"}, // Linux
+}
+
+// formValue 返回第一个非空的参数，兼容各厂商重定向地址中的参数名
+func formValue(r *http.Request, names ...string) string {
+	for _, name := range names {
+		if v := r.FormValue(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// HandleProbe 处理联网检测请求：未认证的用户重定向到认证页面，已认证的用户返回系统期望的应答，
+// 使认证窗口自动关闭。不是检测请求时返回false
+func (a *Authenticator) HandleProbe(w http.ResponseWriter, r *http.Request) bool {
+	p, ok := probes[strings.ToLower(r.URL.Path)]
+	if !ok {
+		return false
+	}
+
+	userip := net.ParseIP(formValue(r, "userip", "wlanuserip"))
+	if userip == nil {
+		userip = sourceIP(r)
+	}
+	log := logger.WithRequest(r).WithFields(logrus.Fields{
+		"user_ip": userip.String(),
+		"host":    r.Host,
+		"path":    r.URL.Path,
+	})
+
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	if _, online := Sessions.Get(userip); online {
+		log.Debug("Answer captive portal probe from online user")
+		if p.body != "" {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			if strings.HasSuffix(r.URL.Path, ".txt") {
+				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			}
+		}
+		w.WriteHeader(p.status)
+		w.Write([]byte(p.body))
+		return true
+	}
+
+	q := url.Values{}
+	if userip != nil {
+		q.Set("userip", userip.String())
+	}
+	if nasip := formValue(r, "nasip", "wlanacip"); nasip != "" {
+		q.Set("nasip", nasip)
+	} else if nasip := viper.GetString("http.nas_ip"); nasip != "" {
+		q.Set("nasip", nasip)
+	}
+	if mac := formValue(r, "usermac", "wlanusermac"); mac != "" {
+		q.Set("usermac", mac)
+	} else if mac, ok := Discovered.Lookup(userip); ok {
+		q.Set("usermac", mac.String())
+	}
+
+	// 只为来源地址本身签名，避免为他人的IP取得签名
+	if nasip := net.ParseIP(q.Get("nasip")); nasip != nil && userip.Equal(sourceIP(r)) {
+		if nas := portal.NasFor(nasip); nas.RedirectAuth == RedirectHMAC {
+			signRedirect(q, nas, time.Now())
+		}
+	}
+
+	target := viper.GetString("web.portal_url")
+	if target == "" {
+		target = "/portal"
+	}
+	log.Debug("Redirect captive portal probe to portal page")
+	http.Redirect(w, r, target+"?"+q.Encode(), http.StatusFound)
+	return true
+}
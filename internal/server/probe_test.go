@@ -0,0 +1,50 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestHandleProbe(t *testing.T) {
+	a := &Authenticator{}
+	userip := net.ParseIP("10.0.0.8")
+	Discovered.Add(userip, net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff})
+
+	r := httptest.NewRequest(http.MethodGet, "http://captive.apple.com/hotspot-detect.html?wlanacip=192.168.10.3", nil)
+	r.RemoteAddr = "10.0.0.8:51000"
+	w := httptest.NewRecorder()
+	if !a.HandleProbe(w, r) {
+		t.Fatal("apple probe not recognized")
+	}
+	if w.Code != http.StatusFound {
+		t.Fatalf("offline user: got %d, want redirect", w.Code)
+	}
+	loc, _ := url.Parse(w.Header().Get("Location"))
+	q := loc.Query()
+	if loc.Path != "/portal" || q.Get("userip") != "10.0.0.8" || q.Get("nasip") != "192.168.10.3" || q.Get("usermac") != "aa:bb:cc:dd:ee:ff" {
+		t.Errorf("redirect location: %s", loc)
+	}
+
+	Sessions.Add(&Session{Username: "alice", UserIP: userip, NasIP: net.ParseIP("192.168.10.3")})
+	defer Sessions.Remove(userip)
+	for path, want := range map[string]int{
+		"/generate_204":        http.StatusNoContent,
+		"/hotspot-detect.html": http.StatusOK,
+		"/connecttest.txt":     http.StatusOK,
+	} {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.RemoteAddr = "10.0.0.8:51000"
+		w := httptest.NewRecorder()
+		a.HandleProbe(w, r)
+		if w.Code != want || w.Body.String() != probes[path].body {
+			t.Errorf("%s online: got %d %q", path, w.Code, w.Body.String())
+		}
+	}
+
+	if a.HandleProbe(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/portal", nil)) {
+		t.Error("portal page treated as probe")
+	}
+}
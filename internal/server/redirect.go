@@ -0,0 +1,176 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"syler/internal/portal"
+	"syler/internal/web"
+)
+
+// 校验认证页面提交的用户参数的方式，按NAS配置
+const (
+	RedirectNone   = "none"   // 不校验
+	RedirectHMAC   = "hmac"   // 重定向地址带有ts和sign签名参数
+	RedirectSource = "source" // 用户IP必须与请求来源地址一致，未配置时的默认方式
+)
+
+var (
+	errRedirectUnsigned = errors.New("redirect parameters are not signed")
+	errRedirectExpired  = errors.New("redirect signature expired")
+	errRedirectSign     = errors.New("redirect signature mismatch")
+	errRedirectReused   = errors.New("redirect signature already used")
+	errSourceMismatch   = errors.New("user IP does not match request source")
+	errSessionToken     = errors.New("invalid session token")
+	errRedirectMode     = errors.New("unknown redirect_auth mode")
+)
+
+// redirectTTL 签名的有效期
+func redirectTTL() time.Duration {
+	if d := viper.GetDuration("portal.redirect_ttl"); d > 0 {
+		return d
+	}
+	return 10 * time.Minute
+}
+
+func redirectKey(nas portal.Nas) []byte {
+	if nas.RedirectKey != "" {
+		return []byte(nas.RedirectKey)
+	}
+	return []byte(nas.Secret)
+}
+
+// redirectSign 计算 hex(HMAC-SHA256(key, "userip|nasip|usermac|ts"))，参数使用重定向地址中的原始值
+func redirectSign(nas portal.Nas, userip, nasip, usermac, ts string) string {
+	h := hmac.New(sha256.New, redirectKey(nas))
+	h.Write([]byte(strings.Join([]string{userip, nasip, usermac, ts}, "|")))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// signRedirect 为重定向到认证页面的参数加上ts和sign
+func signRedirect(q url.Values, nas portal.Nas, now time.Time) {
+	ts := strconv.FormatInt(now.Unix(), 10)
+	q.Set("ts", ts)
+	q.Set("sign", redirectSign(nas, q.Get("userip"), q.Get("nasip"), q.Get("usermac"), ts))
+}
+
+// sourceIP 请求的来源地址
+func sourceIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// verifyRedirect 按NAS配置校验登录请求中的userip、nasip和usermac是否来自NAS的重定向
+func verifyRedirect(r *http.Request, nas portal.Nas, userip net.IP) error {
+	switch nas.RedirectAuth {
+	case RedirectNone:
+		return nil
+	case "", RedirectSource:
+		if !userip.Equal(sourceIP(r)) {
+			return errSourceMismatch
+		}
+		return nil
+	case RedirectHMAC:
+		ts, sign := r.FormValue("ts"), r.FormValue("sign")
+		if ts == "" || sign == "" {
+			return errRedirectUnsigned
+		}
+		sec, err := strconv.ParseInt(ts, 10, 64)
+		if err != nil {
+			return errRedirectUnsigned
+		}
+		// 允许NAS与syler之间一分钟的时钟偏差
+		if age := time.Since(time.Unix(sec, 0)); age > redirectTTL() || age < -time.Minute {
+			return errRedirectExpired
+		}
+		want := redirectSign(nas, r.FormValue("userip"), r.FormValue("nasip"), r.FormValue("usermac"), ts)
+		if !hmac.Equal([]byte(sign), []byte(want)) {
+			return errRedirectSign
+		}
+		return nil
+	}
+	return errRedirectMode
+}
+
+// redirectUsedPrefix 已用于登录的重定向签名
+const redirectUsedPrefix = "redirect_used:"
+
+// claimRedirect hmac方式下占用重定向签名，同一签名同时只有一个登录请求能使用。
+// 登录失败时调用release归还，用户可以改正密码后重试；登录成功后签名不再可用，
+// 截获的重定向地址只能在用户登录之前、签名有效期内被抢先使用
+func (a *Authenticator) claimRedirect(ctx context.Context, r *http.Request, nas portal.Nas) (release func(), err error) {
+	release = func() {}
+	if nas.RedirectAuth != RedirectHMAC {
+		return release, nil
+	}
+	key := redirectUsedPrefix + r.FormValue("sign")
+	// 签名在redirectTTL加时钟偏差内有效，记录保留同样长的时间
+	n, err := a.state.Incr(ctx, key, redirectTTL()+time.Minute)
+	if err != nil {
+		return release, err
+	}
+	if n > 1 {
+		return release, errRedirectReused
+	}
+	return func() { a.state.Del(context.WithoutCancel(ctx), key) }, nil
+}
+
+// sessionToken 登录成功后返回给页面的令牌，登出时校验，用户重新登录后旧令牌失效
+func sessionToken(nas portal.Nas, s Session) string {
+	h := hmac.New(sha256.New, redirectKey(nas))
+	h.Write([]byte(strings.Join([]string{
+		"session", s.Username, s.UserIP.String(), s.NasIP.String(),
+		strconv.FormatInt(s.LoginAt.UnixNano(), 10),
+	}, "|")))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ownsSession 请求来自会话的用户IP、带有登录时下发的令牌，或来自登录时的浏览器
+func ownsSession(r *http.Request, s Session) bool {
+	if s.UserIP.Equal(sourceIP(r)) {
+		return true
+	}
+	if token := r.FormValue("token"); token != "" && hmac.Equal([]byte(token), []byte(sessionToken(portal.NasFor(s.NasIP), s))) {
+		return true
+	}
+	id, ok := web.SessionID(r)
+	return ok && s.Browser != "" && id == s.Browser
+}
+
+// verifySession 校验登出、心跳等针对已登录用户的请求：登录时的浏览器总是可以操作，
+// 此外source方式要求来源地址为用户IP，hmac方式要求登录时下发的令牌
+func verifySession(r *http.Request, nas portal.Nas, userip net.IP) error {
+	if s, ok := Sessions.Get(userip); ok && ownsSession(r, s) {
+		return nil
+	}
+	switch nas.RedirectAuth {
+	case RedirectNone:
+		return nil
+	case "", RedirectSource:
+		if !userip.Equal(sourceIP(r)) {
+			return errSourceMismatch
+		}
+		return nil
+	case RedirectHMAC:
+		s, ok := Sessions.Get(userip)
+		if !ok || !hmac.Equal([]byte(r.FormValue("token")), []byte(sessionToken(nas, s))) {
+			return errSessionToken
+		}
+		return nil
+	}
+	return errRedirectMode
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"syler/internal/portal"
+	"syler/internal/store"
+)
+
+func postForm(path string, q url.Values, remote string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(q.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.RemoteAddr = remote
+	return r
+}
+
+func TestVerifyRedirect(t *testing.T) {
+	nas := portal.Nas{Secret: "secret", RedirectAuth: RedirectHMAC, RedirectKey: "redirect-key"}
+	userip := net.ParseIP("10.0.0.8")
+	q := url.Values{"userip": {"10.0.0.8"}, "nasip": {"192.168.10.3"}, "usermac": {"aa:bb:cc:dd:ee:ff"}}
+
+	signed := url.Values{}
+	for k, v := range q {
+		signed[k] = v
+	}
+	signRedirect(signed, nas, time.Now())
+	if err := verifyRedirect(postForm("/api/login", signed, "10.0.0.8:5000"), nas, userip); err != nil {
+		t.Errorf("signed: %v", err)
+	}
+
+	spoofed := url.Values{}
+	for k, v := range signed {
+		spoofed[k] = v
+	}
+	spoofed.Set("userip", "10.0.0.9")
+	if err := verifyRedirect(postForm("/api/login", spoofed, "10.0.0.8:5000"), nas, net.ParseIP("10.0.0.9")); err != errRedirectSign {
+		t.Errorf("spoofed userip: %v", err)
+	}
+	if err := verifyRedirect(postForm("/api/login", q, "10.0.0.8:5000"), nas, userip); err != errRedirectUnsigned {
+		t.Errorf("unsigned: %v", err)
+	}
+	expired := url.Values{}
+	for k, v := range q {
+		expired[k] = v
+	}
+	signRedirect(expired, nas, time.Now().Add(-time.Hour))
+	if err := verifyRedirect(postForm("/api/login", expired, "10.0.0.8:5000"), nas, userip); err != errRedirectExpired {
+		t.Errorf("expired: %v", err)
+	}
+
+	nas.RedirectAuth = RedirectSource
+	if err := verifyRedirect(postForm("/api/login", q, "10.0.0.8:5000"), nas, userip); err != nil {
+		t.Errorf("source: %v", err)
+	}
+	if err := verifyRedirect(postForm("/api/login", q, "10.0.0.9:5000"), nas, userip); err != errSourceMismatch {
+		t.Errorf("source mismatch: %v", err)
+	}
+
+	// 未配置时默认按来源地址校验
+	nas.RedirectAuth = ""
+	if err := verifyRedirect(postForm("/api/login", q, "10.0.0.9:5000"), nas, userip); err != errSourceMismatch {
+		t.Errorf("default: %v", err)
+	}
+
+	nas.RedirectAuth = RedirectNone
+	if err := verifyRedirect(postForm("/api/login", q, "10.0.0.9:5000"), nas, userip); err != nil {
+		t.Errorf("none: %v", err)
+	}
+}
+
+// 重定向签名登录成功后不能再次使用，登录失败时可以重试
+func TestRedirectSingleUse(t *testing.T) {
+	defer func(s store.Store) { AuthHandler.state = s }(AuthHandler.state)
+	AuthHandler.state = store.NewMemory()
+	sim := startTestNas(t, 2)
+	defer sim.Close()
+	nas := portal.NasFor(sim.Addr().IP)
+	nas.RedirectAuth = RedirectHMAC
+	portal.RegisterNas(nas)
+	userip := net.IPv4(10, 5, 0, 1)
+	defer Sessions.Remove(userip)
+
+	q := url.Values{"userip": {userip.String()}, "nasip": {sim.Addr().IP.String()}}
+	signRedirect(q, nas, time.Now())
+	login := func(pwd string) int {
+		form := url.Values{"username": {"user"}, "userpwd": {pwd}}
+		for k, v := range q {
+			form[k] = v
+		}
+		w := httptest.NewRecorder()
+		AuthHandler.HandleLogin(w, postForm("/login", form, "203.0.113.1:40000"))
+		return w.Code
+	}
+	if code := login("wrong"); code != http.StatusUnauthorized {
+		t.Fatalf("wrong password: %d", code)
+	}
+	if code := login("pwd"); code != http.StatusOK {
+		t.Fatalf("retry after failure: %d", code)
+	}
+	if code := login("pwd"); code != http.StatusForbidden {
+		t.Errorf("replayed signature: %d, want 403", code)
+	}
+	if _, err := AuthHandler.state.Get(context.Background(), redirectUsedPrefix+q.Get("sign")); err != nil {
+		t.Errorf("used signature not recorded: %v", err)
+	}
+}
+
+func TestVerifyLogout(t *testing.T) {
+	nas := portal.Nas{Secret: "secret", RedirectAuth: RedirectHMAC}
+	userip := net.ParseIP("10.0.0.10")
+	s := &Session{Username: "alice", UserIP: userip, NasIP: net.ParseIP("192.168.10.3"), LoginAt: time.Now()}
+	Sessions.Add(s)
+	defer Sessions.Remove(userip)
+
+	q := url.Values{"userip": {"10.0.0.10"}, "nasip": {"192.168.10.3"}}
+	if err := verifySession(postForm("/api/logout", q, "10.0.0.99:5000"), nas, userip); err != errSessionToken {
+		t.Errorf("logout without token: %v", err)
+	}
+	q.Set("token", sessionToken(nas, *s))
+	if err := verifySession(postForm("/api/logout", q, "10.0.0.99:5000"), nas, userip); err != nil {
+		t.Errorf("logout with token: %v", err)
+	}
+
+	// 重新登录后旧令牌失效
+	Sessions.Add(&Session{Username: "alice", UserIP: userip, NasIP: s.NasIP, LoginAt: s.LoginAt.Add(time.Second)})
+	if err := verifySession(postForm("/api/logout", q, "10.0.0.99:5000"), nas, userip); err != errSessionToken {
+		t.Errorf("logout with stale token: %v", err)
+	}
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Session 认证成功的在线用户
+type Session struct {
+	Username string    `json:"username"`
+	UserIP   net.IP    `json:"userip"`
+	UserMAC  string    `json:"usermac,omitempty"`
+	NasIP    net.IP    `json:"nasip"`
+	LoginAt  time.Time `json:"login_at"`
+	LastSeen time.Time `json:"last_seen"`         // 最近一次心跳时间
+	Browser  string    `json:"browser,omitempty"` // 登录时浏览器的会话ID
+	Method   string    `json:"method,omitempty"`  // 认证方式：sms、chap
+	Deadline time.Time `json:"deadline"`          // 访问策略要求下线的时间，零值表示不限
+}
+
+// SessionStore 在线用户表，单机使用内存，集群模式下存放在Redis中由各节点共享
+type SessionStore interface {
+	Add(s *Session)
+	Remove(userip net.IP) *Session
+	Get(userip net.IP) (Session, bool)
+	Touch(userip net.IP) bool
+	SetMAC(userip net.IP, mac string) bool
+	Shorten(userip net.IP, deadline time.Time) bool
+	ByMAC(mac string) (Session, bool)
+	ByUsername(username string) []Session
+	Rekey(old, userip net.IP) bool
+	Idle(now time.Time, idle func(nasip net.IP) time.Duration) []Session
+	List() []Session
+	Len() int
+}
+
+// SessionTable 按用户IP索引的内存在线用户表
+type SessionTable struct {
+	lock     sync.Mutex
+	sessions map[string]*Session
+}
+
+var Sessions SessionStore = NewSessionTable()
+
+func NewSessionTable() *SessionTable {
+	return &SessionTable{sessions: make(map[string]*Session)}
+}
+
+// Add 登记在线用户，同一IP的旧会话被覆盖
+func (t *SessionTable) Add(s *Session) {
+	now := time.Now()
+	if s.LoginAt.IsZero() {
+		s.LoginAt = now
+	}
+	if s.LastSeen.IsZero() {
+		s.LastSeen = now
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.sessions[s.UserIP.String()] = s
+}
+
+// Remove 删除在线用户，返回被删除的会话
+func (t *SessionTable) Remove(userip net.IP) *Session {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	s, ok := t.sessions[userip.String()]
+	if !ok {
+		return nil
+	}
+	delete(t.sessions, userip.String())
+	return s
+}
+
+// Get 返回在线用户的副本
+func (t *SessionTable) Get(userip net.IP) (Session, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	s, ok := t.sessions[userip.String()]
+	if !ok {
+		return Session{}, false
+	}
+	return *s, true
+}
+
+// Touch 收到心跳时刷新最近活动时间，用户不在线时返回false
+func (t *SessionTable) Touch(userip net.IP) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	s, ok := t.sessions[userip.String()]
+	if ok {
+		s.LastSeen = time.Now()
+	}
+	return ok
+}
+
+// Idle 返回最近活动时间早于idle(nasip)之前的会话，idle返回0的NAS不参与检测
+func (t *SessionTable) Idle(now time.Time, idle func(nasip net.IP) time.Duration) []Session {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	var list []Session
+	for _, s := range t.sessions {
+		if d := idle(s.NasIP); d > 0 && now.Sub(s.LastSeen) > d {
+			list = append(list, *s)
+		}
+	}
+	return list
+}
+
+// SetMAC 更新在线用户的MAC，用户不在线时返回false
+func (t *SessionTable) SetMAC(userip net.IP, mac string) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	s, ok := t.sessions[userip.String()]
+	if ok {
+		s.UserMAC = mac
+	}
+	return ok
+}
+
+// Shorten 将会话的下线时间提前到deadline，原下线时间更早时不变，用户不在线时返回false
+func (t *SessionTable) Shorten(userip net.IP, deadline time.Time) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	s, ok := t.sessions[userip.String()]
+	if ok {
+		s.Deadline = earlier(s.Deadline, deadline)
+	}
+	return ok
+}
+
+// earlier 返回较早的下线时间，零值表示不限
+func earlier(a, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+	return a
+}
+
+// ByMAC 按MAC查找在线用户
+func (t *SessionTable) ByMAC(mac string) (Session, bool) {
+	mac = normalizeMAC(mac)
+	if mac == "" {
+		return Session{}, false
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, s := range t.sessions {
+		if normalizeMAC(s.UserMAC) == mac {
+			return *s, true
+		}
+	}
+	return Session{}, false
+}
+
+// ByUsername 返回使用该用户名在线的会话
+func (t *SessionTable) ByUsername(username string) []Session {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	var list []Session
+	for _, s := range t.sessions {
+		if s.Username == username {
+			list = append(list, *s)
+		}
+	}
+	return list
+}
+
+// Rekey 用户IP变化后将会话移到新IP下
+func (t *SessionTable) Rekey(old, userip net.IP) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	s, ok := t.sessions[old.String()]
+	if !ok {
+		return false
+	}
+	delete(t.sessions, old.String())
+	s.UserIP = userip
+	s.LastSeen = time.Now()
+	t.sessions[userip.String()] = s
+	return true
+}
+
+// List 返回所有在线用户的副本
+func (t *SessionTable) List() []Session {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	list := make([]Session, 0, len(t.sessions))
+	for _, s := range t.sessions {
+		list = append(list, *s)
+	}
+	return list
+}
+
+// Len 返回在线用户数
+func (t *SessionTable) Len() int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return len(t.sessions)
+}
+
+// normalizeMAC 去掉分隔符并转为小写，如 AA-BB-CC-DD-EE-FF 转为 aabbccddeeff
+func normalizeMAC(mac string) string {
+	return strings.ToLower(strings.NewReplacer(":", "", "-", "", ".", "").Replace(mac))
+}
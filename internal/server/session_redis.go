@@ -0,0 +1,332 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+
+	"syler/internal/logger"
+)
+
+// RedisSessions 存放在Redis中的在线用户表，集群各节点共享
+//
+//	<prefix>session:<ip>      会话JSON
+//	<prefix>sessions          在线用户IP集合
+//	<prefix>session_mac:<mac> MAC对应的用户IP
+//	<prefix>session_user:<name> 用户名在线的IP集合
+//
+// 各键可能分布在Redis集群的不同槽位，因此使用普通管道而非事务。
+// 会话和MAC键按sessionTTL设置过期时间，每次写入时刷新，定时任务未能下线的会话不会永久残留
+type RedisSessions struct {
+	rdb    redis.UniversalClient
+	prefix string
+}
+
+const (
+	// sessionTTLSlack 会话键在应下线时间之后保留的时长，留给定时任务下线并通知NAS
+	sessionTTLSlack = 10 * time.Minute
+	// sessionMaxTTL 没有空闲检查和截止时间的会话键的过期时间
+	sessionMaxTTL = 7 * 24 * time.Hour
+)
+
+// sessionTTL 会话键的过期时间：最近一次心跳加空闲时长、截止时间中较早的一个，再加上sessionTTLSlack
+func sessionTTL(s *Session, now time.Time) time.Duration {
+	ttl := sessionMaxTTL
+	if idle := idleTimeout(s.NasIP); idle > 0 {
+		ttl = min(ttl, s.LastSeen.Add(idle).Sub(now)+sessionTTLSlack)
+	}
+	if !s.Deadline.IsZero() {
+		ttl = min(ttl, s.Deadline.Sub(now)+sessionTTLSlack)
+	}
+	return max(ttl, sessionTTLSlack)
+}
+
+func NewRedisSessions(rdb redis.UniversalClient, prefix string) *RedisSessions {
+	return &RedisSessions{rdb: rdb, prefix: prefix}
+}
+
+func (r *RedisSessions) key(userip net.IP) string {
+	return r.prefix + "session:" + userip.String()
+}
+
+func (r *RedisSessions) macKey(mac string) string {
+	return r.prefix + "session_mac:" + normalizeMAC(mac)
+}
+
+func (r *RedisSessions) userKey(username string) string {
+	return r.prefix + "session_user:" + username
+}
+
+func (r *RedisSessions) setKey() string {
+	return r.prefix + "sessions"
+}
+
+func (r *RedisSessions) ctx() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), 3*time.Second)
+}
+
+func (r *RedisSessions) logError(err error, op string) {
+	logger.Subsystem(logger.Portal).WithFields(logrus.Fields{
+		"error":     err,
+		"operation": op,
+	}).Error("Redis session store error")
+}
+
+func (r *RedisSessions) Add(s *Session) {
+	now := time.Now()
+	if s.LoginAt.IsZero() {
+		s.LoginAt = now
+	}
+	if s.LastSeen.IsZero() {
+		s.LastSeen = now
+	}
+	ctx, cancel := r.ctx()
+	defer cancel()
+	if err := r.save(ctx, s); err != nil {
+		r.logError(err, "add")
+	}
+}
+
+func (r *RedisSessions) save(ctx context.Context, s *Session) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	ttl := sessionTTL(s, time.Now())
+	pipe := r.rdb.Pipeline()
+	pipe.Set(ctx, r.key(s.UserIP), data, ttl)
+	pipe.SAdd(ctx, r.setKey(), s.UserIP.String())
+	pipe.SAdd(ctx, r.userKey(s.Username), s.UserIP.String())
+	if s.UserMAC != "" {
+		pipe.Set(ctx, r.macKey(s.UserMAC), s.UserIP.String(), ttl)
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (r *RedisSessions) load(ctx context.Context, userip net.IP) (*Session, error) {
+	data, err := r.rdb.Get(ctx, r.key(userip)).Bytes()
+	if err != nil {
+		return nil, err
+	}
+	s := new(Session)
+	return s, json.Unmarshal(data, s)
+}
+
+// Remove 使用GETDEL删除，多个节点同时删除同一用户时只有一个节点拿到会话
+func (r *RedisSessions) Remove(userip net.IP) *Session {
+	ctx, cancel := r.ctx()
+	defer cancel()
+	data, err := r.rdb.GetDel(ctx, r.key(userip)).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			r.logError(err, "remove")
+		}
+		return nil
+	}
+	s := new(Session)
+	if err := json.Unmarshal(data, s); err != nil {
+		r.logError(err, "remove")
+		return nil
+	}
+	pipe := r.rdb.Pipeline()
+	pipe.SRem(ctx, r.setKey(), userip.String())
+	pipe.SRem(ctx, r.userKey(s.Username), userip.String())
+	if s.UserMAC != "" {
+		pipe.Del(ctx, r.macKey(s.UserMAC))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		r.logError(err, "remove")
+	}
+	return s
+}
+
+func (r *RedisSessions) Get(userip net.IP) (Session, bool) {
+	ctx, cancel := r.ctx()
+	defer cancel()
+	s, err := r.load(ctx, userip)
+	if err != nil {
+		if err != redis.Nil {
+			r.logError(err, "get")
+		}
+		return Session{}, false
+	}
+	return *s, true
+}
+
+// update 读取会话修改后写回并刷新过期时间，用户不在线时返回false。
+// 写回使用SET XX，读取后会话被其他节点删除时不会把已下线的会话写回
+func (r *RedisSessions) update(userip net.IP, op string, f func(*Session)) bool {
+	ctx, cancel := r.ctx()
+	defer cancel()
+	s, err := r.load(ctx, userip)
+	if err != nil {
+		if err != redis.Nil {
+			r.logError(err, op)
+		}
+		return false
+	}
+	f(s)
+	data, err := json.Marshal(s)
+	if err != nil {
+		r.logError(err, op)
+		return false
+	}
+	ttl := sessionTTL(s, time.Now())
+	ok, err := r.rdb.SetXX(ctx, r.key(userip), data, ttl).Result()
+	if err != nil {
+		r.logError(err, op)
+		return false
+	}
+	if !ok {
+		return false
+	}
+	// 会话键已写入，索引在Remove之后残留时由loadAll和ByMAC清理或忽略
+	if s.UserMAC != "" {
+		if err := r.rdb.Set(ctx, r.macKey(s.UserMAC), userip.String(), ttl).Err(); err != nil {
+			r.logError(err, op)
+		}
+	}
+	return true
+}
+
+func (r *RedisSessions) Touch(userip net.IP) bool {
+	return r.update(userip, "touch", func(s *Session) {
+		s.LastSeen = time.Now()
+	})
+}
+
+func (r *RedisSessions) SetMAC(userip net.IP, mac string) bool {
+	return r.update(userip, "set_mac", func(s *Session) {
+		s.UserMAC = mac
+	})
+}
+
+func (r *RedisSessions) Shorten(userip net.IP, deadline time.Time) bool {
+	return r.update(userip, "shorten", func(s *Session) {
+		s.Deadline = earlier(s.Deadline, deadline)
+	})
+}
+
+func (r *RedisSessions) ByMAC(mac string) (Session, bool) {
+	if normalizeMAC(mac) == "" {
+		return Session{}, false
+	}
+	ctx, cancel := r.ctx()
+	defer cancel()
+	ip, err := r.rdb.Get(ctx, r.macKey(mac)).Result()
+	if err != nil {
+		if err != redis.Nil {
+			r.logError(err, "by_mac")
+		}
+		return Session{}, false
+	}
+	s, ok := r.Get(net.ParseIP(ip))
+	if !ok || normalizeMAC(s.UserMAC) != normalizeMAC(mac) {
+		return Session{}, false
+	}
+	return s, true
+}
+
+func (r *RedisSessions) Rekey(old, userip net.IP) bool {
+	s := r.Remove(old)
+	if s == nil {
+		return false
+	}
+	s.UserIP = userip
+	s.LastSeen = time.Now()
+	r.Add(s)
+	return true
+}
+
+// loadAll 读取集合set中各IP的会话，清理会话已被删除的IP
+func (r *RedisSessions) loadAll(ctx context.Context, set string) ([]Session, error) {
+	ips, err := r.rdb.SMembers(ctx, set).Result()
+	if err != nil || len(ips) == 0 {
+		return nil, err
+	}
+	pipe := r.rdb.Pipeline()
+	cmds := make([]*redis.StringCmd, len(ips))
+	for i, ip := range ips {
+		cmds[i] = pipe.Get(ctx, r.key(net.ParseIP(ip)))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+	var list []Session
+	for i, cmd := range cmds {
+		data, err := cmd.Bytes()
+		if err != nil {
+			// 会话已被删除，清理集合中残留的IP
+			r.rdb.SRem(ctx, set, ips[i])
+			continue
+		}
+		var s Session
+		if json.Unmarshal(data, &s) != nil {
+			continue
+		}
+		list = append(list, s)
+	}
+	return list, nil
+}
+
+func (r *RedisSessions) List() []Session {
+	ctx, cancel := r.ctx()
+	defer cancel()
+	list, err := r.loadAll(ctx, r.setKey())
+	if err != nil {
+		r.logError(err, "list")
+	}
+	return list
+}
+
+func (r *RedisSessions) Idle(now time.Time, idle func(nasip net.IP) time.Duration) []Session {
+	ctx, cancel := r.ctx()
+	defer cancel()
+	all, err := r.loadAll(ctx, r.setKey())
+	if err != nil {
+		r.logError(err, "idle")
+		return nil
+	}
+	var list []Session
+	for _, s := range all {
+		if d := idle(s.NasIP); d > 0 && now.Sub(s.LastSeen) > d {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+func (r *RedisSessions) ByUsername(username string) []Session {
+	ctx, cancel := r.ctx()
+	defer cancel()
+	all, err := r.loadAll(ctx, r.userKey(username))
+	if err != nil {
+		r.logError(err, "by_username")
+		return nil
+	}
+	var list []Session
+	for _, s := range all {
+		if s.Username == username {
+			list = append(list, s)
+		} else {
+			// IP已被其他用户使用
+			r.rdb.SRem(ctx, r.userKey(username), s.UserIP.String())
+		}
+	}
+	return list
+}
+
+func (r *RedisSessions) Len() int {
+	ctx, cancel := r.ctx()
+	defer cancel()
+	n, err := r.rdb.SCard(ctx, r.setKey()).Result()
+	if err != nil {
+		r.logError(err, "len")
+	}
+	return int(n)
+}
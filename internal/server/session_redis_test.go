@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// 设置 SYLER_TEST_REDIS=host:port 时运行
+func testRedis(t *testing.T) *redis.Client {
+	addr := os.Getenv("SYLER_TEST_REDIS")
+	if addr == "" {
+		t.Skip("SYLER_TEST_REDIS not set")
+	}
+	rdb := redis.NewClient(&redis.Options{Addr: addr})
+	if err := rdb.Ping(context.Background()).Err(); err != nil {
+		t.Skip(err)
+	}
+	return rdb
+}
+
+func TestRedisSessions(t *testing.T) {
+	rdb := testRedis(t)
+	prefix := "syler-test:" + time.Now().Format("150405.000") + ":"
+	var store SessionStore = NewRedisSessions(rdb, prefix)
+	other := NewRedisSessions(rdb, prefix) // 模拟另一个节点
+
+	userip, newip := net.IPv4(10, 1, 0, 1).To4(), net.IPv4(10, 1, 0, 2).To4()
+	nasip := net.IPv4(192, 168, 0, 1).To4()
+	store.Add(&Session{Username: "user", UserIP: userip, NasIP: nasip, LastSeen: time.Now().Add(-time.Hour)})
+
+	if s, ok := other.Get(userip); !ok || s.Username != "user" {
+		t.Fatalf("session not shared: %+v", s)
+	}
+	if ttl := rdb.TTL(context.Background(), prefix+"session:"+userip.String()).Val(); ttl <= 0 {
+		t.Errorf("session key without TTL: %v", ttl)
+	}
+	idle := func(net.IP) time.Duration { return time.Minute }
+	if n := len(other.Idle(time.Now(), idle)); n != 1 {
+		t.Errorf("idle sessions: %d", n)
+	}
+	if !other.Touch(userip) || len(store.Idle(time.Now(), idle)) != 0 {
+		t.Error("heartbeat on one node not visible on the other")
+	}
+	// 会话被其他节点删除后，Touch不会把它写回
+	store.Add(&Session{Username: "gone", UserIP: net.IPv4(10, 1, 0, 9).To4(), NasIP: nasip})
+	other.Remove(net.IPv4(10, 1, 0, 9).To4())
+	if store.Touch(net.IPv4(10, 1, 0, 9).To4()) {
+		t.Error("Touch revived a removed session")
+	}
+	if _, ok := other.Get(net.IPv4(10, 1, 0, 9).To4()); ok {
+		t.Error("removed session visible again")
+	}
+	if !store.SetMAC(userip, "AA-BB-CC-00-11-22") {
+		t.Error("SetMAC failed")
+	}
+	if !other.Rekey(userip, newip) {
+		t.Error("Rekey failed")
+	}
+	if s, ok := store.ByMAC("aa:bb:cc:00:11:22"); !ok || !s.UserIP.Equal(newip) {
+		t.Errorf("ByMAC after rekey: %+v", s)
+	}
+	if store.Len() != 1 {
+		t.Errorf("Len = %d", store.Len())
+	}
+	if list := other.ByUsername("user"); len(list) != 1 || !list[0].UserIP.Equal(newip) {
+		t.Errorf("ByUsername after rekey: %+v", list)
+	}
+
+	// 只有一个节点能删除成功
+	if store.Remove(newip) == nil || other.Remove(newip) != nil {
+		t.Error("session removed twice")
+	}
+	if _, ok := other.Get(newip); ok || store.Len() != 0 {
+		t.Error("session still present")
+	}
+}
+
+func TestSessionTTL(t *testing.T) {
+	now := time.Now()
+	nasip := net.IPv4(192, 168, 0, 99).To4()
+	cases := []struct {
+		deadline time.Time
+		want     time.Duration
+	}{
+		{time.Time{}, sessionMaxTTL},
+		{now.Add(time.Hour), time.Hour + sessionTTLSlack},
+		{now.Add(-time.Hour), sessionTTLSlack},
+	}
+	for _, c := range cases {
+		s := &Session{NasIP: nasip, LastSeen: now, Deadline: c.deadline}
+		if got := sessionTTL(s, now); got != c.want {
+			t.Errorf("deadline %v: ttl %v, want %v", c.deadline, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"syler/internal/logger"
+	"syler/internal/portal"
+	"syler/internal/web"
+)
+
+// fluxCacheTTL 同一会话的流量查询结果缓存的时长，页面轮询/api/status时不必每次向NAS发送REQ_INFO
+var fluxCacheTTL = 10 * time.Second
+
+// fluxQueryTimeout 状态查询等待NAS应答流量的最长时间
+var fluxQueryTimeout = 2 * time.Second
+
+type fluxEntry struct {
+	up, down uint64
+	at       time.Time
+}
+
+// fluxCache 按会话缓存NAS统计的流量，用户重新登录后不使用旧会话的结果
+type fluxCache struct {
+	lock    sync.Mutex
+	entries map[string]fluxEntry
+}
+
+var fluxes = &fluxCache{entries: make(map[string]fluxEntry)}
+
+func fluxKey(s Session) string {
+	return s.UserIP.String() + "@" + strconv.FormatInt(s.LoginAt.UnixNano(), 10)
+}
+
+func (c *fluxCache) get(s Session, now time.Time) (fluxEntry, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	e, ok := c.entries[fluxKey(s)]
+	if !ok || now.Sub(e.at) >= fluxCacheTTL {
+		return fluxEntry{}, false
+	}
+	return e, true
+}
+
+// put 保存查询结果，同时清理过期的条目
+func (c *fluxCache) put(s Session, e fluxEntry) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for k, old := range c.entries {
+		if e.at.Sub(old.at) >= fluxCacheTTL {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[fluxKey(s)] = e
+}
+
+// flux 返回会话的上下行流量，缓存过期时向NAS查询，查询受请求的ctx约束
+func flux(ctx context.Context, s Session) (up, down uint64, err error) {
+	now := time.Now()
+	if e, ok := fluxes.get(s, now); ok {
+		return e.up, e.down, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, fluxQueryTimeout)
+	defer cancel()
+	up, down, err = Info(ctx, s.UserIP, s.NasIP)
+	if err != nil {
+		return 0, 0, err
+	}
+	fluxes.put(s, fluxEntry{up: up, down: down, at: now})
+	return up, down, nil
+}
+
+// formatDuration 将时长格式化为“1天2小时3分钟”
+func formatDuration(d time.Duration) string {
+	if d < time.Minute {
+		return "不足1分钟"
+	}
+	var b strings.Builder
+	if days := d / (24 * time.Hour); days > 0 {
+		fmt.Fprintf(&b, "%d天", days)
+	}
+	if hours := d % (24 * time.Hour) / time.Hour; hours > 0 {
+		fmt.Fprintf(&b, "%d小时", hours)
+	}
+	if minutes := d % time.Hour / time.Minute; minutes > 0 {
+		fmt.Fprintf(&b, "%d分钟", minutes)
+	}
+	return b.String()
+}
+
+// HandleStatus 返回用户的在线状态，供页面判断显示登录还是登出界面。用户由userip参数或来源地址确定，
+// 只有会话本人（来源地址、登录令牌或登录时的浏览器）能看到登录时间、有效期和流量。
+// 页面没有会话Cookie时同时签发Cookie，并返回提交表单所需的CSRF令牌
+func (a *Authenticator) HandleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		handleResponse(w, http.StatusMethodNotAllowed, Response{
+			Message: "仅支持GET请求",
+		})
+		return
+	}
+
+	userip := net.ParseIP(r.FormValue("userip"))
+	if userip == nil {
+		userip = sourceIP(r)
+	}
+	data := map[string]interface{}{
+		"online":     false,
+		"csrf_token": web.CSRFToken(web.Session(w, r)),
+	}
+
+	log := logger.WithRequest(r).WithField("user_ip", userip.String())
+	if s, ok := Sessions.Get(userip); ok && ownsSession(r, s) {
+		a.sessionInfo(r, s, data)
+	}
+	log.WithField("online", data["online"]).Debug("Status query")
+
+	handleResponse(w, http.StatusOK, Response{
+		Message: "ok",
+		Data:    data,
+	})
+}
+
+// sessionInfo 填充会话的登录时间、有效期和NAS统计的流量
+func (a *Authenticator) sessionInfo(r *http.Request, s Session, data map[string]interface{}) {
+	now := time.Now()
+	online := now.Sub(s.LoginAt)
+	data["online"] = true
+	data["username"] = s.Username
+	data["userip"] = s.UserIP.String()
+	data["nasip"] = s.NasIP.String()
+	data["usermac"] = s.UserMAC
+	data["method"] = s.Method
+	data["login_at"] = s.LoginAt
+	data["online_seconds"] = int(online.Seconds())
+	data["online_time"] = formatDuration(online)
+
+	// 绑定MAC的用户在有效期内可免密重新认证
+	if s.UserMAC != "" {
+		remaining := s.LoginAt.Add(MacSessionExpire).Sub(now)
+		if remaining < 0 {
+			remaining = 0
+		}
+		data["valid_until"] = s.LoginAt.Add(MacSessionExpire)
+		data["remaining_seconds"] = int(remaining.Seconds())
+		data["timeout"] = formatDuration(remaining)
+	}
+	// 访问策略要求的下线时间
+	if !s.Deadline.IsZero() {
+		left := s.Deadline.Sub(now)
+		if left < 0 {
+			left = 0
+		}
+		data["deadline"] = s.Deadline
+		data["deadline_seconds"] = int(left.Seconds())
+	}
+	if idle := portal.NasFor(s.NasIP).IdleTimeout; idle > 0 {
+		data["idle_timeout"] = int(idle.Seconds())
+	}
+
+	up, down, err := flux(r.Context(), s)
+	if err != nil {
+		logger.WithRequest(r).WithFields(logrus.Fields{
+			"user_ip": s.UserIP.String(),
+			"nas_ip":  s.NasIP.String(),
+			"error":   err,
+		}).Warn("Failed to query user flux")
+		return
+	}
+	data["up_flux"] = up
+	data["down_flux"] = down
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"syler/internal/portal"
+	"syler/internal/web"
+)
+
+func TestStatusAndCSRF(t *testing.T) {
+	a := &Authenticator{}
+	nas := startTestNas(t, 2)
+	defer nas.Close()
+	userip := net.ParseIP("10.0.0.20")
+	if err := Auth(context.Background(), userip, nas.Addr().IP, []byte("user"), []byte("pwd")); err != nil {
+		t.Fatal(err)
+	}
+	Sessions.Add(&Session{
+		Username: "user",
+		UserIP:   userip,
+		UserMAC:  "aa:bb:cc:dd:ee:ff",
+		NasIP:    nas.Addr().IP,
+		LoginAt:  time.Now().Add(-90 * time.Minute),
+		Method:   "chap",
+	})
+	defer Sessions.Remove(userip)
+
+	status := func(remote string, cookies ...*http.Cookie) (*httptest.ResponseRecorder, map[string]interface{}) {
+		r := httptest.NewRequest(http.MethodGet, "/api/status?userip=10.0.0.20", nil)
+		r.RemoteAddr = remote
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		a.HandleStatus(w, r)
+		var resp struct {
+			Data map[string]interface{} `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w, resp.Data
+	}
+
+	w, data := status("10.0.0.20:5000")
+	if data["online"] != true || data["username"] != "user" || data["method"] != "chap" {
+		t.Errorf("status from user IP: %v", data)
+	}
+	if data["online_time"] != "1小时30分钟" || data["remaining_seconds"] == nil {
+		t.Errorf("online time or validity missing: %v", data)
+	}
+	// 模拟NAS返回的上下行流量均为1024
+	if data["up_flux"] != float64(1024) || data["down_flux"] != float64(1024) {
+		t.Errorf("flux: up=%v down=%v", data["up_flux"], data["down_flux"])
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != web.CookieName || !cookies[0].HttpOnly {
+		t.Fatalf("session cookie not issued: %v", cookies)
+	}
+	if _, data := status("10.0.0.99:5000", cookies...); data["online"] != false || data["username"] != nil {
+		t.Errorf("status leaked to another client: %v", data)
+	}
+
+	viper.Set("http.csrf", true)
+	defer viper.Set("http.csrf", nil)
+	called := false
+	h := csrfProtect(func(w http.ResponseWriter, r *http.Request) { called = true })
+	post := func(token string, cookies ...*http.Cookie) int {
+		r := postForm("/api/logout", url.Values{"userip": {"10.0.0.20"}}, "10.0.0.20:5000")
+		if token != "" {
+			r.Header.Set(web.CSRFHeader, token)
+		}
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w.Code
+	}
+	if code := post(data["csrf_token"].(string)); code != http.StatusForbidden || called {
+		t.Errorf("token without cookie: %d", code)
+	}
+	if code := post("forged", cookies...); code != http.StatusForbidden || called {
+		t.Errorf("forged token: %d", code)
+	}
+	post(data["csrf_token"].(string), cookies...)
+	if !called {
+		t.Error("valid CSRF token rejected")
+	}
+}
+
+func TestStatusFluxCache(t *testing.T) {
+	nas := startTestNas(t, 2)
+	defer nas.Close()
+	s := Session{Username: "user", UserIP: net.ParseIP("10.0.0.21"), NasIP: nas.Addr().IP, LoginAt: time.Now()}
+	if err := Auth(context.Background(), s.UserIP, s.NasIP, []byte("user"), []byte("pwd")); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if up, down, err := flux(context.Background(), s); err != nil || up != 1024 || down != 1024 {
+			t.Fatalf("flux: %d %d %v", up, down, err)
+		}
+	}
+	queries := 0
+	for _, m := range nas.Received() {
+		if m.Type() == portal.REQ_INFO {
+			queries++
+		}
+	}
+	if queries != 1 {
+		t.Errorf("sent %d REQ_INFO for repeated status queries, want 1", queries)
+	}
+
+	// NAS不应答时按ctx放弃等待，不等到重传结束
+	silent, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 3)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	portal.RegisterNas(portal.Nas{IP: net.IPv4(127, 0, 0, 3), Port: silent.LocalAddr().(*net.UDPAddr).Port, Secret: "secret", Retries: 2, RetryInterval: time.Second})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, _, err := Info(ctx, s.UserIP, net.IPv4(127, 0, 0, 3)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("Info ignored ctx, returned after %v", d)
+	}
+}
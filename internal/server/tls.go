@@ -0,0 +1,230 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	"syler/internal/logger"
+)
+
+// TLSConfig HTTPS配置，读取自http.tls
+type TLSConfig struct {
+	Enabled        bool          `mapstructure:"enabled"`
+	CertFile       string        `mapstructure:"cert_file"`
+	KeyFile        string        `mapstructure:"key_file"`
+	MinVersion     string        `mapstructure:"min_version"` // 1.0、1.1、1.2、1.3，默认1.2
+	MaxVersion     string        `mapstructure:"max_version"` // 为空时不限制
+	CipherSuites   []string      `mapstructure:"cipher_suites"`
+	ReloadInterval time.Duration `mapstructure:"reload_interval"` // 检查证书文件变化的间隔
+	RedirectPort   int           `mapstructure:"redirect_port"`   // HTTP跳转HTTPS的监听端口，0表示不监听
+	RedirectHost   string        `mapstructure:"redirect_host"`   // 跳转的目标主机名，与证书一致；为空时使用web.portal_url中的主机
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+func parseTLSVersion(v string, def uint16) (uint16, error) {
+	if v == "" {
+		return def, nil
+	}
+	if version, ok := tlsVersions[v]; ok {
+		return version, nil
+	}
+	return 0, fmt.Errorf("unknown TLS version %q", v)
+}
+
+// parseCipherSuites 按名称（如 TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256）选择加密套件，
+// 只允许Go认为安全的套件。TLS 1.3的套件不可配置
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	known := make(map[string]uint16)
+	for _, c := range tls.CipherSuites() {
+		known[c.Name] = c.ID
+	}
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// certReloader 证书文件修改后自动重新加载，替换证书无需重启
+type certReloader struct {
+	certFile, keyFile string
+
+	lock    sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// latestMod 证书和私钥文件中较新的修改时间
+func (c *certReloader) latestMod() (time.Time, error) {
+	var latest time.Time
+	for _, f := range []string{c.certFile, c.keyFile} {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (c *certReloader) reload() error {
+	mod, err := c.latestMod()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	c.lock.Lock()
+	c.cert, c.modTime = &cert, mod
+	c.lock.Unlock()
+	return nil
+}
+
+// check 文件有变化时重新加载，新证书无效时继续使用旧证书
+func (c *certReloader) check() {
+	log := logger.Subsystem(logger.HTTP).WithField("cert_file", c.certFile)
+	mod, err := c.latestMod()
+	if err != nil {
+		log.WithField("error", err).Warn("Failed to stat TLS certificate")
+		return
+	}
+	c.lock.RLock()
+	changed := !mod.Equal(c.modTime)
+	c.lock.RUnlock()
+	if !changed {
+		return
+	}
+	if err := c.reload(); err != nil {
+		log.WithField("error", err).Error("Failed to reload TLS certificate, keeping the previous one")
+		return
+	}
+	log.Info("Reloaded TLS certificate")
+}
+
+// watch 定期检查证书文件
+func (c *certReloader) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		c.check()
+	}
+}
+
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.cert, nil
+}
+
+// newTLSConfig 根据配置创建tls.Config，并返回证书加载器
+func newTLSConfig(cfg TLSConfig) (*tls.Config, *certReloader, error) {
+	minVersion, err := parseTLSVersion(cfg.MinVersion, tls.VersionTLS12)
+	if err != nil {
+		return nil, nil, err
+	}
+	maxVersion, err := parseTLSVersion(cfg.MaxVersion, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	suites, err := parseCipherSuites(cfg.CipherSuites)
+	if err != nil {
+		return nil, nil, err
+	}
+	certs, err := newCertReloader(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &tls.Config{
+		MinVersion:     minVersion,
+		MaxVersion:     maxVersion,
+		CipherSuites:   suites,
+		GetCertificate: certs.GetCertificate,
+	}, certs, nil
+}
+
+// redirectHost 跳转的目标主机，未配置redirect_host时取web.portal_url中的主机
+func redirectHost(cfg TLSConfig) string {
+	if cfg.RedirectHost != "" {
+		return cfg.RedirectHost
+	}
+	if u, err := url.Parse(viper.GetString("web.portal_url")); err == nil {
+		return u.Hostname()
+	}
+	return ""
+}
+
+// httpsRedirect HTTP请求跳转到配置的Portal主机的HTTPS端口。目标主机不取自请求的Host头，
+// 避免被伪造的Host把用户引到其他站点；使用临时跳转，浏览器不会缓存。
+// 联网检测请求仍在HTTP上应答，系统的检测逻辑不跟随跳转到HTTPS
+func httpsRedirect(host string, httpsPort int) http.HandlerFunc {
+	if httpsPort != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if AuthHandler.HandleProbe(w, r) {
+			return
+		}
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusTemporaryRedirect)
+	}
+}
+
+// startRedirect 监听HTTP端口，将请求跳转到HTTPS
+func startRedirect(host string, port int, target string, httpsPort int) {
+	log := logger.Subsystem(logger.HTTP)
+	if target == "" {
+		log.Error("HTTP redirect port set without http.tls.redirect_host or an absolute web.portal_url, not starting redirect server")
+		return
+	}
+	server := &http.Server{
+		Addr:              fmt.Sprintf("%s:%d", host, port),
+		Handler:           requestID(httpsRedirect(target, httpsPort)),
+		ReadTimeout:       5 * time.Second,
+		WriteTimeout:      10 * time.Second,
+		IdleTimeout:       120 * time.Second,
+		ReadHeaderTimeout: 2 * time.Second,
+	}
+	log.WithFields(logrus.Fields{
+		"host":   host,
+		"port":   port,
+		"target": target,
+	}).Info("Starting HTTP to HTTPS redirect server")
+	if err := server.ListenAndServe(); err != nil {
+		log.WithField("error", err).Fatal("Failed to start HTTP redirect server")
+	}
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// writeCert 生成自签名证书写入cert和key文件
+func writeCert(t *testing.T, certFile, keyFile, cn string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+}
+
+func servedCN(t *testing.T, c *certReloader) string {
+	t.Helper()
+	cert, _ := c.GetCertificate(nil)
+	x, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return x.Subject.CommonName
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCert(t, certFile, keyFile, "old")
+
+	config, certs, err := newTLSConfig(TLSConfig{
+		CertFile:     certFile,
+		KeyFile:      keyFile,
+		CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.MinVersion != tls.VersionTLS12 || len(config.CipherSuites) != 1 {
+		t.Errorf("config: min %x suites %v", config.MinVersion, config.CipherSuites)
+	}
+	if _, _, err := newTLSConfig(TLSConfig{CertFile: certFile, KeyFile: keyFile, MinVersion: "1.4"}); err == nil {
+		t.Error("unknown version accepted")
+	}
+	if _, _, err := newTLSConfig(TLSConfig{CertFile: certFile, KeyFile: keyFile, CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}}); err == nil {
+		t.Error("insecure cipher suite accepted")
+	}
+
+	// 写入无效证书时继续使用旧证书
+	os.WriteFile(certFile, []byte("broken"), 0o600)
+	later := time.Now().Add(time.Second)
+	os.Chtimes(certFile, later, later)
+	certs.check()
+	if cn := servedCN(t, certs); cn != "old" {
+		t.Errorf("broken certificate replaced the old one: %s", cn)
+	}
+
+	writeCert(t, certFile, keyFile, "new")
+	later = later.Add(time.Second)
+	os.Chtimes(certFile, later, later)
+	certs.check()
+	if cn := servedCN(t, certs); cn != "new" {
+		t.Errorf("certificate not reloaded: %s", cn)
+	}
+}
+
+func TestHTTPSRedirect(t *testing.T) {
+	cases := []struct {
+		port   int
+		target string
+		want   string
+	}{
+		{443, "http://portal.example.com/portal?userip=10.0.0.8", "https://portal.example.com/portal?userip=10.0.0.8"},
+		{8443, "http://portal.example.com:8080/", "https://portal.example.com:8443/"},
+		// 请求的Host头不影响跳转目标
+		{443, "http://evil.example.net/portal", "https://portal.example.com/portal"},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		httpsRedirect("portal.example.com", c.port)(w, httptest.NewRequest(http.MethodGet, c.target, nil))
+		if w.Code != http.StatusTemporaryRedirect || w.Header().Get("Location") != c.want {
+			t.Errorf("%s: %d %s", c.target, w.Code, w.Header().Get("Location"))
+		}
+	}
+
+	defer viper.Set("web.portal_url", viper.Get("web.portal_url"))
+	viper.Set("web.portal_url", "https://wifi.example.com/portal")
+	if h := redirectHost(TLSConfig{}); h != "wifi.example.com" {
+		t.Errorf("host from portal_url: %q", h)
+	}
+	if h := redirectHost(TLSConfig{RedirectHost: "portal.example.com"}); h != "portal.example.com" {
+		t.Errorf("configured host: %q", h)
+	}
+	viper.Set("web.portal_url", "/portal")
+	if h := redirectHost(TLSConfig{}); h != "" {
+		t.Errorf("relative portal_url: %q", h)
+	}
+}
@@ -0,0 +1,114 @@
+package simulator
+
+import (
+	"bytes"
+
+	"syler/internal/portal"
+	v1 "syler/internal/portal/v1"
+	v2 "syler/internal/portal/v2"
+)
+
+type attr struct {
+	typ byte
+	val []byte
+}
+
+// codec 屏蔽Portal 1.0和2.0报文结构的差异
+type codec interface {
+	portal.Version
+	// verify 校验Portal Server发来的请求报文的Authenticator
+	verify(req portal.Message, secret string) bool
+	// response 构造对req的应答报文
+	response(req portal.Message, typ byte, errCode byte, reqId uint16, attrs []attr, secret string) portal.Message
+	// notify 构造NAS主动发送的报文
+	notify(typ byte, userip []byte, serial uint16, attrs []attr, secret string) portal.Message
+	pap(m portal.Message) bool
+}
+
+type codecV1 struct{ v1.Version }
+
+func (c *codecV1) verify(portal.Message, string) bool {
+	return true
+}
+
+func (c *codecV1) response(req portal.Message, typ byte, errCode byte, reqId uint16, attrs []attr, secret string) portal.Message {
+	r := req.(*v1.T_Message)
+	msg := &v1.T_Message{Header: r.Header}
+	msg.Header.Type = typ
+	msg.Header.ErrCode = errCode
+	msg.Header.ReqIdentifier = reqId
+	msg.Header.AttrNum = byte(len(attrs))
+	msg.Attrs = v1Attrs(attrs)
+	return msg
+}
+
+func (c *codecV1) notify(typ byte, userip []byte, serial uint16, attrs []attr, secret string) portal.Message {
+	msg := &v1.T_Message{}
+	msg.Header.Version = 0x01
+	msg.Header.Type = typ
+	msg.Header.SerialNo = serial
+	msg.Header.UserIp = userip
+	msg.Header.AttrNum = byte(len(attrs))
+	msg.Attrs = v1Attrs(attrs)
+	return msg
+}
+
+func (c *codecV1) pap(m portal.Message) bool {
+	return m.(*v1.T_Message).Header.Pap == 1
+}
+
+func v1Attrs(attrs []attr) []v1.T_Attr {
+	res := make([]v1.T_Attr, len(attrs))
+	for i, a := range attrs {
+		res[i] = v1.T_Attr{AttrType: a.typ, AttrLen: byte(len(a.val)), AttrStr: a.val}
+	}
+	return res
+}
+
+type codecV2 struct{ v2.Version }
+
+func (c *codecV2) verify(req portal.Message, secret string) bool {
+	r := *req.(*v2.T_Message)
+	wanted := r.Header.Authenticator
+	r.Header.Authenticator = make([]byte, 16)
+	r.AuthBy(secret)
+	return bytes.Equal(wanted, r.Header.Authenticator)
+}
+
+func (c *codecV2) response(req portal.Message, typ byte, errCode byte, reqId uint16, attrs []attr, secret string) portal.Message {
+	r := req.(*v2.T_Message)
+	msg := &v2.T_Message{Header: r.Header}
+	msg.Header.Type = typ
+	msg.Header.ErrCode = errCode
+	msg.Header.ReqIdentifier = reqId
+	msg.Header.AttrNum = byte(len(attrs))
+	msg.Attrs = v2Attrs(attrs)
+	// 应答报文的Authenticator以请求报文的Authenticator计算
+	msg.AuthBy(secret)
+	return msg
+}
+
+func (c *codecV2) notify(typ byte, userip []byte, serial uint16, attrs []attr, secret string) portal.Message {
+	msg := &v2.T_Message{}
+	msg.Header.Version = 0x02
+	msg.Header.Type = typ
+	msg.Header.SerialNo = serial
+	msg.Header.UserIp = userip
+	msg.Header.AttrNum = byte(len(attrs))
+	msg.Header.Authenticator = make([]byte, 16)
+	msg.Attrs = v2Attrs(attrs)
+	msg.AuthBy(secret)
+	return msg
+}
+
+func (c *codecV2) pap(m portal.Message) bool {
+	return m.(*v2.T_Message).Header.Pap == 1
+}
+
+func v2Attrs(attrs []attr) []v2.T_Attr {
+	res := make([]v2.T_Attr, len(attrs))
+	for i, a := range attrs {
+		res[i] = v2.T_Attr{AttrType: a.typ, AttrLen: byte(len(a.val)), AttrStr: a.val}
+	}
+	return res
+}
@@ -0,0 +1,346 @@
+// Package simulator 实现一个软件NAS/BAS，按华为Portal 1.0/2.0协议应答
+// Portal Server的请求，用于在没有交换机的环境下做端到端测试
+package simulator
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"syler/internal/portal"
+)
+
+// Config 模拟NAS的配置
+type Config struct {
+	Addr    string            // 监听地址，如 127.0.0.1:2000
+	Version int               // Portal协议版本 1 或 2
+	Secret  string            // 共享密钥
+	Users   map[string]string // 用户名 -> 密码，为空时接受任意用户
+}
+
+// Fault 对某类请求注入的故障
+type Fault struct {
+	ErrCode  byte          // 应答报文中的错误码
+	TextInfo string        // 应答中携带的TextInfo属性
+	Delay    time.Duration // 应答前的延迟
+	Drop     int           // 丢弃接下来的N个请求，小于0时丢弃全部
+}
+
+type pending struct {
+	reqId     uint16
+	challenge []byte
+}
+
+// Session 模拟NAS上的在线用户
+type Session struct {
+	UserIP   string
+	Username string
+	LoginAt  time.Time
+}
+
+type Nas struct {
+	cfg    Config
+	codec  codec
+	conn   *net.UDPConn
+	portal *net.UDPAddr // 最近一次请求的来源，作为主动报文的目的地址
+
+	mu       sync.Mutex
+	reqId    uint16
+	serial   uint16
+	faults   map[byte]*Fault
+	pending  map[string]pending
+	online   map[string]Session
+	received []portal.Message
+	badAuth  int
+}
+
+func New(cfg Config) *Nas {
+	n := &Nas{
+		cfg:     cfg,
+		faults:  make(map[byte]*Fault),
+		pending: make(map[string]pending),
+		online:  make(map[string]Session),
+	}
+	if cfg.Version == 1 {
+		n.codec = new(codecV1)
+	} else {
+		n.codec = new(codecV2)
+	}
+	return n
+}
+
+// Start 监听UDP端口并在后台处理请求
+func (n *Nas) Start() error {
+	addr, err := net.ResolveUDPAddr("udp", n.cfg.Addr)
+	if err != nil {
+		return err
+	}
+	n.conn, err = net.ListenUDP("udp", addr)
+	if err != nil {
+		return err
+	}
+	go n.serve()
+	return nil
+}
+
+func (n *Nas) Addr() *net.UDPAddr {
+	return n.conn.LocalAddr().(*net.UDPAddr)
+}
+
+func (n *Nas) Close() error {
+	return n.conn.Close()
+}
+
+// Inject 为某类请求设置故障，fault为nil时清除
+func (n *Nas) Inject(reqType byte, fault *Fault) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if fault == nil {
+		delete(n.faults, reqType)
+		return
+	}
+	f := *fault
+	n.faults[reqType] = &f
+}
+
+// Received 返回收到的所有报文
+func (n *Nas) Received() []portal.Message {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]portal.Message(nil), n.received...)
+}
+
+// BadAuthenticators 返回Authenticator校验失败而被丢弃的报文数
+func (n *Nas) BadAuthenticators() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.badAuth
+}
+
+// Sessions 返回在线用户
+func (n *Nas) Sessions() []Session {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	res := make([]Session, 0, len(n.online))
+	for _, s := range n.online {
+		res = append(res, s)
+	}
+	return res
+}
+
+// Online 判断用户是否在线
+func (n *Nas) Online(userip net.IP) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	_, ok := n.online[userip.String()]
+	return ok
+}
+
+// NotifyLogout 向Portal Server发送NTF_LOGOUT，dest为空时发往最近一次请求的来源
+func (n *Nas) NotifyLogout(userip net.IP, dest *net.UDPAddr) error {
+	n.mu.Lock()
+	delete(n.online, userip.String())
+	n.mu.Unlock()
+	return n.send(portal.NTF_LOGOUT, userip, nil, dest)
+}
+
+// Heartbeat 模拟H3C设备发送NTF_USER_HEARTBEAT，在User-List属性中通告所有在线用户
+func (n *Nas) Heartbeat(dest *net.UDPAddr) error {
+	n.mu.Lock()
+	var users []byte
+	for ip := range n.online {
+		users = append(users, net.ParseIP(ip).To4()...)
+	}
+	n.mu.Unlock()
+	return n.send(portal.NTF_USER_HEARTBEAT, net.IPv4zero, []attr{{portal.ATTR_USERLIST, users}}, dest)
+}
+
+// Discover 发送NTF_USERDISCOVERY，通告新发现用户的IP和MAC
+func (n *Nas) Discover(userip net.IP, mac net.HardwareAddr, dest *net.UDPAddr) error {
+	return n.send(portal.NTF_USERDISCOVERY, userip, []attr{{portal.ATTR_SESSIONID, mac}}, dest)
+}
+
+// ChangeIP 在线用户的IP由old变为userip，发送NTF_USERIPCHANGE
+func (n *Nas) ChangeIP(old, userip net.IP, mac net.HardwareAddr, dest *net.UDPAddr) error {
+	n.mu.Lock()
+	if s, ok := n.online[old.String()]; ok {
+		delete(n.online, old.String())
+		s.UserIP = userip.String()
+		n.online[s.UserIP] = s
+	}
+	n.mu.Unlock()
+	return n.send(portal.NTF_USERIPCHANGE, userip, []attr{{portal.ATTR_SESSIONID, mac}}, dest)
+}
+
+// QueryMAC 发送REQ_MACINFO查询MAC绑定的用户，应答记录在Received中
+func (n *Nas) QueryMAC(userip net.IP, mac net.HardwareAddr, dest *net.UDPAddr) error {
+	return n.send(portal.REQ_MACINFO, userip, []attr{{portal.ATTR_SESSIONID, mac}}, dest)
+}
+
+// send 发送NAS主动发起的报文，dest为空时发往最近一次通信的Portal Server
+func (n *Nas) send(typ byte, userip net.IP, attrs []attr, dest *net.UDPAddr) error {
+	n.mu.Lock()
+	if dest == nil {
+		dest = n.portal
+	}
+	n.serial++
+	serial := n.serial
+	n.mu.Unlock()
+	if dest == nil {
+		return fmt.Errorf("no portal server address known")
+	}
+	msg := n.codec.notify(typ, userip.To4(), serial, attrs, n.cfg.Secret)
+	_, err := n.conn.WriteToUDP(msg.Bytes(), dest)
+	return err
+}
+
+func (n *Nas) serve() {
+	buf := make([]byte, 4096)
+	for {
+		size, addr, err := n.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		data := make([]byte, size)
+		copy(data, buf[:size])
+		go n.handle(data, addr)
+	}
+}
+
+func (n *Nas) handle(data []byte, src *net.UDPAddr) {
+	req := n.codec.Unmarshall(data)
+
+	n.mu.Lock()
+	n.portal = src
+	n.received = append(n.received, req)
+	if !n.codec.verify(req, n.cfg.Secret) {
+		n.badAuth++
+		n.mu.Unlock()
+		return
+	}
+	fault, hasFault := n.faults[req.Type()]
+	if hasFault && fault.Drop != 0 {
+		if fault.Drop > 0 {
+			fault.Drop--
+		}
+		n.mu.Unlock()
+		return
+	}
+	n.mu.Unlock()
+
+	res := n.process(req)
+	if res == nil {
+		return
+	}
+	if hasFault {
+		time.Sleep(fault.Delay)
+		if fault.ErrCode != 0 || fault.TextInfo != "" {
+			var attrs []attr
+			if fault.TextInfo != "" {
+				attrs = append(attrs, attr{portal.ATTR_TEXTINFO, []byte(fault.TextInfo)})
+			}
+			res = n.codec.response(req, res.Type(), fault.ErrCode, res.ReqId(), attrs, n.cfg.Secret)
+		}
+	}
+	n.conn.WriteToUDP(res.Bytes(), src)
+}
+
+// process 按协议处理请求，返回应答报文，无需应答时返回nil
+func (n *Nas) process(req portal.Message) portal.Message {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	userip := req.UserIp().String()
+	switch req.Type() {
+	case portal.REQ_CHALLENGE:
+		if _, ok := n.online[userip]; ok {
+			return n.codec.response(req, portal.ACK_CHALLENGE, 2, 0, nil, n.cfg.Secret)
+		}
+		if _, ok := n.pending[userip]; ok {
+			return n.codec.response(req, portal.ACK_CHALLENGE, 3, 0, nil, n.cfg.Secret)
+		}
+		n.reqId++
+		p := pending{reqId: n.reqId, challenge: make([]byte, 16)}
+		rand.Read(p.challenge)
+		n.pending[userip] = p
+		return n.codec.response(req, portal.ACK_CHALLENGE, 0, p.reqId, []attr{{portal.ATTR_CHALLENGE, p.challenge}}, n.cfg.Secret)
+
+	case portal.REQ_AUTH:
+		if _, ok := n.online[userip]; ok {
+			return n.codec.response(req, portal.ACK_AUTH, 2, req.ReqId(), nil, n.cfg.Secret)
+		}
+		username, ok := n.authenticate(req, n.codec.pap(req))
+		delete(n.pending, userip)
+		if !ok {
+			return n.codec.response(req, portal.ACK_AUTH, 1, req.ReqId(), []attr{{portal.ATTR_TEXTINFO, []byte("认证失败")}}, n.cfg.Secret)
+		}
+		n.online[userip] = Session{UserIP: userip, Username: username, LoginAt: time.Now()}
+		return n.codec.response(req, portal.ACK_AUTH, 0, req.ReqId(), nil, n.cfg.Secret)
+
+	case portal.REQ_LOGOUT:
+		if req.ErrCode() == 1 {
+			// 超时下线报文，取消正在进行的认证，无需应答
+			delete(n.pending, userip)
+			delete(n.online, userip)
+			return nil
+		}
+		if _, ok := n.online[userip]; !ok {
+			return n.codec.response(req, portal.ACK_LOGOUT, 1, req.ReqId(), nil, n.cfg.Secret)
+		}
+		delete(n.online, userip)
+		return n.codec.response(req, portal.ACK_LOGOUT, 0, req.ReqId(), nil, n.cfg.Secret)
+
+	case portal.REQ_INFO:
+		if _, ok := n.online[userip]; !ok {
+			return n.codec.response(req, portal.ACK_INFO, 2, req.ReqId(), nil, n.cfg.Secret)
+		}
+		flux := make([]byte, 8)
+		binary.BigEndian.PutUint64(flux, 1024)
+		return n.codec.response(req, portal.ACK_INFO, 0, req.ReqId(), []attr{
+			{portal.ATTR_UPLINKFLUX, flux},
+			{portal.ATTR_DOWNLINKFLUX, flux},
+		}, n.cfg.Secret)
+	}
+	// AFF_ACK_AUTH、ACK_NTF_LOGOUT 等无需应答
+	return nil
+}
+
+// authenticate 校验REQ_AUTH中的CHAP或PAP密码
+func (n *Nas) authenticate(req portal.Message, pap bool) (string, bool) {
+	p, ok := n.pending[req.UserIp().String()]
+	if !pap && (!ok || p.reqId != req.ReqId()) {
+		return "", false
+	}
+	var username, password, chap []byte
+	for i := 0; i < req.AttributeLen(); i++ {
+		a := req.Attribute(i)
+		switch a.Type() {
+		case portal.ATTR_USERNAME:
+			username = a.Byte()
+		case portal.ATTR_PASSWORD:
+			password = a.Byte()
+		case portal.ATTR_CHAPPASSWORD:
+			chap = a.Byte()
+		}
+	}
+	if n.cfg.Users == nil {
+		return string(username), true
+	}
+	want, ok := n.cfg.Users[string(username)]
+	if !ok {
+		return "", false
+	}
+	if pap {
+		return string(username), want == string(password)
+	}
+	hash := md5.New()
+	hash.Write([]byte{byte(p.reqId)})
+	hash.Write([]byte(want))
+	hash.Write(p.challenge)
+	return string(username), bytes.Equal(hash.Sum(nil), chap)
+}
@@ -0,0 +1,154 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"syler/internal/logger"
+)
+
+// probeInterval 降级期间在后台探测主存储的周期
+const probeInterval = 5 * time.Second
+
+// Fallback 主存储不可用时降级使用本地存储：写入暂存到本地，读取在主存储查不到时再查本地。
+// 降级期间不再访问主存储，避免每个请求都等待连接超时，由后台定时Ping探测，恢复后切回主存储。
+// 集群中各节点的本地存储互不可见，降级期间的数据只在写入的节点有效
+type Fallback struct {
+	primary  Store
+	local    Store
+	degraded atomic.Bool
+	probing  atomic.Bool
+	lastErr  atomic.Value // 最近一次主存储错误，降级期间代替主存储返回
+	interval time.Duration
+	stop     chan struct{}
+	closed   sync.Once
+}
+
+func NewFallback(primary, local Store) *Fallback {
+	return &Fallback{primary: primary, local: local, interval: probeInterval, stop: make(chan struct{})}
+}
+
+// Primary 返回主存储，供必须与外部服务共享、不能降级到本地的数据使用
+func (f *Fallback) Primary() Store {
+	return f.primary
+}
+
+// Degraded 主存储是否处于不可用状态
+func (f *Fallback) Degraded() bool {
+	return f.degraded.Load()
+}
+
+func (f *Fallback) err() error {
+	if err, ok := f.lastErr.Load().(error); ok {
+		return err
+	}
+	return ErrUnavailable
+}
+
+func (f *Fallback) mark(err error) {
+	down := err != nil && !errors.Is(err, ErrNotFound)
+	if down {
+		f.lastErr.Store(err)
+	}
+	if down == f.degraded.Swap(down) {
+		return
+	}
+	log := logger.Subsystem(logger.HTTP)
+	if down {
+		log.WithField("error", err).Error("State store unavailable, using local memory")
+		if f.probing.CompareAndSwap(false, true) {
+			go f.probe()
+		}
+	} else {
+		log.Info("State store recovered")
+	}
+}
+
+// probe 降级期间定时Ping主存储，成功后结束降级
+func (f *Fallback) probe() {
+	defer f.probing.Store(false)
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+	for f.degraded.Load() {
+		select {
+		case <-f.stop:
+			return
+		case <-ticker.C:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		f.mark(f.primary.Ping(ctx))
+		cancel()
+	}
+}
+
+func (f *Fallback) Get(ctx context.Context, key string) (string, error) {
+	if f.degraded.Load() {
+		if v, err := f.local.Get(ctx, key); err == nil {
+			return v, nil
+		}
+		return "", f.err()
+	}
+	v, err := f.primary.Get(ctx, key)
+	f.mark(err)
+	if err == nil {
+		return v, nil
+	}
+	if lv, lerr := f.local.Get(ctx, key); lerr == nil {
+		return lv, nil
+	}
+	return "", err
+}
+
+func (f *Fallback) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	if f.degraded.Load() {
+		return f.local.Set(ctx, key, value, ttl)
+	}
+	err := f.primary.Set(ctx, key, value, ttl)
+	f.mark(err)
+	if err != nil {
+		return f.local.Set(ctx, key, value, ttl)
+	}
+	return nil
+}
+
+func (f *Fallback) Del(ctx context.Context, key string) error {
+	f.local.Del(ctx, key)
+	if f.degraded.Load() {
+		return f.err()
+	}
+	err := f.primary.Del(ctx, key)
+	f.mark(err)
+	return err
+}
+
+func (f *Fallback) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return f.IncrBy(ctx, key, 1, ttl)
+}
+
+func (f *Fallback) IncrBy(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error) {
+	if f.degraded.Load() {
+		return f.local.IncrBy(ctx, key, n, ttl)
+	}
+	v, err := f.primary.IncrBy(ctx, key, n, ttl)
+	f.mark(err)
+	if err != nil {
+		return f.local.IncrBy(ctx, key, n, ttl)
+	}
+	return v, nil
+}
+
+// Ping 总是访问主存储，健康检查可以借此及时发现恢复
+func (f *Fallback) Ping(ctx context.Context) error {
+	err := f.primary.Ping(ctx)
+	f.mark(err)
+	return err
+}
+
+func (f *Fallback) Close() error {
+	f.closed.Do(func() { close(f.stop) })
+	f.local.Close()
+	return f.primary.Close()
+}
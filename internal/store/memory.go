@@ -0,0 +1,200 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"syler/internal/logger"
+)
+
+// snapshotInterval 清理过期条目和写入快照的周期，进程崩溃时最多丢失这段时间内的修改
+const snapshotInterval = time.Second
+
+// Memory 本地内存存储，指定文件时定时把修改写入快照，关闭时再写一次，重启后恢复。适用于没有Redis的单机部署
+type Memory struct {
+	lock  sync.Mutex
+	items map[string]item
+	file  string
+	dirty bool
+
+	saving sync.Mutex // 串行化快照写入，写文件时不持有lock
+	stop   chan struct{}
+	done   chan struct{}
+	closed sync.Once
+}
+
+type item struct {
+	Value  string    `json:"value"`
+	Expire time.Time `json:"expire,omitempty"`
+}
+
+func (i item) expired(now time.Time) bool {
+	return !i.Expire.IsZero() && now.After(i.Expire)
+}
+
+func NewMemory() *Memory {
+	m := newMemory()
+	go m.run()
+	return m
+}
+
+func newMemory() *Memory {
+	return &Memory{items: make(map[string]item), stop: make(chan struct{}), done: make(chan struct{})}
+}
+
+// OpenFile 创建持久化到file的内存存储，文件存在时载入其中未过期的条目
+func OpenFile(file string) (*Memory, error) {
+	m := newMemory()
+	m.file = file
+	data, err := os.ReadFile(file)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &m.items); err != nil {
+			return nil, err
+		}
+	}
+	go m.run()
+	return m, nil
+}
+
+func (m *Memory) Get(ctx context.Context, key string) (string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	i, ok := m.items[key]
+	if !ok || i.expired(time.Now()) {
+		return "", ErrNotFound
+	}
+	return i.Value, nil
+}
+
+func (m *Memory) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	i := item{Value: value}
+	if ttl > 0 {
+		i.Expire = time.Now().Add(ttl)
+	}
+	m.items[key] = i
+	m.dirty = true
+	return nil
+}
+
+func (m *Memory) Del(ctx context.Context, key string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.items, key)
+	m.dirty = true
+	return nil
+}
+
+func (m *Memory) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return m.IncrBy(ctx, key, 1, ttl)
+}
+
+func (m *Memory) IncrBy(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var n int64
+	if i, ok := m.items[key]; ok && !i.expired(time.Now()) {
+		v, err := strconv.ParseInt(i.Value, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		n = v
+	}
+	n += delta
+	i := item{Value: strconv.FormatInt(n, 10)}
+	if ttl > 0 {
+		i.Expire = time.Now().Add(ttl)
+	}
+	m.items[key] = i
+	m.dirty = true
+	return n, nil
+}
+
+func (m *Memory) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Close 停止定时任务并写入最后一次快照
+func (m *Memory) Close() error {
+	m.closed.Do(func() {
+		close(m.stop)
+		<-m.done
+	})
+	return m.save()
+}
+
+func (m *Memory) run() {
+	defer close(m.done)
+	ticker := time.NewTicker(snapshotInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			if err := m.save(); err != nil {
+				logger.Subsystem(logger.HTTP).WithField("error", err).Error("Failed to save state snapshot")
+			}
+		}
+	}
+}
+
+// save 清理过期条目，有修改时写入临时文件后改名，避免写到一半时崩溃损坏快照
+func (m *Memory) save() error {
+	m.saving.Lock()
+	defer m.saving.Unlock()
+	m.lock.Lock()
+	now := time.Now()
+	for k, i := range m.items {
+		if i.expired(now) {
+			delete(m.items, k)
+		}
+	}
+	if m.file == "" || !m.dirty {
+		m.lock.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(m.items)
+	if err == nil {
+		m.dirty = false
+	}
+	m.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := m.write(data); err != nil {
+		m.lock.Lock()
+		m.dirty = true
+		m.lock.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (m *Memory) write(data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(m.file), filepath.Base(m.file)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), m.file)
+}
@@ -0,0 +1,136 @@
+// Package store 保存短信验证码、MAC绑定等带过期时间的状态，
+// 支持Redis（单机、哨兵、集群）和本地内存（可持久化到文件）两类后端
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var ErrNotFound = errors.New("key not found")
+
+// ErrUnavailable 主存储降级期间跳过访问时返回
+var ErrUnavailable = errors.New("state store unavailable")
+
+// Store 键值存储
+type Store interface {
+	// Get 键不存在或已过期时返回ErrNotFound
+	Get(ctx context.Context, key string) (string, error)
+	// Set ttl为0时不过期
+	Set(ctx context.Context, key, value string, ttl time.Duration) error
+	Del(ctx context.Context, key string) error
+	// Incr 计数加一并返回新值，每次递增都将过期时间重置为ttl
+	Incr(ctx context.Context, key string, ttl time.Duration) (int64, error)
+	// IncrBy 与Incr相同，增加n
+	IncrBy(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error)
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// 后端类型
+const (
+	BackendRedis  = "redis"
+	BackendMemory = "memory"
+)
+
+// Redis部署模式
+const (
+	ModeStandalone = "standalone"
+	ModeSentinel   = "sentinel"
+	ModeCluster    = "cluster"
+)
+
+// Config 存储配置
+type Config struct {
+	Backend string // redis 或 memory
+	File    string // memory后端的持久化文件，为空时只保存在内存中
+	Redis   RedisConfig
+}
+
+// RedisConfig Redis连接配置
+type RedisConfig struct {
+	Mode             string   // standalone、sentinel 或 cluster
+	Addr             string   // 单机地址
+	Addrs            []string // 哨兵或集群节点地址
+	MasterName       string   // 哨兵模式的主节点名称
+	Password         string
+	SentinelPassword string
+	DB               int
+}
+
+// New 按配置创建存储。Redis后端在连接不上时仍然返回，由Fallback暂存到本地内存
+func New(cfg Config) (Store, error) {
+	switch cfg.Backend {
+	case "", BackendRedis:
+		rdb, err := NewRedisClient(cfg.Redis)
+		if err != nil {
+			return nil, err
+		}
+		return NewFallback(NewRedis(rdb), NewMemory()), nil
+	case BackendMemory:
+		if cfg.File == "" {
+			return NewMemory(), nil
+		}
+		return OpenFile(cfg.File)
+	}
+	return nil, fmt.Errorf("unknown store backend: %s", cfg.Backend)
+}
+
+// NewRedisClient 按部署模式创建Redis客户端
+func NewRedisClient(cfg RedisConfig) (redis.UniversalClient, error) {
+	opts := &redis.UniversalOptions{
+		Password:         cfg.Password,
+		SentinelPassword: cfg.SentinelPassword,
+		DB:               cfg.DB,
+		DialTimeout:      5 * time.Second,
+		ReadTimeout:      3 * time.Second,
+		WriteTimeout:     3 * time.Second,
+		PoolSize:         10,
+		MaxRetries:       3,
+	}
+	switch cfg.Mode {
+	case "", ModeStandalone:
+		if cfg.Addr == "" {
+			return nil, errors.New("redis.addr is required")
+		}
+		return redis.NewClient(&redis.Options{
+			Addr:         cfg.Addr,
+			Password:     opts.Password,
+			DB:           opts.DB,
+			DialTimeout:  opts.DialTimeout,
+			ReadTimeout:  opts.ReadTimeout,
+			WriteTimeout: opts.WriteTimeout,
+			PoolSize:     opts.PoolSize,
+			MaxRetries:   opts.MaxRetries,
+		}), nil
+	case ModeSentinel:
+		if cfg.MasterName == "" || len(cfg.Addrs) == 0 {
+			return nil, errors.New("redis.master_name and redis.addrs are required in sentinel mode")
+		}
+		opts.Addrs = cfg.Addrs
+		opts.MasterName = cfg.MasterName
+		return redis.NewFailoverClient(opts.Failover()), nil
+	case ModeCluster:
+		if len(cfg.Addrs) == 0 {
+			return nil, errors.New("redis.addrs is required in cluster mode")
+		}
+		opts.Addrs = cfg.Addrs
+		return redis.NewClusterClient(opts.Cluster()), nil
+	}
+	return nil, fmt.Errorf("unknown redis mode: %s", cfg.Mode)
+}
+
+// RedisClient 返回存储使用的Redis客户端，非Redis后端返回false
+func RedisClient(s Store) (redis.UniversalClient, bool) {
+	switch v := s.(type) {
+	case *Redis:
+		return v.rdb, true
+	case *Fallback:
+		return RedisClient(v.primary)
+	}
+	return nil, false
+}
@@ -0,0 +1,202 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+	file := filepath.Join(t.TempDir(), "state.json")
+	m, err := OpenFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Set(ctx, "user:13800000000", "123456", time.Minute)
+	m.Set(ctx, "expired", "x", time.Nanosecond)
+	m.Set(ctx, "deleted", "x", 0)
+	m.Del(ctx, "deleted")
+	time.Sleep(time.Millisecond)
+
+	// 修改先保存在内存中，定时任务或关闭时才写入快照
+	if _, err := os.Stat(file); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("snapshot written before interval: %v", err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重新打开后从快照恢复
+	m, err = OpenFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := m.Get(ctx, "user:13800000000"); err != nil || v != "123456" {
+		t.Errorf("get after reopen: %q %v", v, err)
+	}
+	for _, key := range []string{"expired", "deleted", "missing"} {
+		if _, err := m.Get(ctx, key); err != ErrNotFound {
+			t.Errorf("get %s: expected ErrNotFound, got %v", key, err)
+		}
+	}
+
+	for want := int64(1); want <= 3; want++ {
+		if n, err := m.Incr(ctx, "fail:user:alice", time.Minute); err != nil || n != want {
+			t.Errorf("incr: %d %v, want %d", n, err, want)
+		}
+	}
+	if n, err := m.IncrBy(ctx, "fail:user:alice", 60, time.Minute); err != nil || n != 63 {
+		t.Errorf("incrby: %d %v", n, err)
+	}
+	m.Incr(ctx, "fail:expired", time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if n, _ := m.Incr(ctx, "fail:expired", time.Minute); n != 1 {
+		t.Errorf("incr after expiry: %d", n)
+	}
+}
+
+func TestMemorySnapshotInterval(t *testing.T) {
+	ctx := context.Background()
+	file := filepath.Join(t.TempDir(), "state.json")
+	m, err := OpenFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	m.Set(ctx, "user:13800000000", "123456", time.Minute)
+	time.Sleep(snapshotInterval + 500*time.Millisecond)
+
+	r, err := OpenFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if v, err := r.Get(ctx, "user:13800000000"); err != nil || v != "123456" {
+		t.Errorf("get from periodic snapshot: %q %v", v, err)
+	}
+}
+
+// broken 模拟不可用的Redis，记录访问次数
+type broken struct {
+	*Memory
+	down  atomic.Bool
+	calls atomic.Int64
+}
+
+var errDown = errors.New("connection refused")
+
+func (b *broken) Get(ctx context.Context, key string) (string, error) {
+	b.calls.Add(1)
+	if b.down.Load() {
+		return "", errDown
+	}
+	return b.Memory.Get(ctx, key)
+}
+
+func (b *broken) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	b.calls.Add(1)
+	if b.down.Load() {
+		return errDown
+	}
+	return b.Memory.Set(ctx, key, value, ttl)
+}
+
+func (b *broken) Ping(ctx context.Context) error {
+	if b.down.Load() {
+		return errDown
+	}
+	return nil
+}
+
+func TestFallback(t *testing.T) {
+	ctx := context.Background()
+	primary := &broken{Memory: NewMemory()}
+	f := NewFallback(primary, NewMemory())
+
+	primary.Set(ctx, "mac:aabbccddeeff", "alice", 0)
+	primary.down.Store(true)
+	if err := f.Set(ctx, "user:13800000000", "123456", time.Minute); err != nil {
+		t.Fatalf("set while degraded: %v", err)
+	}
+	if !f.Degraded() {
+		t.Error("expected degraded after primary failure")
+	}
+	if v, err := f.Get(ctx, "user:13800000000"); err != nil || v != "123456" {
+		t.Errorf("get while degraded: %q %v", v, err)
+	}
+	if _, err := f.Get(ctx, "mac:aabbccddeeff"); err != errDown {
+		t.Errorf("get unknown key while degraded: expected primary error, got %v", err)
+	}
+
+	primary.down.Store(false)
+	if err := f.Ping(ctx); err != nil || f.Degraded() {
+		t.Errorf("expected recovery, err=%v degraded=%v", err, f.Degraded())
+	}
+	if v, _ := f.Get(ctx, "mac:aabbccddeeff"); v != "alice" {
+		t.Errorf("get after recovery: %q", v)
+	}
+}
+
+// 降级期间跳过主存储，由后台探测恢复
+func TestFallbackProbe(t *testing.T) {
+	ctx := context.Background()
+	primary := &broken{Memory: NewMemory()}
+	f := NewFallback(primary, NewMemory())
+	f.interval = 20 * time.Millisecond
+	defer f.Close()
+
+	primary.down.Store(true)
+	f.Set(ctx, "user:13800000000", "123456", time.Minute)
+	calls := primary.calls.Load()
+	for i := 0; i < 10; i++ {
+		f.Set(ctx, "user:13800000000", "123456", time.Minute)
+		if _, err := f.Get(ctx, "missing"); err != errDown {
+			t.Errorf("get while degraded: expected last primary error, got %v", err)
+		}
+	}
+	if n := primary.calls.Load() - calls; n != 0 {
+		t.Errorf("primary accessed %d times while degraded", n)
+	}
+
+	primary.down.Store(false)
+	deadline := time.Now().Add(time.Second)
+	for f.Degraded() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if f.Degraded() {
+		t.Fatal("probe did not recover")
+	}
+	f.Set(ctx, "mac:aabbccddeeff", "alice", 0)
+	if v, _ := primary.Memory.Get(ctx, "mac:aabbccddeeff"); v != "alice" {
+		t.Errorf("write after recovery did not reach primary: %q", v)
+	}
+}
+
+func TestNewRedisClient(t *testing.T) {
+	cases := []struct {
+		cfg RedisConfig
+		ok  bool
+	}{
+		{RedisConfig{Addr: "localhost:6379"}, true},
+		{RedisConfig{Mode: ModeSentinel, Addrs: []string{"localhost:26379"}, MasterName: "mymaster"}, true},
+		{RedisConfig{Mode: ModeSentinel, Addrs: []string{"localhost:26379"}}, false},
+		{RedisConfig{Mode: ModeCluster, Addrs: []string{"localhost:7000"}}, true},
+		{RedisConfig{Mode: ModeCluster}, false},
+		{RedisConfig{Mode: "ring"}, false},
+	}
+	for _, c := range cases {
+		rdb, err := NewRedisClient(c.cfg)
+		if (err == nil) != c.ok {
+			t.Errorf("%+v: err=%v", c.cfg, err)
+		}
+		if rdb != nil {
+			rdb.Close()
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const (
+	CookieName = "syler_session"
+	CSRFHeader = "X-CSRF-Token"
+	CSRFField  = "csrf_token"
+)
+
+var (
+	ErrNoSession = errors.New("missing or invalid session cookie")
+	ErrCSRF      = errors.New("invalid CSRF token")
+)
+
+var (
+	keyLock   sync.RWMutex
+	cookieKey = randomBytes(32)
+)
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	rand.Read(b)
+	return b
+}
+
+// SetCookieKey 设置会话Cookie和CSRF令牌的签名密钥，集群各节点需使用相同的密钥。
+// 未设置时使用进程启动时生成的随机密钥
+func SetCookieKey(key string) {
+	keyLock.Lock()
+	defer keyLock.Unlock()
+	cookieKey = []byte(key)
+}
+
+func mac(parts ...string) string {
+	keyLock.RLock()
+	h := hmac.New(sha256.New, cookieKey)
+	keyLock.RUnlock()
+	h.Write([]byte(strings.Join(parts, "|")))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// SessionID 返回请求中有效的会话Cookie对应的会话ID
+func SessionID(r *http.Request) (string, bool) {
+	c, err := r.Cookie(CookieName)
+	if err != nil {
+		return "", false
+	}
+	id, sig, ok := strings.Cut(c.Value, ".")
+	if !ok || id == "" || !hmac.Equal([]byte(sig), []byte(mac("session", id))) {
+		return "", false
+	}
+	return id, true
+}
+
+// Session 返回浏览器的会话ID，没有有效的Cookie时签发新的会话Cookie
+func Session(w http.ResponseWriter, r *http.Request) string {
+	if id, ok := SessionID(r); ok {
+		return id
+	}
+	id := base64.RawURLEncoding.EncodeToString(randomBytes(16))
+	http.SetCookie(w, &http.Cookie{
+		Name:     CookieName,
+		Value:    id + "." + mac("session", id),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return id
+}
+
+// CSRFToken 会话对应的CSRF令牌，页面在请求头X-CSRF-Token或表单字段csrf_token中提交
+func CSRFToken(id string) string {
+	return mac("csrf", id)
+}
+
+// VerifyCSRF 校验请求带有有效的会话Cookie和匹配的CSRF令牌
+func VerifyCSRF(r *http.Request) error {
+	id, ok := SessionID(r)
+	if !ok {
+		return ErrNoSession
+	}
+	token := r.Header.Get(CSRFHeader)
+	if token == "" {
+		token = r.FormValue(CSRFField)
+	}
+	if !hmac.Equal([]byte(token), []byte(CSRFToken(id))) {
+		return ErrCSRF
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package web
+
+import "net"
+
+// Theme 认证页面的品牌配置，为空的字段使用默认主题的值
+type Theme struct {
+	Name         string   `mapstructure:"name"`          // 主题目录名，对应 <theme_dir>/<name>/
+	Title        string   `mapstructure:"title"`         // 页面标题
+	Logo         string   `mapstructure:"logo"`          // Logo地址，如 /themes/mall/logo.png
+	PrimaryColor string   `mapstructure:"primary_color"` // 按钮颜色
+	Background   string   `mapstructure:"background"`    // 背景图片地址
+	Terms        string   `mapstructure:"terms"`         // 服务条款，非空时需勾选同意后才能登录
+	Methods      []string `mapstructure:"methods"`       // 启用的认证方式：sms、chap
+}
+
+// Site 按NAS、SSID或站点名选择主题，条件为空表示不限
+type Site struct {
+	NasIP string `mapstructure:"nasip"`
+	SSID  string `mapstructure:"ssid"`
+	Site  string `mapstructure:"site"`
+	Theme Theme  `mapstructure:"theme"`
+}
+
+var defaultTheme = Theme{
+	Title:   "WiFi 登录",
+	Methods: []string{"sms", "chap"},
+}
+
+// Enabled 是否启用了认证方式
+func (t Theme) Enabled(method string) bool {
+	for _, m := range t.Methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// merge 用o中非空的字段覆盖t
+func (t Theme) merge(o Theme) Theme {
+	if o.Name != "" {
+		t.Name = o.Name
+	}
+	if o.Title != "" {
+		t.Title = o.Title
+	}
+	if o.Logo != "" {
+		t.Logo = o.Logo
+	}
+	if o.PrimaryColor != "" {
+		t.PrimaryColor = o.PrimaryColor
+	}
+	if o.Background != "" {
+		t.Background = o.Background
+	}
+	if o.Terms != "" {
+		t.Terms = o.Terms
+	}
+	if len(o.Methods) > 0 {
+		t.Methods = o.Methods
+	}
+	return t
+}
+
+func (s Site) match(nasip, ssid, site string) bool {
+	if s.NasIP != "" && !net.ParseIP(s.NasIP).Equal(net.ParseIP(nasip)) {
+		return false
+	}
+	if s.SSID != "" && s.SSID != ssid {
+		return false
+	}
+	if s.Site != "" && s.Site != site {
+		return false
+	}
+	return true
+}
+
+// Select 返回第一个匹配的站点主题，没有匹配时返回默认主题
+func (c *Config) Select(nasip, ssid, site string) Theme {
+	t := defaultTheme.merge(c.Theme)
+	for _, s := range c.Sites {
+		if s.match(nasip, ssid, site) {
+			return t.merge(s.Theme)
+		}
+	}
+	return t
+}
@@ -0,0 +1,122 @@
+// Package web 提供内置的认证页面，按NAS、SSID或站点渲染不同的品牌主题
+package web
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"syler/internal/logger"
+	"syler/www"
+)
+
+// Config 页面配置
+type Config struct {
+	ThemeDir string `mapstructure:"theme_dir"` // 主题目录，其中的文件覆盖内置页面
+	Theme    Theme  `mapstructure:"theme"`     // 默认主题
+	Sites    []Site `mapstructure:"sites"`     // 按顺序匹配的站点主题
+}
+
+// Handler 渲染认证页面并提供静态文件。文件依次从
+// <theme_dir>/<theme>/、<theme_dir>/ 和内置页面中查找
+type Handler struct {
+	cfg      Config
+	embedded fs.FS
+}
+
+func New(cfg Config) *Handler {
+	embedded, _ := fs.Sub(www.Portal, "portal")
+	return &Handler{cfg: cfg, embedded: embedded}
+}
+
+// overlay 按顺序查找文件的多层文件系统
+type overlay []fs.FS
+
+func (o overlay) Open(name string) (fs.File, error) {
+	for _, fsys := range o {
+		f, err := fsys.Open(name)
+		if err == nil {
+			return f, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+func (h *Handler) files(theme string) fs.FS {
+	var o overlay
+	if h.cfg.ThemeDir != "" {
+		if theme != "" && theme == filepath.Base(theme) {
+			o = append(o, os.DirFS(filepath.Join(h.cfg.ThemeDir, theme)))
+		}
+		o = append(o, os.DirFS(h.cfg.ThemeDir))
+	}
+	return append(o, h.embedded)
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch p := r.URL.Path; {
+	case p == "/" || p == "/portal" || p == "/index.html":
+		h.render(w, r)
+	case strings.HasPrefix(p, "/themes/") && h.cfg.ThemeDir != "":
+		h.serveFile(w, r, os.DirFS(h.cfg.ThemeDir), strings.TrimPrefix(p, "/themes/"))
+	case strings.HasPrefix(p, "/assets/"):
+		h.serveFile(w, r, h.files(""), strings.TrimPrefix(p, "/"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveFile 提供静态文件，不列出目录
+func (h *Handler) serveFile(w http.ResponseWriter, r *http.Request, fsys fs.FS, name string) {
+	name = path.Clean(name)
+	if !fs.ValidPath(name) {
+		http.NotFound(w, r)
+		return
+	}
+	if st, err := fs.Stat(fsys, name); err != nil || st.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFileFS(w, r, fsys, name)
+}
+
+// page 渲染index.html的数据
+type page struct {
+	Theme
+	CSRFToken string
+}
+
+// render 按请求参数选择主题渲染index.html，并签发会话Cookie
+func (h *Handler) render(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	theme := h.cfg.Select(q.Get("nasip"), q.Get("ssid"), q.Get("site"))
+
+	var buf bytes.Buffer
+	tmpl, err := template.ParseFS(h.files(theme.Name), "index.html")
+	if err == nil {
+		err = tmpl.Execute(&buf, page{Theme: theme, CSRFToken: CSRFToken(Session(w, r))})
+	}
+	if err != nil {
+		logger.WithRequest(r).WithFields(logrus.Fields{
+			"error": err,
+			"theme": theme.Name,
+		}).Error("Failed to render portal page")
+		http.Error(w, "页面加载失败", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	buf.WriteTo(w)
+}
@@ -0,0 +1,89 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func get(h http.Handler, url string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+	return w
+}
+
+func TestRender(t *testing.T) {
+	h := New(Config{
+		Theme: Theme{Title: "景枫 WiFi 登录", PrimaryColor: "#2b6cb0"},
+		Sites: []Site{
+			{NasIP: "192.168.10.3", SSID: "Mall", Theme: Theme{Title: "商场免费WiFi", Methods: []string{"chap"}, Terms: "禁止<违法>用途"}},
+		},
+	})
+
+	w := get(h, "/portal?nasip=192.168.10.1&ssid=Mall")
+	body := w.Body.String()
+	if w.Code != http.StatusOK || !strings.Contains(body, "<title>景枫 WiFi 登录</title>") {
+		t.Fatalf("default theme: %d\n%s", w.Code, body)
+	}
+	if !strings.Contains(body, "--primary-color: #2b6cb0") || !strings.Contains(body, "getCodeButton") {
+		t.Errorf("default theme missing color or sms button:\n%s", body)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Cookie", w.Header().Get("Set-Cookie"))
+	id, ok := SessionID(r)
+	if !ok || !strings.Contains(body, `<meta name="csrf-token" content="`+CSRFToken(id)+`">`) {
+		t.Errorf("session cookie or CSRF token missing: %q", w.Header().Get("Set-Cookie"))
+	}
+
+	body = get(h, "/?nasip=192.168.10.3&ssid=Mall").Body.String()
+	if !strings.Contains(body, "<title>商场免费WiFi</title>") {
+		t.Errorf("site theme not selected:\n%s", body)
+	}
+	if strings.Contains(body, "getCodeButton") {
+		t.Error("sms button rendered with sms disabled")
+	}
+	if !strings.Contains(body, "禁止&lt;违法&gt;用途") || !strings.Contains(body, `id="terms"`) {
+		t.Errorf("terms not rendered or not escaped:\n%s", body)
+	}
+	if !strings.Contains(body, "--primary-color: #2b6cb0") {
+		t.Error("site theme should inherit default color")
+	}
+}
+
+func TestThemeDir(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "mall"), 0755)
+	os.WriteFile(filepath.Join(dir, "mall", "index.html"), []byte("<h1>{{.Title}}</h1>"), 0644)
+	os.WriteFile(filepath.Join(dir, "mall", "logo.png"), []byte("png"), 0644)
+	os.MkdirAll(filepath.Join(dir, "assets", "css"), 0755)
+	os.WriteFile(filepath.Join(dir, "assets", "css", "style.css"), []byte("body{}"), 0644)
+
+	h := New(Config{
+		ThemeDir: dir,
+		Sites:    []Site{{Site: "mall", Theme: Theme{Name: "mall", Title: "Mall"}}},
+	})
+
+	if body := get(h, "/?site=mall").Body.String(); body != "<h1>Mall</h1>" {
+		t.Errorf("theme template not used: %q", body)
+	}
+	if body := get(h, "/").Body.String(); !strings.Contains(body, "loginForm") {
+		t.Error("default page should come from embedded files")
+	}
+	if body := get(h, "/assets/css/style.css").Body.String(); body != "body{}" {
+		t.Errorf("asset override not used: %q", body)
+	}
+	if w := get(h, "/assets/js/main.js"); w.Code != http.StatusOK {
+		t.Errorf("embedded asset: %d", w.Code)
+	}
+	if body := get(h, "/themes/mall/logo.png").Body.String(); body != "png" {
+		t.Errorf("theme file: %q", body)
+	}
+	for _, url := range []string{"/assets/", "/themes/../../etc/passwd", "/missing"} {
+		if w := get(h, url); w.Code != http.StatusNotFound {
+			t.Errorf("%s: got %d, want 404", url, w.Code)
+		}
+	}
+}
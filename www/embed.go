@@ -0,0 +1,7 @@
+// Package www 内置的认证页面，编译进syler二进制，可被主题目录中的同名文件覆盖
+package www
+
+import "embed"
+
+//go:embed portal
+var Portal embed.FS
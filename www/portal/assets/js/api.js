@@ -1,48 +1,69 @@
 const API = {
     baseURL: '/api',
+    csrfToken: '',
 
-    async login(data) {
-        try {
-            console.log('Sending login request:', data);
-            const response = await fetch(this.baseURL + '/login', {
-                method: 'POST',
-                headers: {
-                    'Content-Type': 'application/x-www-form-urlencoded',
-                    'Accept': 'application/json',
-                    'Referer': window.location.origin + '/portal'
-                },
-                body: new URLSearchParams(data)
-            });
-
-            const result = await response.json();
-            if (!response.ok) {
-                throw new Error(result.message || '登录失败');
+    // 内置页面的CSRF令牌在meta中，nginx提供的页面从/api/status获取
+    getCSRFToken() {
+        if (!this.csrfToken) {
+            const meta = document.querySelector('meta[name="csrf-token"]');
+            const token = meta ? meta.getAttribute('content') : '';
+            if (token && !token.startsWith('{{')) {
+                this.csrfToken = token;
             }
-            return result;
-        } catch (error) {
-            throw error;
         }
+        return this.csrfToken;
+    },
+
+    async post(path, body, contentType, errorMessage) {
+        const response = await fetch(this.baseURL + path, {
+            method: 'POST',
+            credentials: 'same-origin',
+            headers: {
+                'Content-Type': contentType,
+                'Accept': 'application/json',
+                'X-CSRF-Token': this.getCSRFToken()
+            },
+            body: body
+        });
+
+        const result = await response.json();
+        if (!response.ok) {
+            throw new Error(result.message || errorMessage);
+        }
+        return result;
+    },
+
+    async login(data) {
+        return this.post('/login', new URLSearchParams(data), 'application/x-www-form-urlencoded', '登录失败');
     },
 
     async logout(data) {
-        try {
-            const response = await fetch(this.baseURL + '/logout', {
-                method: 'POST',
-                headers: {
-                    'Content-Type': 'application/x-www-form-urlencoded',
-                    'Accept': 'application/json',
-                    'Referer': window.location.origin + '/portal'
-                },
-                body: new URLSearchParams(data)
-            });
-
-            const result = await response.json();
-            if (!response.ok) {
-                throw new Error(result.message || '登出失败');
-            }
-            return result;
-        } catch (error) {
-            throw error;
+        return this.post('/logout', new URLSearchParams(data), 'application/x-www-form-urlencoded', '登出失败');
+    },
+
+    // 刷新活动时间，hmac方式下需带登录时返回的令牌
+    async heartbeat(userip, token) {
+        return this.post('/heartbeat', new URLSearchParams({ userip, token }), 'application/x-www-form-urlencoded', '心跳失败');
+    },
+
+    async sendCode(phone) {
+        return this.post('/sendcode', JSON.stringify({ phone }), 'application/json', '获取失败，请稍后再试');
+    },
+
+    // 查询用户的在线状态，同时取得CSRF令牌
+    async status(userip) {
+        const query = userip ? '?userip=' + encodeURIComponent(userip) : '';
+        const response = await fetch(this.baseURL + '/status' + query, {
+            credentials: 'same-origin',
+            headers: { 'Accept': 'application/json' }
+        });
+        const result = await response.json();
+        if (!response.ok) {
+            throw new Error(result.message || '查询状态失败');
+        }
+        if (result.data && result.data.csrf_token) {
+            this.csrfToken = result.data.csrf_token;
         }
+        return result.data;
     }
-};
\ No newline at end of file
+};
@@ -1,25 +1,24 @@
-document.addEventListener('DOMContentLoaded', () => {
+document.addEventListener('DOMContentLoaded', async () => {
     // 初始化参数
     const nasip = Utils.getQueryParam('nasip');
     const userip = Utils.getQueryParam('userip');
     const usermac = Utils.getQueryParam('usermac');
 
-    if (!nasip || !userip || !usermac) {
+    // 设置隐藏字段
+    document.getElementById('nasip').value = nasip || '';
+    document.getElementById('userip').value = userip || '';
+    document.getElementById('usermac').value = usermac || '';
+    // NAS签名的重定向参数，未启用签名时为空
+    document.getElementById('ts').value = Utils.getQueryParam('ts') || '';
+    document.getElementById('sign').value = Utils.getQueryParam('sign') || '';
+
+    // 检查登录状态，已登录时不再要求重定向参数
+    const online = await Utils.checkLoginStatus(userip);
+    if (!online && (!nasip || !userip)) {
         Utils.showMessage('缺少必要参数');
         return;
     }
 
-    // 设置隐藏字段
-    document.getElementById('nasip').value = nasip;
-    document.getElementById('userip').value = userip;
-    document.getElementById('usermac').value = usermac;
-    localStorage.setItem('nasip', nasip);
-    localStorage.setItem('userip', userip);
-    localStorage.setItem('usermac', usermac);
-
-    // 检查登录状态
-    Utils.checkLoginStatus();
-
     // 登录表单提交处理
     const loginForm = document.getElementById('loginForm');
     const loginButton = document.getElementById('loginButton');
@@ -31,13 +30,11 @@ document.addEventListener('DOMContentLoaded', () => {
         try {
             const formData = new FormData(loginForm);
             const data = Object.fromEntries(formData.entries());
-            console.log('Form data to be sent:', data);
 
             const result = await API.login(data);
+            // 页面刷新后心跳仍需使用登录令牌
+            Utils.saveToken(result.data && result.data.token);
             Utils.showMessage(result.message, 'success');
-
-            // 保存登录信息并更新界面
-            Utils.saveLoginData(result.data);
             Utils.toggleAuthSection(true, result.data);
 
             // 更新浏览器历史状态，使移动设备显示"完成"而不是"取消"
@@ -51,11 +48,11 @@ document.addEventListener('DOMContentLoaded', () => {
         }
     });
 
-    // 获取验证码按钮处理
+    // 获取验证码按钮处理，未启用短信认证时页面不含该按钮
     const getCodeButton = document.getElementById('getCodeButton');
     let countdown = 0;
 
-    getCodeButton.addEventListener('click', async () => {
+    getCodeButton && getCodeButton.addEventListener('click', async () => {
         if (countdown > 0) {
             Utils.showMessage(`请等待 ${countdown} 秒后重试`, 'info');
             return;
@@ -81,23 +78,10 @@ document.addEventListener('DOMContentLoaded', () => {
         }, 1000);
 
         try {
-            const response = await fetch('/api/sendcode', {
-                method: 'POST',
-                headers: {
-                    'Content-Type': 'application/json',
-                    'Referer': window.location.origin + '/portal'
-                },
-                body: JSON.stringify({ phone })
-            });
-
-            if (response.ok) {
-                Utils.showMessage('验证码发送成功，请注意查收，5分钟有效', 'success');
-            } else {
-                const result = await response.json();
-                Utils.showMessage(result.message || '获取失败，请稍后再试', 'error');
-            }
+            await API.sendCode(phone);
+            Utils.showMessage('验证码发送成功，请注意查收，5分钟有效', 'success');
         } catch (error) {
-            Utils.showMessage('网络错误，请检查连接', 'error');
+            Utils.showMessage(error.message || '网络错误，请检查连接', 'error');
         }
     });
 
@@ -106,21 +90,21 @@ document.addEventListener('DOMContentLoaded', () => {
 
     logoutButton.addEventListener('click', async () => {
         try {
+            const status = await API.status(userip);
             const data = {
-                nasip: localStorage.getItem('nasip'),
-                userip: localStorage.getItem('userip'),
-                usermac: localStorage.getItem('usermac')
+                nasip: status.nasip || nasip,
+                userip: status.userip || userip,
+                usermac: status.usermac || usermac
             };
 
             const result = await API.logout(data);
             Utils.showMessage(result.message, 'success');
-
-            // 清除登录信息并更新界面
-            Utils.clearLoginData();
+            Utils.stopHeartbeat();
+            Utils.saveToken('');
             Utils.toggleAuthSection(false);
 
         } catch (error) {
             Utils.showMessage(error.message);
         }
     });
-});
\ No newline at end of file
+});
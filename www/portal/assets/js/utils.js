@@ -1,4 +1,6 @@
 const Utils = {
+    heartbeatTimer: null,
+
     getQueryParam(param) {
         const urlParams = new URLSearchParams(window.location.search);
         return urlParams.get(param);
@@ -15,6 +17,16 @@ const Utils = {
         }, 3000);
     },
 
+    formatBytes(n) {
+        const units = ['B', 'KB', 'MB', 'GB', 'TB'];
+        let i = 0;
+        while (n >= 1024 && i < units.length - 1) {
+            n /= 1024;
+            i++;
+        }
+        return `${n.toFixed(i ? 1 : 0)} ${units[i]}`;
+    },
+
     // 切换登录/登出界面
     toggleAuthSection(isLoggedIn, userData) {
         const loginSection = document.getElementById('loginSection');
@@ -27,41 +39,80 @@ const Utils = {
             // 更新用户信息显示
             document.getElementById('displayUsername').textContent = userData.username || '';
             document.getElementById('displayUserIP').textContent = userData.userip || '';
+            document.getElementById('displayOnline').textContent = userData.online_time || '';
             document.getElementById('displayTimeout').textContent = userData.timeout || '';
+            document.getElementById('displayFlux').textContent =
+                userData.up_flux === undefined ? '' :
+                    `上行 ${this.formatBytes(userData.up_flux)} / 下行 ${this.formatBytes(userData.down_flux)}`;
         } else {
             logoutSection.classList.add('hidden');
             loginSection.classList.remove('hidden');
         }
     },
 
-    // 检查登录状态
-    checkLoginStatus() {
-        const isLoggedIn = localStorage.getItem('isLoggedIn') === 'true';
-        if (isLoggedIn) {
-            const userData = {
-                username: localStorage.getItem('username'),
-                userip: localStorage.getItem('userip'),
-                timeout: localStorage.getItem('timeout')
-            };
-            this.toggleAuthSection(true, userData);
+    // 以服务端的在线状态为准切换登录/登出界面
+    async checkLoginStatus(userip) {
+        try {
+            const status = await API.status(userip);
+            if (status.online) {
+                this.toggleAuthSection(true, status);
+                this.startHeartbeat(status);
+            }
+            return status.online;
+        } catch (error) {
+            console.error('Status query failed:', error);
+            return false;
         }
-        return isLoggedIn;
     },
 
-    // 保存登录信息
-    saveLoginData(data) {
-        localStorage.setItem('isLoggedIn', 'true');
-        localStorage.setItem('username', data.username);
-        localStorage.setItem('userip', data.userip);
-        localStorage.setItem('timeout', data.timeout);
+    saveToken(token) {
+        try {
+            if (token) {
+                sessionStorage.setItem('syler_token', token);
+            } else {
+                sessionStorage.removeItem('syler_token');
+            }
+        } catch (error) {
+            console.error('Session storage unavailable:', error);
+        }
     },
 
-    // 清除登录信息
-    clearLoginData() {
-        localStorage.removeItem('isLoggedIn');
-        localStorage.removeItem('username');
-        localStorage.removeItem('userip');
-        localStorage.removeItem('timeout');
+    loadToken() {
+        try {
+            return sessionStorage.getItem('syler_token') || '';
+        } catch (error) {
+            return '';
+        }
+    },
+
+    // NAS配置了空闲检测时，页面打开期间按空闲时长的三分之一发送心跳，不发送NTF_USER_HEARTBEAT的设备依靠它保持在线
+    startHeartbeat(status) {
+        this.stopHeartbeat();
+        const idle = parseInt(status.idle_timeout);
+        if (!idle || !status.userip) {
+            return;
+        }
+        const ms = Math.max(idle / 3, 10) * 1000;
+        this.heartbeatTimer = setInterval(async () => {
+            try {
+                await API.heartbeat(status.userip, this.loadToken());
+            } catch (error) {
+                console.error('Heartbeat failed:', error);
+                // 已被下线时回到登录界面
+                const current = await API.status(status.userip).catch(() => null);
+                if (current && !current.online) {
+                    this.stopHeartbeat();
+                    this.toggleAuthSection(false);
+                }
+            }
+        }, ms);
+    },
+
+    stopHeartbeat() {
+        if (this.heartbeatTimer) {
+            clearInterval(this.heartbeatTimer);
+            this.heartbeatTimer = null;
+        }
     },
 
     startLogoutTimer(timeout) {
@@ -72,8 +123,8 @@ const Utils = {
     },
 
     async handleAutoLogout() {
-        const nasip = localStorage.getItem('nasip');
-        const userip = localStorage.getItem('userip');
+        const nasip = document.getElementById('nasip').value;
+        const userip = document.getElementById('userip').value;
 
         try {
             await API.logout({ nasip, userip });